@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-chi/chi"
+)
+
+func registerArchiveRoutes(todosRouter chi.Router) {
+	todosRouter.Post("/archive", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var archived = make(domain.Todos, 0)
+		archived = append(archived, user.ArchiveCompleted(time.Now())...)
+		err := saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		bytes, err := marshalTodoList(r, user, archived)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Post("/{todoID}/archive", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		if !user.ArchiveTodo(todoID, time.Now()) {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found"}},
+			})
+			return
+		}
+		respondArchivedTodo(rw, r, user, user.FindTodo(todoID))
+	})
+	todosRouter.Post("/{todoID}/unarchive", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		if !user.UnarchiveTodo(todoID) {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Archived todo not found"}},
+			})
+			return
+		}
+		respondArchivedTodo(rw, r, user, user.FindTodo(todoID))
+	})
+}
+
+func respondArchivedTodo(rw http.ResponseWriter, r *http.Request, user *domain.User, todo *domain.Todo) {
+	todo.UpdatedAt = time.Now()
+	err := saveUser(r, user)
+	if err != nil {
+		respondSaveError(rw, r, err)
+		return
+	}
+
+	user.Todos.ComputeProgress()
+	bytes, err := json.Marshal(todo)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bytes)
+}
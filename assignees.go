@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/notify"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/eleanorhealth/milo"
+	"github.com/go-chi/chi"
+)
+
+type assigneeInput struct {
+	AssigneeID entityid.ID `json:"assigneeId"`
+}
+
+// validateTodoAssignee checks that a todo is assigned to someone who can see
+// its project: the owner or one of the project's members.
+func validateTodoAssignee(owner *domain.User, todo *domain.Todo) []ErrorResponseError {
+	if todo.AssigneeID == "" || todo.AssigneeID == owner.ID {
+		return nil
+	}
+	if shareStore != nil {
+		if _, err := shareStore.Membership(context.Background(), todo.ProjectID, todo.AssigneeID); err == nil {
+			return nil
+		}
+	}
+	return []ErrorResponseError{{Message: "assignee must have access to the project", Field: "assigneeId"}}
+}
+
+// sharedAssignedTodos returns the active todos assigned to the signed in user
+// in projects other users share with them, filtered like GET /todos.
+func sharedAssignedTodos(r *http.Request) (domain.Todos, error) {
+	user := requestGetUser(r)
+	if shareStore == nil {
+		return nil, nil
+	}
+	members, err := shareStore.ListAccepted(context.Background(), user.ID, user.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var todos domain.Todos
+	owners := make(map[entityid.ID]*domain.User)
+	for _, member := range members {
+		owner, ok := owners[member.OwnerID]
+		if !ok {
+			var err error
+			owner, err = storage.FindUserInOrg(context.Background(), db, member.OwnerID, user.OrgID)
+			if err != nil {
+				return nil, err
+			}
+			owner.Todos.ComputeProgress()
+			owners[member.OwnerID] = owner
+		}
+
+		projectTodos := owner.ProjectTodos(member.ProjectID)
+		filters, _ := todoListFilters(r, owner)
+		for _, keep := range filters {
+			projectTodos = projectTodos.Filter(keep)
+		}
+		todos = append(todos, projectTodos...)
+	}
+	return todos, nil
+}
+
+// notifyAssignee tells a todo's assignee it was assigned to them, unless they
+// assigned it to themselves.
+func notifyAssignee(r *http.Request, owner *domain.User, todo *domain.Todo) {
+	actor := requestGetUser(r)
+	if todo.AssigneeID == actor.ID {
+		return
+	}
+
+	assignee := owner
+	if todo.AssigneeID != owner.ID {
+		var err error
+		assignee, err = storage.FindUserInOrg(context.Background(), db, todo.AssigneeID, actor.OrgID)
+		if err != nil {
+			if err != milo.ErrNotFound {
+				log.Print(err)
+			}
+			return
+		}
+	}
+
+	err := sendNotification(notify.Notification{
+		UserID:  assignee.ID,
+		Email:   assignee.Email,
+		Subject: "Assigned: " + todo.Title,
+		Body:    fmt.Sprintf("%s assigned %q to you.", actor.Email, todo.Title),
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func registerAssigneeRoutes(todosRouter chi.Router) {
+	todosRouter.Put("/{todoID}/assignee", func(rw http.ResponseWriter, r *http.Request) {
+		var input = assigneeInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil || input.AssigneeID == "" {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "must provide assigneeId", Field: "assigneeId"}},
+			})
+			return
+		}
+		setTodoAssignee(rw, r, input.AssigneeID)
+	})
+	todosRouter.Delete("/{todoID}/assignee", func(rw http.ResponseWriter, r *http.Request) {
+		setTodoAssignee(rw, r, "")
+	})
+}
+
+func setTodoAssignee(rw http.ResponseWriter, r *http.Request, assigneeID entityid.ID) {
+	todoID := entityid.ID(chi.URLParam(r, "todoID"))
+	user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+	if !ok {
+		return
+	}
+	todo := user.FindTodo(todoID)
+	if todo.ID == "" {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Todo not found"}},
+		})
+		return
+	}
+	if !checkIfMatch(rw, r, todo) {
+		return
+	}
+
+	updatedTodo := editableTodo(todo)
+	updatedTodo.AssigneeID = assigneeID
+	if status, errors := updateTodo(r, user, todo, updatedTodo); len(errors) > 0 {
+		respondError(rw, status, ErrorResponse{Errors: errors})
+		return
+	}
+
+	err := saveUser(r, user)
+	if err != nil {
+		respondSaveError(rw, r, err)
+		return
+	}
+
+	user.Todos.ComputeProgress()
+	bytes, err := json.Marshal(todo)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	rw.Header().Set("ETag", todoETag(todo))
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bytes)
+}
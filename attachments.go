@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/blobs"
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var attachmentStore *storage.AttachmentStore
+var blobStore blobs.BlobStore
+
+var maxAttachmentSize int64 = 10 << 20
+var attachmentQuota int64 = 100 << 20
+var attachmentURLLifetime = 5 * time.Minute
+var attachmentURLSecret []byte
+
+// attachmentContentTypes are the types of file that may be attached, as
+// sniffed from their contents.
+var attachmentContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+type attachmentResponse struct {
+	*domain.Attachment
+	// URL downloads the attachment without authentication until
+	// URLExpiresAt.
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"urlExpiresAt"`
+}
+
+// getAttachmentURLSecret returns ATTACHMENT_URL_SECRET, or when it isn't set
+// a key derived from JWT_SECRET, so the key signing download URLs never
+// authenticates sessions as well.
+func getAttachmentURLSecret() []byte {
+	if secret := os.Getenv("ATTACHMENT_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("attachment-urls"))
+	return mac.Sum(nil)
+}
+
+func attachmentSignature(id entityid.ID, expires int64) string {
+	mac := hmac.New(sha256.New, attachmentURLSecret)
+	mac.Write([]byte(id.String() + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAttachmentResponse(attachment *domain.Attachment) attachmentResponse {
+	expiresAt := time.Now().Add(attachmentURLLifetime).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", attachmentSignature(attachment.ID, expiresAt.Unix()))
+	return attachmentResponse{
+		Attachment:   attachment,
+		URL:          "/attachments/" + attachment.ID.String() + "/download?" + query.Encode(),
+		URLExpiresAt: expiresAt,
+	}
+}
+
+// validAttachmentURL reports whether the download URL's signature matches and
+// it hasn't expired.
+func validAttachmentURL(r *http.Request, id entityid.ID) bool {
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	expected := attachmentSignature(id, expires)
+	return hmac.Equal([]byte(expected), []byte(r.URL.Query().Get("signature")))
+}
+
+// maxAttachmentUploadSize is the largest upload body, leaving room for the
+// multipart framing around the file.
+func maxAttachmentUploadSize() int64 {
+	return maxAttachmentSize + 1<<20
+}
+
+// readAttachmentUpload reads the file part of a multipart upload, returning
+// its name and contents. On failure it returns the status to respond with.
+func readAttachmentUpload(rw http.ResponseWriter, r *http.Request) (string, []byte, int, error) {
+	r.Body = http.MaxBytesReader(rw, r.Body, maxAttachmentUploadSize())
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, http.StatusBadRequest, fmt.Errorf("must be a multipart/form-data upload")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, http.StatusBadRequest, fmt.Errorf("must include a file")
+		}
+		if err != nil {
+			return "", nil, http.StatusRequestEntityTooLarge, fmt.Errorf("attachment must be at most %d bytes", maxAttachmentSize)
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(part, maxAttachmentSize+1))
+		part.Close()
+		if err != nil || int64(len(data)) > maxAttachmentSize {
+			return "", nil, http.StatusRequestEntityTooLarge, fmt.Errorf("attachment must be at most %d bytes", maxAttachmentSize)
+		}
+		return part.FileName(), data, http.StatusOK, nil
+	}
+}
+
+// attachmentFilename keeps the base name of an uploaded file's name, which
+// clients may send as a path.
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		name = name[len(name)-255:]
+	}
+	return name
+}
+
+// cleanupAttachments deletes the blobs of attachments whose todo was deleted,
+// then the attachments themselves.
+func cleanupAttachments(ctx context.Context) error {
+	orphaned, err := attachmentStore.Orphaned(ctx, 100)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range orphaned {
+		err := blobStore.Delete(ctx, attachment.Key)
+		if err != nil {
+			return err
+		}
+		err = attachmentStore.Delete(ctx, attachment.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// todoAttachment looks up an attachment on the todo in the URL.
+func todoAttachment(rw http.ResponseWriter, r *http.Request, todo *domain.Todo) (*domain.Attachment, bool) {
+	attachment, err := attachmentStore.Find(context.Background(), entityid.ID(chi.URLParam(r, "attachmentID")))
+	if err == storage.ErrAttachmentNotFound || (err == nil && attachment.TodoID != todo.ID) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Attachment not found"}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	return attachment, true
+}
+
+func registerAttachmentRoutes(todosRouter chi.Router) {
+	todosRouter.Post("/{todoID}/attachments", func(rw http.ResponseWriter, r *http.Request) {
+		user, todo, ok := requestTodo(rw, r, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+
+		filename, data, status, err := readAttachmentUpload(rw, r)
+		if err != nil {
+			respondError(rw, status, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error(), Field: "file"}},
+			})
+			return
+		}
+		contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+		if !attachmentContentTypes[contentType] {
+			respondError(rw, http.StatusUnsupportedMediaType, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "attachments must be PNG, JPEG, GIF or WebP images or PDFs", Field: "file"}},
+			})
+			return
+		}
+
+		used, err := attachmentStore.UsedBytes(context.Background(), user.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if used+int64(len(data)) > attachmentQuota {
+			respondError(rw, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: fmt.Sprintf("attachment quota of %d bytes exceeded", attachmentQuota), Field: "file"}},
+			})
+			return
+		}
+
+		id := entityid.Generator.Generate()
+		attachment := &domain.Attachment{
+			ID:          id,
+			TodoID:      todo.ID,
+			UserID:      user.ID,
+			UploaderID:  requestGetUser(r).ID,
+			Filename:    attachmentFilename(filename),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Key:         "attachments/" + user.ID.String() + "/" + id.String(),
+			CreatedAt:   time.Now(),
+		}
+		err = blobStore.Put(context.Background(), attachment.Key, bytes.NewReader(data), attachment.Size, contentType)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		err = attachmentStore.Create(context.Background(), attachment, attachmentQuota)
+		if err != nil {
+			if err := blobStore.Delete(context.Background(), attachment.Key); err != nil {
+				log.Print(err)
+			}
+			if err == storage.ErrAttachmentQuotaExceeded {
+				respondError(rw, http.StatusRequestEntityTooLarge, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: fmt.Sprintf("attachment quota of %d bytes exceeded", attachmentQuota), Field: "file"}},
+				})
+				return
+			}
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		encoded, err := json.Marshal(newAttachmentResponse(attachment))
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(encoded)
+	})
+	todosRouter.Get("/{todoID}/attachments", func(rw http.ResponseWriter, r *http.Request) {
+		_, todo, ok := requestTodo(rw, r, domain.PermissionView)
+		if !ok {
+			return
+		}
+
+		attachments, err := attachmentStore.List(context.Background(), todo.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		var responses = make([]attachmentResponse, 0, len(attachments))
+		for _, attachment := range attachments {
+			responses = append(responses, newAttachmentResponse(attachment))
+		}
+
+		bytes, err := json.Marshal(responses)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Get("/{todoID}/attachments/{attachmentID}", func(rw http.ResponseWriter, r *http.Request) {
+		_, todo, ok := requestTodo(rw, r, domain.PermissionView)
+		if !ok {
+			return
+		}
+		attachment, ok := todoAttachment(rw, r, todo)
+		if !ok {
+			return
+		}
+
+		bytes, err := json.Marshal(newAttachmentResponse(attachment))
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Delete("/{todoID}/attachments/{attachmentID}", func(rw http.ResponseWriter, r *http.Request) {
+		_, todo, ok := requestTodo(rw, r, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		attachment, ok := todoAttachment(rw, r, todo)
+		if !ok {
+			return
+		}
+
+		err := blobStore.Delete(context.Background(), attachment.Key)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		err = attachmentStore.Delete(context.Background(), attachment.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// registerAttachmentDownloadRoutes serves attachments to anyone holding an
+// unexpired signed URL, so browsers can load them without a token.
+func registerAttachmentDownloadRoutes(r chi.Router) {
+	r.Get("/attachments/{attachmentID}/download", func(rw http.ResponseWriter, r *http.Request) {
+		id := entityid.ID(chi.URLParam(r, "attachmentID"))
+		if !validAttachmentURL(r, id) {
+			respondError(rw, http.StatusForbidden, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Download link is invalid or has expired"}},
+			})
+			return
+		}
+
+		attachment, err := attachmentStore.Find(context.Background(), id)
+		if err == storage.ErrAttachmentNotFound {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Attachment not found"}},
+			})
+			return
+		}
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		blob, err := blobStore.Get(context.Background(), attachment.Key)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		defer blob.Close()
+
+		rw.Header().Set("Content-Type", attachment.ContentType)
+		rw.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(attachmentURLLifetime.Seconds())))
+		rw.WriteHeader(http.StatusOK)
+		io.Copy(rw, blob)
+	})
+}
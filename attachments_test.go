@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func Test_attachmentFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"screenshot.png", "screenshot.png"},
+		{"C:\\Users\\ann\\report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{"  ", "attachment"},
+		{"", "attachment"},
+	}
+
+	for _, test := range tests {
+		if got := attachmentFilename(test.name); got != test.expected {
+			t.Errorf("attachmentFilename(%q) = %q, expected %q", test.name, got, test.expected)
+		}
+	}
+}
+
+func Test_validAttachmentURL(t *testing.T) {
+	attachmentURLSecret = []byte("secret")
+	id := entityid.ID("a")
+	response := newAttachmentResponse(&domain.Attachment{ID: id})
+
+	t.Run("accepts the signed URL", func(t *testing.T) {
+		r := httptest.NewRequest("GET", response.URL, nil)
+		if !validAttachmentURL(r, id) {
+			t.Errorf("validAttachmentURL() = false, expected true")
+		}
+	})
+	t.Run("rejects another attachment", func(t *testing.T) {
+		r := httptest.NewRequest("GET", response.URL, nil)
+		if validAttachmentURL(r, entityid.ID("b")) {
+			t.Errorf("validAttachmentURL() = true, expected false")
+		}
+	})
+	t.Run("rejects expired URLs", func(t *testing.T) {
+		expires := time.Now().Add(-time.Minute).Unix()
+		query := url.Values{}
+		query.Set("expires", strconv.FormatInt(expires, 10))
+		query.Set("signature", attachmentSignature(id, expires))
+		r := httptest.NewRequest("GET", "/attachments/a/download?"+query.Encode(), nil)
+		if validAttachmentURL(r, id) {
+			t.Errorf("validAttachmentURL() = true, expected false")
+		}
+	})
+}
+
+func Test_getAttachmentURLSecret(t *testing.T) {
+	os.Setenv("JWT_SECRET", "jwt")
+	defer os.Unsetenv("JWT_SECRET")
+
+	if secret := getAttachmentURLSecret(); len(secret) == 0 || string(secret) == "jwt" {
+		t.Errorf("getAttachmentURLSecret() = %q, expected a key derived from JWT_SECRET", secret)
+	}
+
+	os.Setenv("ATTACHMENT_URL_SECRET", "urls")
+	defer os.Unsetenv("ATTACHMENT_URL_SECRET")
+	if secret := getAttachmentURLSecret(); string(secret) != "urls" {
+		t.Errorf("getAttachmentURLSecret() = %q, expected ATTACHMENT_URL_SECRET", secret)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-chi/chi"
+	"github.com/ulule/limiter/v3"
+)
+
+var maxBatchOperations = 100
+
+const (
+	batchModeAtomic  = "atomic"
+	batchModePerItem = "per-item"
+)
+
+type batchInput struct {
+	// Mode is atomic, where any failed operation discards the whole batch, or
+	// per-item, where each operation succeeds or fails on its own.
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Op string      `json:"op"`
+	ID entityid.ID `json:"id"`
+	// Version, when set, must match the todo's current version for an update
+	// or delete to apply, like an If-Match header.
+	Version int             `json:"version"`
+	Todo    json.RawMessage `json:"todo"`
+}
+
+type batchResult struct {
+	Op     string               `json:"op"`
+	ID     entityid.ID          `json:"id,omitempty"`
+	Status int                  `json:"status"`
+	Todo   *domain.Todo         `json:"todo,omitempty"`
+	Errors []ErrorResponseError `json:"errors,omitempty"`
+}
+
+type batchResponse struct {
+	Results []*batchResult `json:"results"`
+}
+
+// todoCreationLimit is the part of the todo creation limiter batches use.
+type todoCreationLimit interface {
+	GetIPKey(r *http.Request) string
+	Peek(ctx context.Context, key string) (limiter.Context, error)
+	Get(ctx context.Context, key string) (limiter.Context, error)
+}
+
+func registerBatchRoutes(todosRouter chi.Router, todoCreationLimiter *limiter.Limiter) {
+	todosRouter.Post("/batch", batchHandler(todoCreationLimiter))
+}
+
+func batchHandler(todoCreationLimiter todoCreationLimit) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		// A batch is saved with its owner's todos in one go, so it only
+		// reaches the signed in user's own todos, never shared ones.
+		user := requestGetUser(r)
+
+		var input batchInput
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if input.Mode == "" {
+			input.Mode = batchModeAtomic
+		}
+		if input.Mode != batchModeAtomic && input.Mode != batchModePerItem {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "mode must be one of atomic, per-item", Field: "mode"}},
+			})
+			return
+		}
+		if len(input.Operations) == 0 || len(input.Operations) > maxBatchOperations {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: fmt.Sprintf("a batch must have between 1 and %d operations", maxBatchOperations), Field: "operations"}},
+			})
+			return
+		}
+
+		// Creates count against the todo creation limit as if each had been its
+		// own request. The batch is rejected up front if the creates don't fit
+		// in what remains, and only those that are saved take a token, so a
+		// client isn't charged for todos it didn't get. The pinned limiter can't
+		// give tokens back, so they can't be reserved first: the limit is best
+		// effort, and concurrent batches from one client can each fit in what
+		// remains and together go over it by up to a batch.
+		creates := int64(0)
+		for _, operation := range input.Operations {
+			if operation.Op == "create" {
+				creates++
+			}
+		}
+		if creates > 0 {
+			limit, err := todoCreationLimiter.Peek(r.Context(), todoCreationLimiter.GetIPKey(r))
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			if limit.Reached || limit.Remaining < creates {
+				tooManyRequestsHandler(rw, r)
+				return
+			}
+		}
+
+		response, failed := applyBatch(r, user, input)
+		if failed != nil && input.Mode == batchModeAtomic {
+			bytes, err := json.Marshal(response)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(failed.Status)
+			rw.Write(bytes)
+			return
+		}
+
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+		// The todos are saved by now, so failing to count them doesn't fail
+		// the request.
+		for _, result := range response.Results {
+			if result.Op != "create" || result.Errors != nil {
+				continue
+			}
+			if _, err := todoCreationLimiter.Get(r.Context(), todoCreationLimiter.GetIPKey(r)); err != nil {
+				log.Print(err)
+			}
+		}
+
+		user.Todos.ComputeProgress()
+		bytes, err := json.Marshal(response)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	}
+}
+
+// applyBatch applies the operations of a batch to the user's todos without
+// saving them and returns their results along with the first that failed. In
+// atomic mode it stops at the first failure and reports the other operations
+// as failed dependencies of it.
+func applyBatch(r *http.Request, user *domain.User, input batchInput) (*batchResponse, *batchResult) {
+	response := &batchResponse{}
+	var failed *batchResult
+	for _, operation := range input.Operations {
+		result := applyBatchOperation(r, user, operation)
+		response.Results = append(response.Results, result)
+		if result.Errors != nil && failed == nil {
+			failed = result
+		}
+		if failed != nil && input.Mode == batchModeAtomic {
+			break
+		}
+	}
+
+	if failed != nil && input.Mode == batchModeAtomic {
+		// Nothing is saved, so the operations that did apply are reported as
+		// failed dependencies of the one that didn't.
+		for i, operation := range input.Operations {
+			if i >= len(response.Results) {
+				response.Results = append(response.Results, &batchResult{Op: operation.Op, ID: operation.ID})
+			}
+			if result := response.Results[i]; result != failed {
+				result.Status = http.StatusFailedDependency
+				result.Todo = nil
+				result.Errors = []ErrorResponseError{{Message: "not applied because another operation in the batch failed"}}
+			}
+		}
+	}
+	return response, failed
+}
+
+// applyBatchOperation applies a single operation of a batch to the user's
+// todos without saving them.
+func applyBatchOperation(r *http.Request, user *domain.User, operation batchOperation) *batchResult {
+	result := &batchResult{Op: operation.Op, ID: operation.ID}
+	fail := func(status int, errors ...ErrorResponseError) *batchResult {
+		result.Status = status
+		result.Errors = errors
+		return result
+	}
+
+	var todo *domain.Todo
+	switch operation.Op {
+	case "create":
+	case "update", "delete":
+		todo = user.FindTodo(operation.ID)
+		if todo.ID == "" {
+			return fail(http.StatusNotFound, ErrorResponseError{Message: "Todo not found", Field: "id"})
+		}
+		if operation.Version != 0 && operation.Version != todo.Version {
+			return fail(http.StatusPreconditionFailed, ErrorResponseError{Message: "Todo has been modified", Field: "version"})
+		}
+	default:
+		return fail(http.StatusBadRequest, ErrorResponseError{Message: "op must be one of create, update, delete", Field: "op"})
+	}
+
+	switch operation.Op {
+	case "create":
+		todo = &domain.Todo{}
+		if err := decodeBatchTodo(operation.Todo, todo); err != nil {
+			return fail(http.StatusBadRequest, ErrorResponseError{Message: err.Error(), Field: "todo"})
+		}
+		if errors := createTodo(user, todo); len(errors) > 0 {
+			return fail(http.StatusBadRequest, errors...)
+		}
+		result.ID = todo.ID
+		result.Status = http.StatusCreated
+	case "update":
+		updatedTodo := editableTodo(todo)
+		if err := decodeBatchTodo(operation.Todo, updatedTodo); err != nil {
+			return fail(http.StatusBadRequest, ErrorResponseError{Message: err.Error(), Field: "todo"})
+		}
+		if status, errors := updateTodo(r, user, todo, updatedTodo); len(errors) > 0 {
+			return fail(status, errors...)
+		}
+		result.Status = http.StatusOK
+	case "delete":
+		user.TrashTodo(todo.ID, time.Now())
+		result.Status = http.StatusNoContent
+		return result
+	}
+	result.Todo = todo
+	return result
+}
+
+func decodeBatchTodo(raw json.RawMessage, todo *domain.Todo) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("todo is required")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(todo)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/ulule/limiter/v3"
+)
+
+// countingLimit is a todo creation limit that counts tokens like the limiter
+// does, without expiring them.
+type countingLimit struct {
+	limit, count int64
+}
+
+func (cl *countingLimit) GetIPKey(r *http.Request) string {
+	return "ip"
+}
+
+func (cl *countingLimit) Peek(ctx context.Context, key string) (limiter.Context, error) {
+	if cl.count >= cl.limit {
+		return limiter.Context{Limit: cl.limit, Reached: true}, nil
+	}
+	return limiter.Context{Limit: cl.limit, Remaining: cl.limit - cl.count}, nil
+}
+
+func (cl *countingLimit) Get(ctx context.Context, key string) (limiter.Context, error) {
+	cl.count++
+	if cl.count > cl.limit {
+		return limiter.Context{Limit: cl.limit, Reached: true}, nil
+	}
+	return limiter.Context{Limit: cl.limit, Remaining: cl.limit - cl.count}, nil
+}
+
+func batchUser() *domain.User {
+	return &domain.User{ID: "u", Todos: domain.Todos{
+		{ID: "a", Version: 2},
+		{ID: "b", Version: 1},
+	}}
+}
+
+func Test_applyBatch(t *testing.T) {
+	operations := []batchOperation{
+		{Op: "delete", ID: "a", Version: 2},
+		{Op: "delete", ID: "b", Version: 3},
+		{Op: "delete", ID: "missing"},
+	}
+
+	tests := []struct {
+		mode     string
+		failed   int
+		statuses []int
+	}{
+		{batchModeAtomic, http.StatusPreconditionFailed, []int{http.StatusFailedDependency, http.StatusPreconditionFailed, http.StatusFailedDependency}},
+		{batchModePerItem, http.StatusPreconditionFailed, []int{http.StatusNoContent, http.StatusPreconditionFailed, http.StatusNotFound}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/todos/batch", nil)
+		response, failed := applyBatch(r, batchUser(), batchInput{Mode: test.mode, Operations: operations})
+		if failed == nil || failed.Status != test.failed {
+			t.Errorf("applyBatch(%s) failed = %+v, expected status %d", test.mode, failed, test.failed)
+		}
+		if len(response.Results) != len(test.statuses) {
+			t.Fatalf("applyBatch(%s) = %d results, expected %d", test.mode, len(response.Results), len(test.statuses))
+		}
+		for i, result := range response.Results {
+			if result.Status != test.statuses[i] {
+				t.Errorf("applyBatch(%s) result %d status = %d, expected %d", test.mode, i, result.Status, test.statuses[i])
+			}
+		}
+	}
+}
+
+func Test_batchHandler_limit(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		created int64
+	}{
+		{
+			"more creates than remain",
+			`{"operations":[{"op":"create","todo":{}},{"op":"create","todo":{}},{"op":"create","todo":{}}]}`,
+			http.StatusTooManyRequests,
+			0,
+		},
+		{
+			"failed atomic batch",
+			`{"operations":[{"op":"create","todo":{}},{"op":"delete","id":"missing"}]}`,
+			http.StatusNotFound,
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limit := &countingLimit{limit: 3, count: 1}
+			r := httptest.NewRequest(http.MethodPost, "/todos/batch", strings.NewReader(test.body))
+			r = requestSetUser(r, batchUser())
+			rw := httptest.NewRecorder()
+
+			batchHandler(limit).ServeHTTP(rw, r)
+			if rw.Code != test.status {
+				t.Errorf("status = %d, expected %d: %s", rw.Code, test.status, rw.Body)
+			}
+			// Only creates that are saved take a token, and none were.
+			if created := limit.count - 1; created != test.created {
+				t.Errorf("limiter counted %d creates, expected %d", created, test.created)
+			}
+		})
+	}
+}
+
+func Test_applyBatch_failedUpdateChangesNothing(t *testing.T) {
+	user := &domain.User{
+		ID:       "u",
+		Projects: domain.Projects{{ID: "p1"}, {ID: "p2"}},
+		Todos: domain.Todos{
+			{ID: "a", ProjectID: "p1"},
+			{ID: "b", ProjectID: "p1", ParentID: "a"},
+		},
+	}
+	r := requestSetUser(httptest.NewRequest(http.MethodPost, "/todos/batch", nil), user)
+	operations := []batchOperation{
+		{Op: "update", ID: "a", Todo: []byte(`{"projectId":"p2","assigneeId":"stranger"}`)},
+	}
+
+	response, failed := applyBatch(r, user, batchInput{Mode: batchModePerItem, Operations: operations})
+	if failed == nil || failed.Status != http.StatusBadRequest {
+		t.Fatalf("applyBatch() failed = %+v, expected status %d", failed, http.StatusBadRequest)
+	}
+	if len(response.Results) != 1 {
+		t.Fatalf("applyBatch() = %d results, expected 1", len(response.Results))
+	}
+	for _, todo := range user.Todos {
+		if todo.ProjectID != "p1" || todo.AssigneeID != "" {
+			t.Errorf("todo %s project = %s, assignee = %q, expected it unchanged", todo.ID, todo.ProjectID, todo.AssigneeID)
+		}
+	}
+}
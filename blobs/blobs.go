@@ -0,0 +1,43 @@
+// Package blobs stores the contents of uploaded files, such as todo
+// attachments, by key.
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore keeps blobs by key. Keys are slash separated paths such as
+// "attachments/<id>".
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any blob already
+	// stored there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under key. It returns ErrNotFound if there is
+	// none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not
+	// an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey reports whether key is a relative path without empty, "." or ".."
+// segments, so it can't escape the store.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package blobs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"attachments/abc", true},
+		{"abc", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"attachments/../../etc/passwd", false},
+		{"attachments//abc", false},
+		{"attachments/./abc", false},
+		{`attachments\abc`, false},
+	}
+
+	for _, test := range tests {
+		if got := validKey(test.key); got != test.expected {
+			t.Errorf("validKey(%q) = %v, expected %v", test.key, got, test.expected)
+		}
+	}
+}
+
+// testBlobStore puts, gets and deletes a blob through the store.
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+
+	err := store.Put(ctx, "attachments/a", strings.NewReader("hello"), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	blob, err := store.Get(ctx, "attachments/a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	body, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil || string(body) != "hello" {
+		t.Errorf("Get() = %q, %v, expected %q", body, err, "hello")
+	}
+
+	if err := store.Delete(ctx, "attachments/a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "attachments/a"); err != ErrNotFound {
+		t.Errorf("Get() after Delete() error = %v, expected %v", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, "attachments/a"); err != nil {
+		t.Errorf("Delete() of a missing blob error = %v, expected nil", err)
+	}
+	if err := store.Put(ctx, "../a", strings.NewReader(""), 0, "text/plain"); err != ErrInvalidKey {
+		t.Errorf("Put() with an invalid key error = %v, expected %v", err, ErrInvalidKey)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testBlobStore(t, &LocalStore{Dir: dir})
+}
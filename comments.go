@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/notify"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var commentStore *storage.CommentStore
+
+const maxCommentLength = 10000
+
+type commentInput struct {
+	Body *string `json:"body"`
+}
+
+type commentsResponse struct {
+	Comments []*domain.Comment `json:"comments"`
+	// NextCursor is passed back as ?cursor to get the next page. It is empty
+	// on the last page.
+	NextCursor entityid.ID `json:"nextCursor"`
+}
+
+func decodeCommentBody(r *http.Request) (string, []ErrorResponseError) {
+	var input = commentInput{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&input)
+	if err != nil {
+		return "", []ErrorResponseError{{Message: "invalid input"}}
+	}
+	var body string
+	if input.Body != nil {
+		body = strings.TrimSpace(*input.Body)
+	}
+	if body == "" || len(body) > maxCommentLength {
+		return "", []ErrorResponseError{{Message: fmt.Sprintf("body must be between 1 and %d characters", maxCommentLength), Field: "body"}}
+	}
+	return body, nil
+}
+
+// requestTodo looks up the todo in the URL and the user who owns it, checking
+// the signed in user may work on it with perm.
+func requestTodo(rw http.ResponseWriter, r *http.Request, perm domain.Permission) (*domain.User, *domain.Todo, bool) {
+	todoID := entityid.ID(chi.URLParam(r, "todoID"))
+	user, ok := todoOwner(rw, r, todoID, perm)
+	if !ok {
+		return nil, nil, false
+	}
+	todo := user.FindTodo(todoID)
+	if todo.ID == "" {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Todo not found"}},
+		})
+		return nil, nil, false
+	}
+	return user, todo, true
+}
+
+// ownComment looks up a comment on the todo written by the signed in user.
+func ownComment(rw http.ResponseWriter, r *http.Request, todo *domain.Todo) (*domain.Comment, bool) {
+	comment, err := commentStore.Find(context.Background(), entityid.ID(chi.URLParam(r, "commentID")))
+	if err == storage.ErrCommentNotFound || (err == nil && comment.TodoID != todo.ID) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Comment not found"}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	if comment.AuthorID != requestGetUser(r).ID {
+		respondError(rw, http.StatusForbidden, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Only the author can change a comment"}},
+		})
+		return nil, false
+	}
+	return comment, true
+}
+
+// notifyMentions tells the users mentioned in a comment about it. Only people
+// who can see the todo are notified, and nobody is told twice about the same
+// comment.
+func notifyMentions(r *http.Request, owner *domain.User, todo *domain.Todo, comment *domain.Comment, previousBody string) {
+	author := requestGetUser(r)
+	notified := make(map[string]bool)
+	for _, email := range domain.ParseMentions(previousBody) {
+		notified[email] = true
+	}
+
+	for _, email := range domain.ParseMentions(comment.Body) {
+		if notified[email] || strings.EqualFold(email, author.Email) {
+			continue
+		}
+		// Mentions are lowercased, while addresses are kept as typed.
+		mentionedID, err := storage.FindUserIDByEmail(context.Background(), db, email)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if mentionedID == "" {
+			continue
+		}
+		mentioned, err := storage.FindUserInOrg(context.Background(), db, mentionedID, author.OrgID)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if mentioned.ID != owner.ID {
+			if shareStore == nil {
+				continue
+			}
+			if _, err := shareStore.Membership(context.Background(), todo.ProjectID, mentioned.ID); err != nil {
+				continue
+			}
+		}
+
+		err = sendNotification(notify.Notification{
+			UserID:  mentioned.ID,
+			Email:   mentioned.Email,
+			Subject: "Mentioned on " + todo.Title,
+			Body:    fmt.Sprintf("%s mentioned you on %q:\n\n%s", author.Email, todo.Title, comment.Body),
+		})
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func registerCommentRoutes(todosRouter chi.Router) {
+	todosRouter.Get("/{todoID}/comments", func(rw http.ResponseWriter, r *http.Request) {
+		_, todo, ok := requestTodo(rw, r, domain.PermissionView)
+		if !ok {
+			return
+		}
+
+		limit := 20
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			var err error
+			limit, err = strconv.Atoi(limitParam)
+			if err != nil || limit < 1 || limit > 100 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "limit must be between 1 and 100", Field: "limit"}},
+				})
+				return
+			}
+		}
+		var after *domain.Comment
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			var err error
+			after, err = commentStore.Find(context.Background(), entityid.ID(cursor))
+			if err == storage.ErrCommentNotFound || (err == nil && after.TodoID != todo.ID) {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "invalid cursor", Field: "cursor"}},
+				})
+				return
+			}
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+		}
+
+		// Fetching one extra comment tells whether there is another page.
+		comments, err := commentStore.List(context.Background(), todo.ID, after, limit+1)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		response := commentsResponse{Comments: comments}
+		if len(comments) > limit {
+			response.Comments = comments[:limit]
+			response.NextCursor = comments[limit-1].ID
+		}
+
+		bytes, err := json.Marshal(response)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Post("/{todoID}/comments", func(rw http.ResponseWriter, r *http.Request) {
+		user, todo, ok := requestTodo(rw, r, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		body, errors := decodeCommentBody(r)
+		if len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		comment := &domain.Comment{
+			ID:        entityid.Generator.Generate(),
+			TodoID:    todo.ID,
+			AuthorID:  requestGetUser(r).ID,
+			Body:      body,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		err := commentStore.Create(context.Background(), comment)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		notifyMentions(r, user, todo, comment, "")
+
+		bytes, err := json.Marshal(comment)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+	todosRouter.Put("/{todoID}/comments/{commentID}", func(rw http.ResponseWriter, r *http.Request) {
+		user, todo, ok := requestTodo(rw, r, domain.PermissionView)
+		if !ok {
+			return
+		}
+		comment, ok := ownComment(rw, r, todo)
+		if !ok {
+			return
+		}
+		body, errors := decodeCommentBody(r)
+		if len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		previousBody := comment.Body
+		comment.Body = body
+		comment.UpdatedAt = time.Now()
+		err := commentStore.Update(context.Background(), comment)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		notifyMentions(r, user, todo, comment, previousBody)
+
+		bytes, err := json.Marshal(comment)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Delete("/{todoID}/comments/{commentID}", func(rw http.ResponseWriter, r *http.Request) {
+		_, todo, ok := requestTodo(rw, r, domain.PermissionView)
+		if !ok {
+			return
+		}
+		comment, ok := ownComment(rw, r, todo)
+		if !ok {
+			return
+		}
+
+		err := commentStore.Delete(context.Background(), comment.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,71 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Next(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2021, 9, 1, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2021, 9, 1, 12, 35, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 9, 2, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 9, 5, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 9, 1, 12, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2021, 9, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2021, 9, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 9, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2021, 9, 15, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted.
+		{"0 0 15 * 5", time.Date(2021, 9, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"@every 10m", time.Date(2021, 9, 1, 12, 40, 0, 0, time.UTC)},
+		{"@every 1h", time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", test.spec, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(test.expected) {
+			t.Errorf("Parse(%q).Next() = %v, expected %v", test.spec, got, test.expected)
+		}
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every",
+		"@every soon",
+		"@every 100ms",
+		"@fortnightly",
+	}
+
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) error = nil, expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// ErrTaskRunning is returned when a task can't run because another replica,
+// or an earlier run, is still running it.
+var ErrTaskRunning = errors.New("task is already running")
+
+// ErrUnknownTask is returned when triggering a task that was never added.
+var ErrUnknownTask = errors.New("unknown task")
+
+type Trigger string
+
+const (
+	TriggerSchedule Trigger = "schedule"
+	TriggerManual   Trigger = "manual"
+)
+
+// Task is a piece of recurring work.
+type Task struct {
+	Name string
+	// Spec is the schedule as configured, see Parse.
+	Spec     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+// Run is one run of a task.
+type Run struct {
+	ID      entityid.ID `json:"id"`
+	Task    string      `json:"task"`
+	Trigger Trigger     `json:"trigger"`
+	// ScheduledAt is when the run was due; manual runs are due when
+	// triggered.
+	ScheduledAt time.Time  `json:"scheduledAt"`
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt"`
+	DurationMs  int64      `json:"durationMs"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Store elects the replica that runs a task and keeps the history of runs.
+type Store interface {
+	// Exclusive calls fn unless another replica holds the task's lock,
+	// holding it until fn returns. It reports whether fn was called.
+	Exclusive(ctx context.Context, task string, fn func(ctx context.Context) error) (bool, error)
+	// StartRun records that a run started. It returns false if a scheduled
+	// run of the task for the same time was already recorded.
+	StartRun(ctx context.Context, run *Run) (bool, error)
+	// FinishRun records how a run went.
+	FinishRun(ctx context.Context, run *Run) error
+}
+
+type Scheduler struct {
+	Store Store
+	// Location is the time zone schedules are read in. It defaults to UTC.
+	Location *time.Location
+	Now      func() time.Time
+
+	mu    sync.RWMutex
+	tasks []*Task
+}
+
+// Add parses spec and adds a task that runs fn on that schedule. Add every
+// task before calling Run.
+func (s *Scheduler) Add(name, spec string, fn func(ctx context.Context) error) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, task := range s.tasks {
+		if task.Name == name {
+			return fmt.Errorf("cron: task %s added twice", name)
+		}
+	}
+	s.tasks = append(s.tasks, &Task{Name: name, Spec: spec, Schedule: schedule, Run: fn})
+	return nil
+}
+
+// Tasks returns the tasks sorted by name.
+func (s *Scheduler) Tasks() []*Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tasks := append([]*Task(nil), s.tasks...)
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks
+}
+
+// Task returns the task with the given name, or nil.
+func (s *Scheduler) Task(name string) *Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, task := range s.tasks {
+		if task.Name == name {
+			return task
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Scheduler) location() *time.Location {
+	if s.Location != nil {
+		return s.Location
+	}
+	return time.UTC
+}
+
+// NextRun returns when the task is next due after t.
+func (s *Scheduler) NextRun(task *Task, t time.Time) time.Time {
+	return task.Schedule.Next(t.In(s.location()))
+}
+
+// Run runs each task whenever it is due until ctx is cancelled. Runs missed
+// while the process was down, or while a task was still running, are
+// skipped rather than caught up. Run waits for running tasks to finish
+// before returning.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	tasks := s.Tasks()
+	if len(tasks) == 0 {
+		return
+	}
+	next := make(map[*Task]time.Time, len(tasks))
+	for _, task := range tasks {
+		next[task] = s.NextRun(task, s.now())
+	}
+
+	for {
+		var earliest time.Time
+		for _, due := range next {
+			if !due.IsZero() && (earliest.IsZero() || due.Before(earliest)) {
+				earliest = due
+			}
+		}
+		if earliest.IsZero() {
+			// None of the schedules will ever be due.
+			return
+		}
+
+		timer := time.NewTimer(earliest.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := s.now()
+		for task, due := range next {
+			if due.IsZero() || due.After(now) {
+				continue
+			}
+			wg.Add(1)
+			go func(task *Task, due time.Time) {
+				defer wg.Done()
+				_, err := s.run(ctx, task, TriggerSchedule, due)
+				if err != nil && err != ErrTaskRunning {
+					log.Printf("cron: %s: %s", task.Name, err)
+				}
+			}(task, due)
+			next[task] = s.NextRun(task, now)
+		}
+	}
+}
+
+// RunNow runs the task right away, unless it is already running.
+func (s *Scheduler) RunNow(ctx context.Context, name string) (*Run, error) {
+	task := s.Task(name)
+	if task == nil {
+		return nil, ErrUnknownTask
+	}
+	return s.run(ctx, task, TriggerManual, s.now())
+}
+
+// run runs the task while holding its lock and records the run. The error
+// is about running the task at all; the task's own error is in the Run.
+func (s *Scheduler) run(ctx context.Context, task *Task, trigger Trigger, scheduledAt time.Time) (*Run, error) {
+	var run *Run
+	ran, err := s.Store.Exclusive(ctx, task.Name, func(ctx context.Context) error {
+		started := s.now()
+		r := &Run{
+			ID:          entityid.Generator.Generate(),
+			Task:        task.Name,
+			Trigger:     trigger,
+			ScheduledAt: scheduledAt,
+			StartedAt:   started,
+		}
+		// Another replica may have run this slot already, if its clock is
+		// ahead of ours.
+		ok, err := s.Store.StartRun(ctx, r)
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := runTask(ctx, task); err != nil {
+			r.Error = err.Error()
+		}
+		finished := s.now()
+		r.FinishedAt = &finished
+		r.DurationMs = finished.Sub(started).Milliseconds()
+		run = r
+		// The run is recorded even if the scheduler is shutting down.
+		return s.Store.FinishRun(context.Background(), r)
+	})
+	if err != nil {
+		return run, err
+	}
+	if !ran {
+		return nil, ErrTaskRunning
+	}
+	return run, nil
+}
+
+// runTask turns a panic into an error so one bad task can't stop the
+// scheduler.
+func runTask(ctx context.Context, task *Task) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("task panicked: %v", recovered)
+		}
+	}()
+	return task.Run(ctx)
+}
@@ -0,0 +1,146 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	locked map[string]bool
+	runs   []*Run
+}
+
+func (ms *memoryStore) Exclusive(ctx context.Context, task string, fn func(ctx context.Context) error) (bool, error) {
+	ms.mu.Lock()
+	if ms.locked[task] {
+		ms.mu.Unlock()
+		return false, nil
+	}
+	if ms.locked == nil {
+		ms.locked = make(map[string]bool)
+	}
+	ms.locked[task] = true
+	ms.mu.Unlock()
+
+	err := fn(ctx)
+
+	ms.mu.Lock()
+	delete(ms.locked, task)
+	ms.mu.Unlock()
+	return true, err
+}
+
+func (ms *memoryStore) StartRun(ctx context.Context, run *Run) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, r := range ms.runs {
+		if run.Trigger == TriggerSchedule && r.Trigger == TriggerSchedule && r.Task == run.Task && r.ScheduledAt.Equal(run.ScheduledAt) {
+			return false, nil
+		}
+	}
+	ms.runs = append(ms.runs, run)
+	return true, nil
+}
+
+func (ms *memoryStore) FinishRun(ctx context.Context, run *Run) error {
+	return nil
+}
+
+func TestScheduler_RunNow(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	scheduler := &Scheduler{Store: store, Now: func() time.Time { return now }}
+
+	calls := 0
+	fail := errors.New("disk full")
+	var taskErr error
+	if err := scheduler.Add("purge", "@hourly", func(ctx context.Context) error {
+		calls++
+		now = now.Add(1500 * time.Millisecond)
+		return taskErr
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Add("purge", "@daily", nil); err == nil {
+		t.Error("Add() of a task twice error = nil, expected an error")
+	}
+	if err := scheduler.Add("broken", "every hour", nil); err == nil {
+		t.Error("Add() with an invalid schedule error = nil, expected an error")
+	}
+
+	t.Run("records runs", func(t *testing.T) {
+		run, err := scheduler.RunNow(context.Background(), "purge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 1 || run.Trigger != TriggerManual || run.DurationMs != 1500 || run.Error != "" || run.FinishedAt == nil {
+			t.Errorf("calls, Run = %d, %+v, expected 1 manual run of 1500ms", calls, run)
+		}
+
+		taskErr = fail
+		run, err = scheduler.RunNow(context.Background(), "purge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run.Error != fail.Error() {
+			t.Errorf("Error = %q, expected %q", run.Error, fail)
+		}
+	})
+	t.Run("skips tasks running elsewhere", func(t *testing.T) {
+		store.locked = map[string]bool{"purge": true}
+		defer func() { store.locked = nil }()
+
+		if _, err := scheduler.RunNow(context.Background(), "purge"); err != ErrTaskRunning {
+			t.Errorf("RunNow() error = %v, expected %v", err, ErrTaskRunning)
+		}
+	})
+	t.Run("runs each scheduled time once", func(t *testing.T) {
+		task := scheduler.Task("purge")
+		due := time.Date(2021, 9, 1, 13, 0, 0, 0, time.UTC)
+		calls = 0
+		for i := 0; i < 2; i++ {
+			if _, err := scheduler.run(context.Background(), task, TriggerSchedule, due); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("ran %d times, expected 1", calls)
+		}
+	})
+	t.Run("rejects unknown tasks", func(t *testing.T) {
+		if _, err := scheduler.RunNow(context.Background(), "missing"); err != ErrUnknownTask {
+			t.Errorf("RunNow() error = %v, expected %v", err, ErrUnknownTask)
+		}
+	})
+}
+
+func TestScheduler_Run(t *testing.T) {
+	scheduler := &Scheduler{Store: &memoryStore{}}
+	ran := make(chan struct{}, 1)
+	scheduler.Add("tick", "@every 1s", func(ctx context.Context) error {
+		select {
+		case ran <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+
+	select {
+	case <-ran:
+	case <-time.After(3 * time.Second):
+		t.Error("Run() didn't run a task due every second")
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,6 @@
+-- The api connects as todos rather than the postgres superuser, since
+-- superusers and roles with BYPASSRLS skip row level security. It owns the
+-- tables it creates, which FORCE ROW LEVEL SECURITY holds to the policies too.
+CREATE ROLE todos LOGIN PASSWORD 'password' NOSUPERUSER NOBYPASSRLS;
+ALTER DATABASE todos OWNER TO todos;
+ALTER SCHEMA public OWNER TO todos;
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func (t *Todo) Archived() bool {
+	return t.ArchivedAt != nil
+}
+
+// SetCompleted marks the todo completed or not, tracking when it was
+// completed.
+func (t *Todo) SetCompleted(completed bool, now time.Time) {
+	if completed && !t.Completed {
+		t.CompletedAt = &now
+	} else if !completed {
+		t.CompletedAt = nil
+	}
+	t.Completed = completed
+}
+
+// ArchiveTodo archives the todo along with its subtasks.
+func (u *User) ArchiveTodo(id entityid.ID, now time.Time) bool {
+	todo := u.FindTodo(id)
+	if todo.ID == "" {
+		return false
+	}
+	if !todo.Archived() {
+		todo.ArchivedAt = &now
+	}
+	for _, descendant := range u.Todos.Descendants(id) {
+		if !descendant.Archived() {
+			descendant.ArchivedAt = &now
+		}
+	}
+	return true
+}
+
+// UnarchiveTodo restores an archived todo along with the subtasks that were
+// archived with it.
+func (u *User) UnarchiveTodo(id entityid.ID) bool {
+	todo := u.FindTodo(id)
+	if todo.ID == "" || !todo.Archived() {
+		return false
+	}
+	archivedAt := *todo.ArchivedAt
+	for _, descendant := range u.Todos.Descendants(id) {
+		if descendant.Archived() && descendant.ArchivedAt.Equal(archivedAt) {
+			descendant.ArchivedAt = nil
+		}
+	}
+	todo.ArchivedAt = nil
+	return true
+}
+
+// ArchiveCompleted archives every completed top level todo that isn't already
+// archived and returns the todos that were archived.
+func (u *User) ArchiveCompleted(now time.Time) Todos {
+	var archived Todos
+	for _, todo := range u.Todos.Active() {
+		if todo.Completed && !todo.Archived() && todo.ParentID == "" {
+			u.ArchiveTodo(todo.ID, now)
+			archived = append(archived, todo)
+		}
+	}
+	return archived
+}
+
+// AutoArchive archives the top level todos that have been completed for
+// longer than the user's AutoArchiveAfterDays setting along with their
+// subtasks, like ArchiveCompleted, and returns them.
+func (u *User) AutoArchive(now time.Time) Todos {
+	if u.AutoArchiveAfterDays <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -u.AutoArchiveAfterDays)
+
+	var archived Todos
+	for _, todo := range u.Todos.Active() {
+		completedAt := todo.UpdatedAt
+		if todo.CompletedAt != nil {
+			completedAt = *todo.CompletedAt
+		}
+		if todo.Completed && !todo.Archived() && todo.ParentID == "" && completedAt.Before(cutoff) {
+			u.ArchiveTodo(todo.ID, now)
+			archived = append(archived, todo)
+		}
+	}
+	return archived
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func TestUser_AutoArchive(t *testing.T) {
+	now := time.Now()
+	longAgo := now.AddDate(0, 0, -10)
+	recently := now.AddDate(0, 0, -1)
+	deletedAt := now
+	user := &User{AutoArchiveAfterDays: 7, Todos: Todos{
+		{ID: "a", Completed: true, CompletedAt: &longAgo},
+		{ID: "b", Completed: true, CompletedAt: &recently},
+		{ID: "c", Completed: false, UpdatedAt: longAgo},
+		{ID: "d", Completed: true, UpdatedAt: longAgo},
+		{ID: "e", Completed: true, CompletedAt: &longAgo, DeletedAt: &deletedAt},
+	}}
+
+	archived := user.AutoArchive(now)
+	if len(archived) != 2 || archived[0].ID != "a" || archived[1].ID != "d" {
+		t.Errorf("AutoArchive() = %v, expected a and d", archived)
+	}
+	if again := user.AutoArchive(now); len(again) != 0 {
+		t.Errorf("AutoArchive() = %v, expected nothing left to archive", again)
+	}
+
+	user.AutoArchiveAfterDays = 0
+	user.Todos = append(user.Todos, &Todo{ID: "f", Completed: true, CompletedAt: &longAgo})
+	if archived := user.AutoArchive(now); len(archived) != 0 {
+		t.Errorf("AutoArchive() = %v, expected nothing when disabled", archived)
+	}
+}
+
+// Archiving on demand and automatically follow the same rule: completed top
+// level todos are archived with their subtasks, and subtasks never on their
+// own.
+func TestUser_archiveSubtasks(t *testing.T) {
+	now := time.Now()
+	longAgo := now.AddDate(0, 0, -10)
+	newUser := func() *User {
+		return &User{AutoArchiveAfterDays: 7, Todos: Todos{
+			{ID: "parent", Completed: true, CompletedAt: &longAgo},
+			{ID: "child", ParentID: "parent"},
+			{ID: "active"},
+			{ID: "done-child", ParentID: "active", Completed: true, CompletedAt: &longAgo},
+		}}
+	}
+
+	for name, archive := range map[string]func(*User) Todos{
+		"ArchiveCompleted": func(u *User) Todos { return u.ArchiveCompleted(now) },
+		"AutoArchive":      func(u *User) Todos { return u.AutoArchive(now) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			user := newUser()
+			archived := archive(user)
+			if len(archived) != 1 || archived[0].ID != "parent" {
+				t.Errorf("%s() = %v, expected only parent", name, archived)
+			}
+			for id, expected := range map[string]bool{"parent": true, "child": true, "active": false, "done-child": false} {
+				if actual := user.Todos.FindByID(entityid.ID(id)).Archived(); actual != expected {
+					t.Errorf("%s archived = %v, expected %v", id, actual, expected)
+				}
+			}
+		})
+	}
+}
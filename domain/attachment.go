@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// Attachment is a file uploaded to a todo. Its contents live in a blob store
+// under Key; its size counts against the quota of the todo's owner.
+type Attachment struct {
+	ID          entityid.ID `json:"id"`
+	TodoID      entityid.ID `json:"todoId"`
+	UserID      entityid.ID `json:"userId"`
+	UploaderID  entityid.ID `json:"uploaderId"`
+	Filename    string      `json:"filename"`
+	ContentType string      `json:"contentType"`
+	Size        int64       `json:"size"`
+	Key         string      `json:"-"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
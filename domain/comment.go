@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// Comment is a markdown note left on a todo by someone who can see it.
+type Comment struct {
+	ID        entityid.ID `json:"id"`
+	TodoID    entityid.ID `json:"todoId"`
+	AuthorID  entityid.ID `json:"authorId"`
+	Body      string      `json:"body"`
+	CreatedAt time.Time   `json:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+var (
+	markdownCodeBlock = regexp.MustCompile("(?s)```.*?```")
+	markdownCodeSpan  = regexp.MustCompile("`[^`\n]*`")
+	mentionPattern    = regexp.MustCompile(`(?:^|[^\w@])@([\w.%+-]+@[\w.-]+\.[A-Za-z]{2,})`)
+)
+
+// ParseMentions returns the email addresses mentioned as @email in a markdown
+// body, lowercased and in the order first mentioned. Mentions inside code are
+// ignored.
+func ParseMentions(body string) []string {
+	body = markdownCodeBlock.ReplaceAllString(body, " ")
+	body = markdownCodeSpan.ReplaceAllString(body, " ")
+
+	var emails []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		email := strings.ToLower(match[1])
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
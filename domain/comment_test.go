@@ -0,0 +1,29 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{"finds mentions", "@ann@example.com can you check with @Bob@Example.com?", []string{"ann@example.com", "bob@example.com"}},
+		{"dedupes mentions", "@ann@example.com and again @ANN@example.com", []string{"ann@example.com"}},
+		{"drops trailing punctuation", "thanks @ann@example.com.", []string{"ann@example.com"}},
+		{"ignores plain emails", "mail ann@example.com", nil},
+		{"ignores code spans", "run `@ann@example.com` first", nil},
+		{"ignores code blocks", "```\n@ann@example.com\n```\n**@bob@example.com**", []string{"bob@example.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseMentions(test.body); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("ParseMentions() = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
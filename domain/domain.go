@@ -11,8 +11,44 @@ type User struct {
 	CreatedAt  time.Time   `json:"createdAt"`
 	LastSeenAt time.Time   `json:"lastSeenAt"`
 	Todos      Todos       `json:"todos"`
+	Tags       Tags        `json:"tags"`
+	Projects   Projects    `json:"projects"`
 	Email      string      `json:"email"`
 	Password   string      `json:"-"`
+	Timezone   string      `json:"timezone"`
+	// AutoArchiveAfterDays archives completed todos this many days after they
+	// were completed. Zero disables auto-archiving.
+	AutoArchiveAfterDays int `json:"autoArchiveAfterDays"`
+	// Version counts the saves of the user. A user can only be saved at the
+	// version it was loaded at, so a request working on a stale copy can't
+	// overwrite another request's changes.
+	Version int `json:"-"`
+
+	// OrgID is the organization Projects and Todos are scoped to. The
+	// projects and todos of other organizations are kept aside; see
+	// ScopeToOrg.
+	OrgID          entityid.ID `json:"orgId"`
+	hiddenProjects Projects
+	hiddenTodos    Todos
+	// limited is set when only OrgID's projects and todos were loaded; see
+	// LimitToOrg.
+	limited bool
+
+	// events are waiting to be written to the outbox; see Raise.
+	events []Event
+	// history is waiting to be written to the todo history; see
+	// RecordHistory.
+	history []*HistoryEntry
+}
+
+// Location returns the user's configured time zone, falling back to UTC when
+// none is set or it cannot be loaded.
+func (u *User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
 type Todos []*Todo
@@ -33,11 +69,58 @@ func (todos Todos) FindIndexByID(id entityid.ID) int {
 	return -1
 }
 
+// Filter returns the todos for which keep returns true.
+func (todos Todos) Filter(keep func(*Todo) bool) Todos {
+	filtered := make(Todos, 0, len(todos))
+	for _, todo := range todos {
+		if keep(todo) {
+			filtered = append(filtered, todo)
+		}
+	}
+	return filtered
+}
+
 type Todo struct {
-	ID          entityid.ID `json:"id"`
-	Title       string      `json:"title"`
-	Description string      `json:"description"`
-	Completed   bool        `json:"completed"`
-	CreatedAt   time.Time   `json:"createdAt"`
-	UpdatedAt   time.Time   `json:"updatedAt"`
+	ID entityid.ID `json:"id"`
+	// Version is incremented on every tracked change and doubles as the
+	// todo's history version and ETag.
+	Version     int       `json:"version"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Completed   bool      `json:"completed"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Priority    Priority  `json:"priority"`
+	Tags        Tags      `json:"tags"`
+
+	ProjectID entityid.ID `json:"projectId"`
+	ParentID  entityid.ID `json:"parentId"`
+	// AssigneeID is the user responsible for the todo: the project's owner or
+	// one of its members.
+	AssigneeID entityid.ID `json:"assigneeId"`
+	Progress   *Progress   `json:"progress"`
+	// CommentCount is filled in on todo lists.
+	CommentCount *int `json:"commentCount,omitempty"`
+	// Position orders the todo among the user's todos. Keys compare as plain
+	// strings; see the ordering package.
+	Position string `json:"position"`
+
+	// DueAt is the moment the todo is due. For all-day todos it is the start of
+	// the due date in the owner's time zone.
+	DueAt  *time.Time `json:"dueAt"`
+	AllDay bool       `json:"allDay"`
+	// ReminderOffsets are the number of minutes before DueAt at which the owner
+	// should be reminded.
+	ReminderOffsets []int      `json:"reminderOffsets"`
+	RemindedAt      *time.Time `json:"-"`
+
+	// Recurrence is an RFC 5545 RRULE. Completing a recurring todo creates the
+	// next occurrence, counted from RecurrenceStart.
+	Recurrence      string     `json:"recurrence"`
+	RecurrenceStart *time.Time `json:"recurrenceStart"`
+
+	CompletedAt *time.Time `json:"completedAt"`
+	ArchivedAt  *time.Time `json:"archivedAt"`
+	// DeletedAt is set while the todo is in the trash.
+	DeletedAt *time.Time `json:"deletedAt"`
 }
@@ -0,0 +1,70 @@
+package domain
+
+import "time"
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// NormalizeDue pins all-day due dates to the start of the given date in loc.
+// The calendar date is taken as written by the client, regardless of offset.
+func (t *Todo) NormalizeDue(loc *time.Location) {
+	if t.DueAt == nil || !t.AllDay {
+		return
+	}
+	y, m, d := t.DueAt.Date()
+	dueAt := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	t.DueAt = &dueAt
+}
+
+// Deadline returns the moment after which the todo is overdue. All-day todos
+// are due until the end of their due date.
+func (t *Todo) Deadline(loc *time.Location) time.Time {
+	if t.AllDay {
+		return startOfDay(*t.DueAt, loc).AddDate(0, 0, 1)
+	}
+	return *t.DueAt
+}
+
+func (t *Todo) IsOverdue(now time.Time, loc *time.Location) bool {
+	return t.DueAt != nil && !t.Completed && now.After(t.Deadline(loc))
+}
+
+func (t *Todo) IsDueToday(now time.Time, loc *time.Location) bool {
+	return t.DueAt != nil && startOfDay(*t.DueAt, loc).Equal(startOfDay(now, loc))
+}
+
+func (t *Todo) IsUpcoming(now time.Time, loc *time.Location) bool {
+	return t.DueAt != nil && !t.Completed && startOfDay(*t.DueAt, loc).After(startOfDay(now, loc))
+}
+
+// PendingReminder returns the latest reminder time that has passed but has not
+// yet been delivered. Reminders that fell before the todo was created are never
+// delivered.
+func (t *Todo) PendingReminder(now time.Time, loc *time.Location) (time.Time, bool) {
+	if t.DueAt == nil || t.Completed {
+		return time.Time{}, false
+	}
+
+	anchor := *t.DueAt
+	if t.AllDay {
+		anchor = startOfDay(anchor, loc)
+	}
+
+	var pending time.Time
+	for _, offset := range t.ReminderOffsets {
+		remindAt := anchor.Add(-time.Duration(offset) * time.Minute)
+		if remindAt.After(now) || remindAt.Before(t.CreatedAt) {
+			continue
+		}
+		if t.RemindedAt != nil && !remindAt.After(*t.RemindedAt) {
+			continue
+		}
+		if remindAt.After(pending) {
+			pending = remindAt
+		}
+	}
+
+	return pending, !pending.IsZero()
+}
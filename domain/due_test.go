@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTodo_AllDay(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	dueAt := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	todo := &Todo{DueAt: &dueAt, AllDay: true}
+	todo.NormalizeDue(loc)
+
+	t.Run("keeps the calendar date in the user's time zone", func(t *testing.T) {
+		expected := time.Date(2021, 9, 1, 0, 0, 0, 0, loc)
+		if !todo.DueAt.Equal(expected) {
+			t.Errorf("DueAt = %v, expected %v", todo.DueAt, expected)
+		}
+	})
+	t.Run("is due today until midnight in the user's time zone", func(t *testing.T) {
+		now := time.Date(2021, 9, 2, 2, 0, 0, 0, time.UTC)
+		if !todo.IsDueToday(now, loc) {
+			t.Errorf("IsDueToday() = false, expected true")
+		}
+		if todo.IsOverdue(now, loc) {
+			t.Errorf("IsOverdue() = true, expected false")
+		}
+	})
+	t.Run("is overdue the next day", func(t *testing.T) {
+		now := time.Date(2021, 9, 2, 5, 0, 0, 0, time.UTC)
+		if !todo.IsOverdue(now, loc) {
+			t.Errorf("IsOverdue() = false, expected true")
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// The types of Event the domain raises.
+const (
+	EventUserRegistered = "user.registered"
+	EventTodoCreated    = "todo.created"
+	EventTodoUpdated    = "todo.updated"
+	EventTodoCompleted  = "todo.completed"
+	EventTodoDeleted    = "todo.deleted"
+)
+
+// Event is something that happened to a user's aggregate. Events are raised
+// on the user and written to the outbox in the same transaction that saves
+// it, so they are recorded if and only if the change is.
+type Event struct {
+	ID   entityid.ID
+	Type string
+	// OrgID is the organization the event happened in and SubjectID the
+	// user or todo it is about.
+	OrgID      entityid.ID
+	SubjectID  entityid.ID
+	Data       interface{}
+	OccurredAt time.Time
+}
+
+// Raise records an event to be written to the outbox when the user is saved.
+func (u *User) Raise(eventType string, orgID, subjectID entityid.ID, data interface{}, now time.Time) {
+	u.events = append(u.events, Event{
+		ID:         entityid.Generator.Generate(),
+		Type:       eventType,
+		OrgID:      orgID,
+		SubjectID:  subjectID,
+		Data:       data,
+		OccurredAt: now,
+	})
+}
+
+// Events returns the events raised since the user was loaded or last saved.
+func (u *User) Events() []Event {
+	return u.events
+}
+
+// ClearEvents forgets the raised events once they have been saved.
+func (u *User) ClearEvents() {
+	u.events = nil
+}
+
+// Register raises the event for a newly signed up user.
+func (u *User) Register(now time.Time) {
+	u.Raise(EventUserRegistered, u.ID, u.ID, struct {
+		ID    entityid.ID `json:"id"`
+		Email string      `json:"email"`
+	}{u.ID, u.Email}, now)
+}
+
+// TodoEventTypes classifies the change that turned before into after, where
+// nil means the todo didn't exist. Moving a todo to the trash deletes it and
+// restoring it creates it again, as far as active todos are concerned.
+func TodoEventTypes(before, after *TodoSnapshot) []string {
+	switch {
+	case before == nil && after == nil:
+		return nil
+	case after == nil:
+		return []string{EventTodoDeleted}
+	case before == nil || (before.DeletedAt != nil && after.DeletedAt == nil):
+		if after.Completed {
+			return []string{EventTodoCreated, EventTodoCompleted}
+		}
+		return []string{EventTodoCreated}
+	case before.DeletedAt == nil && after.DeletedAt != nil:
+		return []string{EventTodoDeleted}
+	case !before.Completed && after.Completed:
+		return []string{EventTodoUpdated, EventTodoCompleted}
+	}
+	return []string{EventTodoUpdated}
+}
+
+// RecordTodoChange raises the events for a change to one of the user's todos.
+// The todo is nil when it was removed for good.
+func (u *User) RecordTodoChange(todoID entityid.ID, todo *Todo, before, after *TodoSnapshot, now time.Time) {
+	var projectID entityid.ID
+	var data interface{} = todo
+	if after != nil {
+		projectID = after.ProjectID
+	} else if before != nil {
+		projectID = before.ProjectID
+	}
+	orgID := u.AllProjects().FindByID(projectID).OrgID
+
+	for _, eventType := range TodoEventTypes(before, after) {
+		if eventType == EventTodoDeleted {
+			data = struct {
+				ID entityid.ID `json:"id"`
+			}{todoID}
+		}
+		u.Raise(eventType, orgID, todoID, data, now)
+	}
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTodoEventTypes(t *testing.T) {
+	now := time.Now()
+	open := &TodoSnapshot{Title: "open"}
+	completed := &TodoSnapshot{Title: "done", Completed: true}
+	trashed := &TodoSnapshot{Title: "trashed", DeletedAt: &now}
+	trashedCompleted := &TodoSnapshot{Title: "trashed", Completed: true, DeletedAt: &now}
+
+	tests := []struct {
+		name          string
+		before, after *TodoSnapshot
+		expected      []string
+	}{
+		{"created", nil, open, []string{EventTodoCreated}},
+		{"created completed", nil, completed, []string{EventTodoCreated, EventTodoCompleted}},
+		{"updated", open, &TodoSnapshot{Title: "renamed"}, []string{EventTodoUpdated}},
+		{"completed", open, completed, []string{EventTodoUpdated, EventTodoCompleted}},
+		{"reopened", completed, open, []string{EventTodoUpdated}},
+		{"trashed", open, trashed, []string{EventTodoDeleted}},
+		{"restored", trashed, open, []string{EventTodoCreated}},
+		{"restored completed", trashedCompleted, completed, []string{EventTodoCreated, EventTodoCompleted}},
+		{"removed", open, nil, []string{EventTodoDeleted}},
+	}
+
+	for _, test := range tests {
+		if got := TodoEventTypes(test.before, test.after); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("TodoEventTypes() %s = %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestUser_RecordTodoChange(t *testing.T) {
+	now := time.Now()
+	user := newOrgsUser()
+	user.ScopeToOrg("a")
+	todo := &Todo{ID: "b-1", ProjectID: "b-inbox", Completed: true}
+
+	user.RecordTodoChange(todo.ID, todo, &TodoSnapshot{ProjectID: "b-inbox"}, &TodoSnapshot{ProjectID: "b-inbox", Completed: true}, now)
+	user.RecordTodoChange("a-1", nil, &TodoSnapshot{ProjectID: "a-inbox"}, nil, now)
+
+	events := user.Events()
+	if len(events) != 3 {
+		t.Fatalf("len(Events()) = %d, expected 3", len(events))
+	}
+	for i, expected := range []string{EventTodoUpdated, EventTodoCompleted, EventTodoDeleted} {
+		if events[i].Type != expected {
+			t.Errorf("Events()[%d].Type = %v, expected %v", i, events[i].Type, expected)
+		}
+	}
+	// Events in other organizations than the one the user is scoped to still
+	// belong to theirs.
+	if events[0].OrgID != "b" || events[0].SubjectID != "b-1" || events[0].Data != todo {
+		t.Errorf("Events()[0] = %+v, expected b-1 in b with the todo", events[0])
+	}
+	if events[2].OrgID != "a" || events[2].Data == nil {
+		t.Errorf("Events()[2] = %+v, expected a-1 in a with its ID", events[2])
+	}
+	if events[0].ID == events[1].ID {
+		t.Error("Events() share an ID")
+	}
+
+	user.ClearEvents()
+	if len(user.Events()) != 0 {
+		t.Errorf("len(Events()) = %d after ClearEvents(), expected 0", len(user.Events()))
+	}
+}
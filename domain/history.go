@@ -0,0 +1,222 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+type HistoryAction string
+
+const (
+	HistoryCreated   HistoryAction = "created"
+	HistoryUpdated   HistoryAction = "updated"
+	HistoryCompleted HistoryAction = "completed"
+	HistoryDeleted   HistoryAction = "deleted"
+	HistoryRestored  HistoryAction = "restored"
+	HistoryPurged    HistoryAction = "purged"
+	HistoryReverted  HistoryAction = "reverted"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// FieldChange holds the JSON encoded value of a field before and after a
+// change. Before is null for created todos and After is null for purged ones.
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// HistoryEntry is an append-only record of one change to a todo. Versions
+// count up from 1 for each todo.
+type HistoryEntry struct {
+	ID        entityid.ID   `json:"id"`
+	TodoID    entityid.ID   `json:"todoId"`
+	ActorID   entityid.ID   `json:"actorId"`
+	Action    HistoryAction `json:"action"`
+	Version   int           `json:"version"`
+	Changes   []FieldChange `json:"changes"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// RecordHistory adds entries to be written to the todo history when the user
+// is saved, in the same transaction, so the history has an entry for every
+// saved version of a todo.
+func (u *User) RecordHistory(entries ...*HistoryEntry) {
+	u.history = append(u.history, entries...)
+}
+
+// PendingHistory returns the entries recorded since the user was last saved.
+func (u *User) PendingHistory() []*HistoryEntry {
+	return u.history
+}
+
+// ClearHistory forgets the recorded entries once they have been saved.
+func (u *User) ClearHistory() {
+	u.history = nil
+}
+
+// TodoSnapshot is the set of todo fields tracked in history. Version is
+// carried along but never diffed.
+type TodoSnapshot struct {
+	Version         int           `json:"-"`
+	Title           string        `json:"title"`
+	Description     string        `json:"description"`
+	Completed       bool          `json:"completed"`
+	Priority        Priority      `json:"priority"`
+	DueAt           *time.Time    `json:"dueAt"`
+	AllDay          bool          `json:"allDay"`
+	ReminderOffsets []int         `json:"reminderOffsets"`
+	Recurrence      string        `json:"recurrence"`
+	ProjectID       entityid.ID   `json:"projectId"`
+	ParentID        entityid.ID   `json:"parentId"`
+	AssigneeID      entityid.ID   `json:"assigneeId"`
+	TagIDs          []entityid.ID `json:"tagIds"`
+	ArchivedAt      *time.Time    `json:"archivedAt"`
+	DeletedAt       *time.Time    `json:"deletedAt"`
+}
+
+func (t *Todo) Snapshot() TodoSnapshot {
+	var tagIDs []entityid.ID
+	for _, tag := range t.Tags {
+		tagIDs = append(tagIDs, tag.ID)
+	}
+	return TodoSnapshot{
+		Version:         t.Version,
+		Title:           t.Title,
+		Description:     t.Description,
+		Completed:       t.Completed,
+		Priority:        t.Priority,
+		DueAt:           t.DueAt,
+		AllDay:          t.AllDay,
+		ReminderOffsets: t.ReminderOffsets,
+		Recurrence:      t.Recurrence,
+		ProjectID:       t.ProjectID,
+		ParentID:        t.ParentID,
+		AssigneeID:      t.AssigneeID,
+		TagIDs:          tagIDs,
+		ArchivedAt:      t.ArchivedAt,
+		DeletedAt:       t.DeletedAt,
+	}
+}
+
+// ApplySnapshot restores the todo's content fields from a snapshot. Where the
+// todo lives (project, parent, tags, archive and trash) is left unchanged.
+func (t *Todo) ApplySnapshot(s TodoSnapshot, now time.Time) {
+	t.Title = s.Title
+	t.Description = s.Description
+	t.SetCompleted(s.Completed, now)
+	t.Priority = s.Priority
+	if !sameTime(t.DueAt, s.DueAt) {
+		t.RemindedAt = nil
+	}
+	t.DueAt = s.DueAt
+	t.AllDay = s.AllDay
+	t.ReminderOffsets = s.ReminderOffsets
+	t.Recurrence = s.Recurrence
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func snapshotFields(s *TodoSnapshot) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if s == nil {
+		return fields
+	}
+	encoded, _ := json.Marshal(s)
+	json.Unmarshal(encoded, &fields)
+	return fields
+}
+
+// DiffSnapshots returns the fields that differ between two snapshots, in
+// field name order. A nil before or after stands for a todo that didn't exist.
+func DiffSnapshots(before, after *TodoSnapshot) []FieldChange {
+	beforeFields, afterFields := snapshotFields(before), snapshotFields(after)
+	names := make(map[string]bool)
+	for name := range beforeFields {
+		names[name] = true
+	}
+	for name := range afterFields {
+		names[name] = true
+	}
+
+	var changes []FieldChange
+	for name := range names {
+		b, a := beforeFields[name], afterFields[name]
+		if bytes.Equal(b, a) {
+			continue
+		}
+		change := FieldChange{Field: name, Before: b, After: a}
+		if b == nil {
+			change.Before = json.RawMessage("null")
+		}
+		if a == nil {
+			change.After = json.RawMessage("null")
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// SnapshotAtVersion rebuilds the snapshot a todo had right after the given
+// version by undoing every later entry on top of its current snapshot.
+func SnapshotAtVersion(current TodoSnapshot, entries []*HistoryEntry, version int) (TodoSnapshot, error) {
+	found := false
+	for _, entry := range entries {
+		if entry.Version == version {
+			found = true
+		}
+	}
+	if !found {
+		return TodoSnapshot{}, ErrVersionNotFound
+	}
+
+	sorted := append([]*HistoryEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version > sorted[j].Version })
+
+	fields := snapshotFields(&current)
+	for _, entry := range sorted {
+		if entry.Version <= version {
+			break
+		}
+		for _, change := range entry.Changes {
+			fields[change.Field] = change.Before
+		}
+	}
+
+	var snapshot TodoSnapshot
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return TodoSnapshot{}, err
+	}
+	err = json.Unmarshal(encoded, &snapshot)
+	return snapshot, err
+}
+
+// InferHistoryAction infers what kind of change turned before into after.
+func InferHistoryAction(before, after *TodoSnapshot) HistoryAction {
+	switch {
+	case before == nil:
+		return HistoryCreated
+	case after == nil:
+		return HistoryPurged
+	case before.DeletedAt == nil && after.DeletedAt != nil:
+		return HistoryDeleted
+	case before.DeletedAt != nil && after.DeletedAt == nil:
+		return HistoryRestored
+	case !before.Completed && after.Completed:
+		return HistoryCompleted
+	}
+	return HistoryUpdated
+}
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	before := &TodoSnapshot{Title: "one", Completed: false}
+	after := &TodoSnapshot{Title: "two", Completed: true}
+
+	changes := DiffSnapshots(before, after)
+	if len(changes) != 2 {
+		t.Fatalf("DiffSnapshots() = %+v, expected 2 changes", changes)
+	}
+	if changes[0].Field != "completed" || string(changes[0].Before) != "false" || string(changes[0].After) != "true" {
+		t.Errorf("changes[0] = %+v", changes[0])
+	}
+	if changes[1].Field != "title" || string(changes[1].Before) != `"one"` || string(changes[1].After) != `"two"` {
+		t.Errorf("changes[1] = %+v", changes[1])
+	}
+	if action := InferHistoryAction(before, after); action != HistoryCompleted {
+		t.Errorf("InferHistoryAction() = %v, expected %v", action, HistoryCompleted)
+	}
+}
+
+func TestSnapshotAtVersion(t *testing.T) {
+	v1 := TodoSnapshot{Title: "one"}
+	v2 := TodoSnapshot{Title: "two", Description: "details"}
+	v3 := TodoSnapshot{Title: "three", Description: "details"}
+	entries := []*HistoryEntry{
+		{Version: 1, Changes: DiffSnapshots(nil, &v1)},
+		{Version: 2, Changes: DiffSnapshots(&v1, &v2)},
+		{Version: 3, Changes: DiffSnapshots(&v2, &v3)},
+	}
+
+	for version, expected := range map[int]TodoSnapshot{1: v1, 2: v2, 3: v3} {
+		actual, err := SnapshotAtVersion(v3, entries, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.Title != expected.Title || actual.Description != expected.Description {
+			t.Errorf("SnapshotAtVersion(%d) = %+v, expected %+v", version, actual, expected)
+		}
+	}
+
+	if _, err := SnapshotAtVersion(v3, entries, 4); err != ErrVersionNotFound {
+		t.Errorf("SnapshotAtVersion(4) error = %v, expected %v", err, ErrVersionNotFound)
+	}
+
+	t.Run("applies only content fields", func(t *testing.T) {
+		archivedAt := time.Now()
+		todo := &Todo{Title: "three", ArchivedAt: &archivedAt}
+		todo.ApplySnapshot(v1, time.Now())
+		if todo.Title != "one" || todo.ArchivedAt == nil {
+			t.Errorf("ApplySnapshot() = %+v", todo)
+		}
+	})
+}
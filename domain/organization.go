@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// Organization is a workspace that projects and todos belong to. Every user
+// has a personal organization whose ID is their own user ID; other
+// organizations are joined by invitation.
+type Organization struct {
+	ID        entityid.ID `json:"id"`
+	Name      string      `json:"name"`
+	Personal  bool        `json:"personal"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+// OrgRole is what a member may do in an organization.
+type OrgRole string
+
+const (
+	OrgRoleOwner  OrgRole = "owner"
+	OrgRoleAdmin  OrgRole = "admin"
+	OrgRoleMember OrgRole = "member"
+)
+
+func (r OrgRole) Valid() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin || r == OrgRoleMember
+}
+
+// CanManage reports whether the role may rename the organization and invite,
+// change and remove its members.
+func (r OrgRole) CanManage() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin
+}
+
+// OrgMember is a user's membership of, or invitation to, an organization.
+// UserID is set once the invitation is accepted.
+type OrgMember struct {
+	ID          entityid.ID      `json:"id"`
+	OrgID       entityid.ID      `json:"orgId"`
+	UserID      entityid.ID      `json:"userId"`
+	Email       string           `json:"email"`
+	Role        OrgRole          `json:"role"`
+	Status      InvitationStatus `json:"status"`
+	InvitedBy   entityid.ID      `json:"invitedBy"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	RespondedAt *time.Time       `json:"respondedAt"`
+}
+
+// ScopeToOrg hides the projects, and the todos in them, that belong to other
+// organizations, so the rest of the application only sees the organization's
+// data. Hidden projects and todos are still saved with the user; see
+// AllProjects and AllTodos.
+func (u *User) ScopeToOrg(orgID entityid.ID) {
+	projects, todos := u.AllProjects(), u.AllTodos()
+	u.OrgID = orgID
+	u.Projects, u.hiddenProjects = nil, nil
+	u.Todos, u.hiddenTodos = nil, nil
+
+	inOrg := make(map[entityid.ID]bool)
+	for _, project := range projects {
+		if project.OrgID == orgID {
+			inOrg[project.ID] = true
+			u.Projects = append(u.Projects, project)
+		} else {
+			u.hiddenProjects = append(u.hiddenProjects, project)
+		}
+	}
+	// Todos without a project are about to be moved into the inbox of the
+	// organization being scoped to.
+	for _, todo := range todos {
+		if todo.ProjectID == "" || inOrg[todo.ProjectID] {
+			u.Todos = append(u.Todos, todo)
+		} else {
+			u.hiddenTodos = append(u.hiddenTodos, todo)
+		}
+	}
+}
+
+// LimitToOrg scopes a user that was loaded with only the projects and todos
+// of the organization to it. Unlike a user scoped by ScopeToOrg it doesn't
+// hold the other organizations' data, so it can only be saved in the
+// organization; see Limited.
+func (u *User) LimitToOrg(orgID entityid.ID) {
+	u.ScopeToOrg(orgID)
+	u.limited = true
+}
+
+// Limited reports whether the user holds only the projects and todos of
+// OrgID, having been loaded with LimitToOrg.
+func (u *User) Limited() bool {
+	return u.limited
+}
+
+// AllProjects returns the user's projects in every organization.
+func (u *User) AllProjects() Projects {
+	return append(append(Projects(nil), u.Projects...), u.hiddenProjects...)
+}
+
+// AllTodos returns the user's todos in every organization.
+func (u *User) AllTodos() Todos {
+	return append(append(Todos(nil), u.Todos...), u.hiddenTodos...)
+}
@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func newOrgsUser() *User {
+	return &User{
+		ID: "user",
+		Projects: Projects{
+			{ID: "a-inbox", OrgID: "a", Inbox: true},
+			{ID: "a-work", OrgID: "a"},
+			{ID: "b-inbox", OrgID: "b", Inbox: true},
+		},
+		Todos: Todos{
+			{ID: "a-1", ProjectID: "a-inbox"},
+			{ID: "a-2", ProjectID: "a-work"},
+			{ID: "b-1", ProjectID: "b-inbox"},
+		},
+	}
+}
+
+func orgIDs(projects Projects, todos Todos) []entityid.ID {
+	var ids []entityid.ID
+	for _, project := range projects {
+		ids = append(ids, project.ID)
+	}
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []entityid.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUser_ScopeToOrg(t *testing.T) {
+	tests := []struct {
+		orgID    entityid.ID
+		expected []entityid.ID
+	}{
+		{"a", []entityid.ID{"a-inbox", "a-work", "a-1", "a-2"}},
+		{"b", []entityid.ID{"b-inbox", "b-1"}},
+		{"c", nil},
+	}
+
+	for _, test := range tests {
+		user := newOrgsUser()
+		user.ScopeToOrg(test.orgID)
+		if got := orgIDs(user.Projects, user.Todos); !equalIDs(got, test.expected) {
+			t.Errorf("ScopeToOrg(%q) left %v, expected %v", test.orgID, got, test.expected)
+		}
+		if user.FindTodo("b-1").ID != "" && test.orgID != "b" {
+			t.Errorf("ScopeToOrg(%q) let org %q find another org's todo", test.orgID, test.orgID)
+		}
+	}
+}
+
+func TestUser_ScopeToOrg_keepsOtherOrgs(t *testing.T) {
+	user := newOrgsUser()
+	user.ScopeToOrg("a")
+	user.ScopeToOrg("b")
+
+	expected := []entityid.ID{"b-inbox", "b-1"}
+	if got := orgIDs(user.Projects, user.Todos); !equalIDs(got, expected) {
+		t.Errorf("ScopeToOrg() after rescoping left %v, expected %v", got, expected)
+	}
+	expected = []entityid.ID{"b-inbox", "a-inbox", "a-work", "b-1", "a-1", "a-2"}
+	if got := orgIDs(user.AllProjects(), user.AllTodos()); !equalIDs(got, expected) {
+		t.Errorf("AllProjects() and AllTodos() = %v, expected %v", got, expected)
+	}
+}
+
+func TestUser_ScopeToOrg_inbox(t *testing.T) {
+	user := newOrgsUser()
+	user.Todos = append(user.Todos, &Todo{ID: "loose"})
+	user.ScopeToOrg("c")
+
+	inbox := user.EnsureInbox(time.Now())
+	if inbox.OrgID != "c" {
+		t.Errorf("EnsureInbox().OrgID = %q, expected %q", inbox.OrgID, "c")
+	}
+	if todo := user.FindTodo("loose"); todo.ProjectID != inbox.ID {
+		t.Errorf("loose todo ProjectID = %q, expected %q", todo.ProjectID, inbox.ID)
+	}
+	if got := len(user.AllProjects()); got != 4 {
+		t.Errorf("len(AllProjects()) = %d, expected %d", got, 4)
+	}
+}
+
+func TestUser_DeleteTag_otherOrgs(t *testing.T) {
+	tag := &Tag{ID: "tag"}
+	user := newOrgsUser()
+	user.Tags = Tags{tag}
+	for _, todo := range user.Todos {
+		todo.AddTag(tag)
+	}
+	user.ScopeToOrg("a")
+
+	user.DeleteTag("tag")
+	for _, todo := range user.AllTodos() {
+		if todo.HasTag("tag") {
+			t.Errorf("todo %s still has the deleted tag", todo.ID)
+		}
+	}
+}
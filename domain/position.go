@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"sort"
+	"time"
+
+	"github.com/DillonStreator/todos/ordering"
+)
+
+// SortByPosition orders the todos by their manual position. Todos sharing a
+// position fall back to creation order.
+func (todos Todos) SortByPosition() {
+	sort.SliceStable(todos, func(i, j int) bool {
+		if todos[i].Position != todos[j].Position {
+			return todos[i].Position < todos[j].Position
+		}
+		return todos[i].CreatedAt.Before(todos[j].CreatedAt)
+	})
+}
+
+// LastPosition returns the greatest position among the todos.
+func (todos Todos) LastPosition() string {
+	last := ""
+	for _, todo := range todos {
+		if todo.Position > last {
+			last = todo.Position
+		}
+	}
+	return last
+}
+
+// EnsurePositions gives todos created before manual ordering existed a
+// position after every positioned todo, keeping their creation order. It
+// returns the todos that were changed.
+func (u *User) EnsurePositions() Todos {
+	unpositioned := u.Todos.Filter(func(t *Todo) bool { return t.Position == "" })
+	if len(unpositioned) == 0 {
+		return nil
+	}
+	unpositioned.SortByPosition()
+
+	last := u.Todos.LastPosition()
+	if last == "" {
+		for i, key := range ordering.Spread(len(unpositioned)) {
+			unpositioned[i].Position = key
+		}
+		return unpositioned
+	}
+
+	for _, todo := range unpositioned {
+		key, err := ordering.Between(last, "")
+		if err != nil {
+			break
+		}
+		todo.Position = key
+		last = key
+	}
+	return unpositioned
+}
+
+// RebalancePositions keeps the current order of the todos and spreads their
+// positions evenly, once repeated moves into the same gap have made them too
+// long. It returns the todos that were changed.
+func (u *User) RebalancePositions() Todos {
+	todos := append(Todos(nil), u.Todos...)
+	todos.SortByPosition()
+
+	var changed Todos
+	for i, key := range ordering.Spread(len(todos)) {
+		if todos[i].Position != key {
+			todos[i].Position = key
+			changed = append(changed, todos[i])
+		}
+	}
+	return changed
+}
+
+// MoveTodo gives the todo a new position. Positions aren't part of the todo's
+// history, so the event for the move is raised here.
+func (u *User) MoveTodo(todo *Todo, position string, now time.Time) {
+	todo.Position = position
+	snapshot := todo.Snapshot()
+	u.RecordTodoChange(todo.ID, todo, &snapshot, &snapshot, now)
+}
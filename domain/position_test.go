@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUser_RebalancePositions(t *testing.T) {
+	now := time.Now()
+	user := &User{Todos: Todos{
+		{ID: "a", Position: "V", CreatedAt: now.Add(time.Minute)},
+		{ID: "b", Position: "V", CreatedAt: now},
+		{ID: "c", Position: "K"},
+	}}
+
+	user.RebalancePositions()
+
+	sorted := append(Todos(nil), user.Todos...)
+	sorted.SortByPosition()
+	order := ""
+	for i, todo := range sorted {
+		order += todo.ID.String()
+		if i > 0 && sorted[i-1].Position == todo.Position {
+			t.Errorf("RebalancePositions() left %s and %s sharing a position", sorted[i-1].ID, todo.ID)
+		}
+	}
+	// Todos sharing a position keep their creation order, as when listed.
+	if order != "cba" {
+		t.Errorf("RebalancePositions() order = %s, expected cba", order)
+	}
+	if changed := user.RebalancePositions(); len(changed) != 0 {
+		t.Errorf("RebalancePositions() changed %d todos when already spread, expected 0", len(changed))
+	}
+}
+
+func TestUser_MoveTodo(t *testing.T) {
+	todo := &Todo{ID: "a", Position: "K"}
+	user := &User{Todos: Todos{todo}}
+
+	user.MoveTodo(todo, "V", time.Now())
+	if todo.Position != "V" {
+		t.Errorf("Position = %q, expected V", todo.Position)
+	}
+	events := user.Events()
+	if len(events) != 1 || events[0].Type != EventTodoUpdated || events[0].SubjectID != "a" {
+		t.Errorf("Events() = %+v, expected a %s event for a", events, EventTodoUpdated)
+	}
+}
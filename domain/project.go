@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+const InboxProjectName = "Inbox"
+
+// Project is a named list of todos. Every user has exactly one inbox project
+// which todos land in when no other project is given.
+type Project struct {
+	ID         entityid.ID `json:"id"`
+	OrgID      entityid.ID `json:"orgId"`
+	Name       string      `json:"name"`
+	Inbox      bool        `json:"inbox"`
+	ArchivedAt *time.Time  `json:"archivedAt"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+func (p *Project) Archived() bool {
+	return p.ArchivedAt != nil
+}
+
+type Projects []*Project
+
+func (projects Projects) FindByID(id entityid.ID) *Project {
+	if index := projects.FindIndexByID(id); index != -1 {
+		return projects[index]
+	}
+	return &Project{}
+}
+
+func (projects Projects) FindIndexByID(id entityid.ID) int {
+	for i, project := range projects {
+		if project.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// EnsureInbox returns the user's inbox in the organization they are scoped to,
+// creating it if needed, and moves any todos without a project into it.
+func (u *User) EnsureInbox(now time.Time) *Project {
+	var inbox *Project
+	for _, project := range u.Projects {
+		if project.Inbox {
+			inbox = project
+			break
+		}
+	}
+	if inbox == nil {
+		inbox = &Project{
+			ID:        entityid.Generator.Generate(),
+			OrgID:     u.OrgID,
+			Name:      InboxProjectName,
+			Inbox:     true,
+			CreatedAt: now,
+		}
+		u.Projects = append(u.Projects, inbox)
+	}
+
+	for _, todo := range u.Todos {
+		if todo.ProjectID == "" {
+			todo.ProjectID = inbox.ID
+		}
+	}
+
+	return inbox
+}
+
+// DeleteProject removes the project, moving its todos, including those in the
+// trash, into the inbox. The inbox itself can't be deleted.
+func (u *User) DeleteProject(id entityid.ID, now time.Time) bool {
+	index := u.Projects.FindIndexByID(id)
+	if index == -1 || u.Projects[index].Inbox {
+		return false
+	}
+	u.Projects = append(u.Projects[:index], u.Projects[index+1:]...)
+
+	inbox := u.EnsureInbox(now)
+	for _, todo := range u.Todos {
+		if todo.ProjectID == id {
+			todo.ProjectID = inbox.ID
+		}
+	}
+	return true
+}
+
+// ProjectTodos returns the todos that belong to the project, excluding the
+// trash.
+func (u *User) ProjectTodos(id entityid.ID) Todos {
+	return u.Todos.Filter(func(t *Todo) bool { return t.ProjectID == id && !t.Deleted() })
+}
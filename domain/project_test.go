@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func newProjectsUser() *User {
+	deletedAt := time.Now()
+	return &User{
+		Projects: Projects{
+			{ID: "inbox", Inbox: true},
+			{ID: "work"},
+			{ID: "home"},
+		},
+		Todos: Todos{
+			{ID: "a", ProjectID: "work"},
+			{ID: "b", ProjectID: "work", DeletedAt: &deletedAt},
+			{ID: "c", ProjectID: "home"},
+		},
+	}
+}
+
+func TestUser_DeleteProject(t *testing.T) {
+	tests := []struct {
+		name     string
+		project  entityid.ID
+		deleted  bool
+		projects []entityid.ID
+		inInbox  []entityid.ID
+	}{
+		{"moves the project's todos into the inbox", "work", true, []entityid.ID{"inbox", "home"}, []entityid.ID{"a", "b"}},
+		{"keeps the inbox", "inbox", false, []entityid.ID{"inbox", "work", "home"}, nil},
+		{"ignores unknown projects", "play", false, []entityid.ID{"inbox", "work", "home"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newProjectsUser()
+			if deleted := user.DeleteProject(tt.project, time.Now()); deleted != tt.deleted {
+				t.Errorf("DeleteProject() = %v, expected %v", deleted, tt.deleted)
+			}
+			if actual := orgIDs(user.Projects, nil); !equalIDs(actual, tt.projects) {
+				t.Errorf("Projects = %v, expected %v", actual, tt.projects)
+			}
+			var inInbox []entityid.ID
+			for _, todo := range user.Todos {
+				if todo.ProjectID == "inbox" {
+					inInbox = append(inInbox, todo.ID)
+				}
+			}
+			if !equalIDs(inInbox, tt.inInbox) {
+				t.Errorf("todos in the inbox = %v, expected %v", inInbox, tt.inInbox)
+			}
+		})
+	}
+}
+
+func TestUser_EnsureInbox(t *testing.T) {
+	tests := []struct {
+		name     string
+		projects Projects
+		created  bool
+	}{
+		{"uses the existing inbox", Projects{{ID: "inbox", OrgID: "org", Name: InboxProjectName, Inbox: true}}, false},
+		{"creates an inbox in the organization", Projects{{ID: "work", OrgID: "org"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &User{
+				OrgID:    "org",
+				Projects: tt.projects,
+				Todos:    Todos{{ID: "a"}, {ID: "b", ProjectID: "work"}},
+			}
+
+			inbox := user.EnsureInbox(time.Now())
+			if !inbox.Inbox || inbox.OrgID != "org" || inbox.Name != InboxProjectName {
+				t.Errorf("EnsureInbox() = %+v, expected an inbox in org", inbox)
+			}
+			if created := inbox.ID != "inbox"; created != tt.created {
+				t.Errorf("EnsureInbox() created = %v, expected %v", created, tt.created)
+			}
+			if projectID := user.Todos.FindByID("a").ProjectID; projectID != inbox.ID {
+				t.Errorf("todo without a project ProjectID = %s, expected the inbox %s", projectID, inbox.ID)
+			}
+			if projectID := user.Todos.FindByID("b").ProjectID; projectID != "work" {
+				t.Errorf("todo in a project ProjectID = %s, expected it kept in work", projectID)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/recurrence"
+)
+
+var ErrSeriesEnded = errors.New("recurring todo has no further occurrences")
+
+// RecurrenceRule parses the todo's recurrence rule. It returns nil when the
+// todo doesn't repeat.
+func (t *Todo) RecurrenceRule() (*recurrence.Rule, error) {
+	if t.Recurrence == "" {
+		return nil, nil
+	}
+	if t.DueAt == nil {
+		return nil, errors.New("recurring todos require a due date")
+	}
+	return recurrence.Parse(t.Recurrence)
+}
+
+func (t *Todo) recurrenceStart() time.Time {
+	if t.RecurrenceStart != nil {
+		return *t.RecurrenceStart
+	}
+	return *t.DueAt
+}
+
+// UpcomingOccurrences returns the due dates of the next n occurrences after
+// the current one.
+func (t *Todo) UpcomingOccurrences(n int, loc *time.Location) ([]time.Time, error) {
+	rule, err := t.RecurrenceRule()
+	if err != nil || rule == nil {
+		return nil, err
+	}
+	return rule.Occurrences(t.recurrenceStart(), *t.DueAt, n, loc), nil
+}
+
+// NextOccurrence returns a new todo for the occurrence following this one.
+// The caller assigns its ID and timestamps. It returns ErrSeriesEnded when
+// the rule has no further occurrences.
+func (t *Todo) NextOccurrence(loc *time.Location) (*Todo, error) {
+	rule, err := t.RecurrenceRule()
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, ErrSeriesEnded
+	}
+
+	dueAt, ok := rule.Next(t.recurrenceStart(), *t.DueAt, loc)
+	if !ok {
+		return nil, ErrSeriesEnded
+	}
+	start := t.recurrenceStart()
+
+	return &Todo{
+		ProjectID:       t.ProjectID,
+		ParentID:        t.ParentID,
+		Title:           t.Title,
+		Description:     t.Description,
+		DueAt:           &dueAt,
+		AllDay:          t.AllDay,
+		ReminderOffsets: t.ReminderOffsets,
+		Recurrence:      t.Recurrence,
+		RecurrenceStart: &start,
+		Priority:        t.Priority,
+		Tags:            append(Tags(nil), t.Tags...),
+	}, nil
+}
+
+// SkipOccurrence moves the todo to its next occurrence without completing it.
+func (t *Todo) SkipOccurrence(loc *time.Location) error {
+	next, err := t.NextOccurrence(loc)
+	if err != nil {
+		return err
+	}
+	t.DueAt = next.DueAt
+	t.RecurrenceStart = next.RecurrenceStart
+	t.RemindedAt = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// Role is the access a user has to a project. The owner has every permission;
+// other users get a role by accepting an invitation.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+// Permission is something a role may allow on a shared project.
+type Permission int
+
+const (
+	// PermissionView allows reading the project and its todos.
+	PermissionView Permission = iota
+	// PermissionEdit allows creating, changing and deleting its todos.
+	PermissionEdit
+	// PermissionManage allows renaming the project and managing its members.
+	PermissionManage
+	// PermissionOwn allows archiving and deleting the project.
+	PermissionOwn
+)
+
+// Valid reports whether the role can be given to a member. The owner role
+// can't.
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleEditor || r == RoleViewer
+}
+
+// Allows reports whether the role grants the permission. Each role includes
+// the permissions of the roles below it.
+func (r Role) Allows(p Permission) bool {
+	switch r {
+	case RoleOwner:
+		return true
+	case RoleAdmin:
+		return p <= PermissionManage
+	case RoleEditor:
+		return p <= PermissionEdit
+	case RoleViewer:
+		return p == PermissionView
+	default:
+		return false
+	}
+}
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+)
+
+// ProjectMember is a user invited to another user's project. UserID is set
+// once the invitation is accepted; until then the invitee is known only by
+// email.
+type ProjectMember struct {
+	ID          entityid.ID      `json:"id"`
+	ProjectID   entityid.ID      `json:"projectId"`
+	OwnerID     entityid.ID      `json:"ownerId"`
+	UserID      entityid.ID      `json:"userId"`
+	Email       string           `json:"email"`
+	Role        Role             `json:"role"`
+	Status      InvitationStatus `json:"status"`
+	InvitedBy   entityid.ID      `json:"invitedBy"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	RespondedAt *time.Time       `json:"respondedAt"`
+}
+
+// Unassign clears the assignee of the project's todos assigned to userID, for
+// when they lose access to the project. It returns the todos that changed.
+func (u *User) Unassign(projectID, userID entityid.ID) Todos {
+	var unassigned Todos
+	for _, todo := range u.Todos {
+		if todo.ProjectID == projectID && todo.AssigneeID == userID {
+			todo.AssigneeID = ""
+			unassigned = append(unassigned, todo)
+		}
+	}
+	return unassigned
+}
@@ -0,0 +1,61 @@
+package domain
+
+import "testing"
+
+func TestRole_Allows(t *testing.T) {
+	tests := []struct {
+		role       Role
+		permission Permission
+		expected   bool
+	}{
+		{RoleViewer, PermissionView, true},
+		{RoleViewer, PermissionEdit, false},
+		{RoleEditor, PermissionEdit, true},
+		{RoleEditor, PermissionManage, false},
+		{RoleAdmin, PermissionManage, true},
+		{RoleAdmin, PermissionOwn, false},
+		{RoleOwner, PermissionOwn, true},
+		{Role(""), PermissionView, false},
+	}
+
+	for _, test := range tests {
+		if got := test.role.Allows(test.permission); got != test.expected {
+			t.Errorf("%q.Allows(%d) = %v, expected %v", test.role, test.permission, got, test.expected)
+		}
+	}
+}
+
+func TestRole_Valid(t *testing.T) {
+	tests := []struct {
+		role     Role
+		expected bool
+	}{
+		{RoleViewer, true},
+		{RoleEditor, true},
+		{RoleAdmin, true},
+		{RoleOwner, false},
+		{Role("superuser"), false},
+	}
+
+	for _, test := range tests {
+		if got := test.role.Valid(); got != test.expected {
+			t.Errorf("%q.Valid() = %v, expected %v", test.role, got, test.expected)
+		}
+	}
+}
+
+func TestUser_Unassign(t *testing.T) {
+	user := &User{Todos: Todos{
+		{ID: "a", ProjectID: "p", AssigneeID: "m"},
+		{ID: "b", ProjectID: "p", AssigneeID: "o"},
+		{ID: "c", ProjectID: "q", AssigneeID: "m"},
+	}}
+
+	unassigned := user.Unassign("p", "m")
+	if len(unassigned) != 1 || unassigned[0].ID != "a" {
+		t.Errorf("Unassign() = %v, expected a", unassigned)
+	}
+	if user.Todos[0].AssigneeID != "" || user.Todos[1].AssigneeID != "o" || user.Todos[2].AssigneeID != "m" {
+		t.Errorf("Unassign() left assignees %q, %q, %q", user.Todos[0].AssigneeID, user.Todos[1].AssigneeID, user.Todos[2].AssigneeID)
+	}
+}
@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+var (
+	ErrParentNotFound = errors.New("parent todo not found")
+	ErrParentCycle    = errors.New("a todo cannot be nested under itself or its subtasks")
+	ErrMaxDepth       = errors.New("subtasks are nested too deeply")
+)
+
+// Progress counts how many of a todo's direct subtasks are completed.
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Children returns the direct subtasks of the todo ordered by position.
+func (todos Todos) Children(id entityid.ID) Todos {
+	children := todos.Filter(func(t *Todo) bool { return t.ParentID == id })
+	children.SortByPosition()
+	return children
+}
+
+// Descendants returns every subtask nested under the todo, at any depth.
+func (todos Todos) Descendants(id entityid.ID) Todos {
+	var descendants Todos
+	for _, child := range todos.Children(id) {
+		descendants = append(descendants, child)
+		descendants = append(descendants, todos.Descendants(child.ID)...)
+	}
+	return descendants
+}
+
+// Depth returns how many ancestors the todo has. Top level todos have depth 0.
+func (todos Todos) Depth(id entityid.ID) int {
+	depth := 0
+	for todo := todos.FindByID(id); todo.ParentID != ""; todo = todos.FindByID(todo.ParentID) {
+		depth++
+		if depth > len(todos) {
+			break
+		}
+	}
+	return depth
+}
+
+// height returns the depth of the deepest subtask below the todo, relative to it.
+func (todos Todos) height(id entityid.ID) int {
+	height := 0
+	for _, child := range todos.Children(id) {
+		if h := todos.height(child.ID) + 1; h > height {
+			height = h
+		}
+	}
+	return height
+}
+
+// ValidateParent checks that the todo, with its subtasks, can be nested under
+// parentID without creating a cycle or exceeding maxDepth.
+func (todos Todos) ValidateParent(todo *Todo, parentID entityid.ID, maxDepth int) error {
+	if parentID == "" {
+		return nil
+	}
+	parent := todos.FindByID(parentID)
+	if parent.ID == "" {
+		return ErrParentNotFound
+	}
+	if parent.ID == todo.ID || todos.Descendants(todo.ID).FindIndexByID(parent.ID) != -1 {
+		return ErrParentCycle
+	}
+	if todos.Depth(parent.ID)+1+todos.height(todo.ID) > maxDepth {
+		return ErrMaxDepth
+	}
+	return nil
+}
+
+// ComputeProgress sets Progress on every todo that has subtasks.
+func (todos Todos) ComputeProgress() {
+	progress := make(map[entityid.ID]*Progress)
+	for _, todo := range todos {
+		if todo.ParentID == "" || todo.Deleted() {
+			continue
+		}
+		p, ok := progress[todo.ParentID]
+		if !ok {
+			p = &Progress{}
+			progress[todo.ParentID] = p
+		}
+		p.Total++
+		if todo.Completed {
+			p.Done++
+		}
+	}
+	for _, todo := range todos {
+		todo.Progress = progress[todo.ID]
+	}
+}
+
+// CompleteSubtasks marks every subtask nested under the todo as completed and
+// returns the ones that changed.
+func (todos Todos) CompleteSubtasks(id entityid.ID, now time.Time) Todos {
+	var completed Todos
+	for _, descendant := range todos.Descendants(id) {
+		if !descendant.Completed && !descendant.Deleted() {
+			descendant.SetCompleted(true, now)
+			completed = append(completed, descendant)
+		}
+	}
+	return completed
+}
+
+// RemoveTodo removes the todo along with all of its subtasks.
+func (u *User) RemoveTodo(id entityid.ID) bool {
+	if u.Todos.FindIndexByID(id) == -1 {
+		return false
+	}
+	removed := u.Todos.Descendants(id)
+	removed = append(removed, u.Todos.FindByID(id))
+	u.Todos = u.Todos.Filter(func(t *Todo) bool { return removed.FindIndexByID(t.ID) == -1 })
+	return true
+}
@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func TestTodos_ValidateParent(t *testing.T) {
+	todos := Todos{
+		{ID: "a"},
+		{ID: "b", ParentID: "a"},
+		{ID: "c", ParentID: "b"},
+		{ID: "d"},
+	}
+
+	tests := []struct {
+		name     string
+		todo     string
+		parent   string
+		maxDepth int
+		expected error
+	}{
+		{"allows nesting within the max depth", "d", "b", 2, nil},
+		{"rejects nesting past the max depth", "d", "c", 2, ErrMaxDepth},
+		{"counts the subtasks being moved", "b", "d", 1, ErrMaxDepth},
+		{"rejects nesting under a subtask", "a", "c", 5, ErrParentCycle},
+		{"rejects nesting under itself", "a", "a", 5, ErrParentCycle},
+		{"rejects unknown parents", "a", "z", 5, ErrParentNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := todos.ValidateParent(todos.FindByID(entityid.ID(tt.todo)), entityid.ID(tt.parent), tt.maxDepth)
+			if actual != tt.expected {
+				t.Errorf("ValidateParent() = %v, expected %v", actual, tt.expected)
+			}
+		})
+	}
+}
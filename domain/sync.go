@@ -0,0 +1,60 @@
+package domain
+
+import "time"
+
+const (
+	SyncWinnerClient = "client"
+	SyncWinnerServer = "server"
+)
+
+// FieldConflict reports a field that a client changed while offline and that
+// was also changed on the server since the version the client started from.
+type FieldConflict struct {
+	Field           string    `json:"field"`
+	Winner          string    `json:"winner"`
+	ServerChangedAt time.Time `json:"serverChangedAt"`
+}
+
+// historyField maps a todo's JSON field to the field it is recorded under in
+// history.
+func historyField(field string) string {
+	if field == "tags" {
+		return "tagIds"
+	}
+	return field
+}
+
+// ResolveFieldConflicts decides which of the fields a client changed at
+// changedAt, starting from baseVersion of a todo, should be applied. Fields
+// changed on the server after baseVersion, according to the todo's history, go
+// to whichever change was made last; ties go to the server.
+func ResolveFieldConflicts(fields []string, baseVersion int, changedAt time.Time, history []*HistoryEntry) ([]string, []FieldConflict) {
+	serverChangedAt := make(map[string]time.Time)
+	for _, entry := range history {
+		if entry.Version <= baseVersion {
+			continue
+		}
+		for _, change := range entry.Changes {
+			if entry.CreatedAt.After(serverChangedAt[change.Field]) {
+				serverChangedAt[change.Field] = entry.CreatedAt
+			}
+		}
+	}
+
+	var apply []string
+	var conflicts []FieldConflict
+	for _, field := range fields {
+		serverTime, changed := serverChangedAt[historyField(field)]
+		if !changed {
+			apply = append(apply, field)
+			continue
+		}
+		conflict := FieldConflict{Field: field, Winner: SyncWinnerServer, ServerChangedAt: serverTime}
+		if changedAt.After(serverTime) {
+			conflict.Winner = SyncWinnerClient
+			apply = append(apply, field)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return apply, conflicts
+}
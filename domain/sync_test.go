@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveFieldConflicts(t *testing.T) {
+	base := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	history := []*HistoryEntry{
+		{Version: 1, CreatedAt: base, Changes: []FieldChange{{Field: "title"}, {Field: "description"}}},
+		{Version: 2, CreatedAt: base.Add(time.Hour), Changes: []FieldChange{{Field: "title"}}},
+		{Version: 3, CreatedAt: base.Add(2 * time.Hour), Changes: []FieldChange{{Field: "tagIds"}}},
+	}
+
+	t.Run("applies fields the server hasn't changed", func(t *testing.T) {
+		apply, conflicts := ResolveFieldConflicts([]string{"description", "priority"}, 1, base, history)
+		if expected := []string{"description", "priority"}; !reflect.DeepEqual(apply, expected) {
+			t.Errorf("ResolveFieldConflicts() apply = %v, expected %v", apply, expected)
+		}
+		if len(conflicts) != 0 {
+			t.Errorf("ResolveFieldConflicts() conflicts = %+v, expected none", conflicts)
+		}
+	})
+	t.Run("last writer wins", func(t *testing.T) {
+		apply, conflicts := ResolveFieldConflicts([]string{"title", "tags"}, 1, base.Add(90*time.Minute), history)
+		if expected := []string{"title"}; !reflect.DeepEqual(apply, expected) {
+			t.Errorf("ResolveFieldConflicts() apply = %v, expected %v", apply, expected)
+		}
+		expected := []FieldConflict{
+			{Field: "title", Winner: SyncWinnerClient, ServerChangedAt: base.Add(time.Hour)},
+			{Field: "tags", Winner: SyncWinnerServer, ServerChangedAt: base.Add(2 * time.Hour)},
+		}
+		if !reflect.DeepEqual(conflicts, expected) {
+			t.Errorf("ResolveFieldConflicts() conflicts = %+v, expected %+v", conflicts, expected)
+		}
+	})
+	t.Run("no conflicts from the current version", func(t *testing.T) {
+		apply, conflicts := ResolveFieldConflicts([]string{"title"}, 3, base, history)
+		if len(apply) != 1 || len(conflicts) != 0 {
+			t.Errorf("ResolveFieldConflicts() = %v, %+v, expected [title] and no conflicts", apply, conflicts)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+type Priority string
+
+const (
+	PriorityNone   Priority = ""
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+)
+
+func (p Priority) Valid() bool {
+	switch p {
+	case PriorityNone, PriorityLow, PriorityMedium, PriorityHigh:
+		return true
+	}
+	return false
+}
+
+// Tag is a user scoped label that can be attached to any of the user's todos.
+type Tag struct {
+	ID        entityid.ID `json:"id"`
+	Name      string      `json:"name"`
+	Color     string      `json:"color"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+type Tags []*Tag
+
+func (tags Tags) FindByID(id entityid.ID) *Tag {
+	if index := tags.FindIndexByID(id); index != -1 {
+		return tags[index]
+	}
+	return &Tag{}
+}
+
+func (tags Tags) FindIndexByID(id entityid.ID) int {
+	for i, tag := range tags {
+		if tag.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// FindByName looks up a tag by name, ignoring case.
+func (tags Tags) FindByName(name string) *Tag {
+	for _, tag := range tags {
+		if strings.EqualFold(tag.Name, name) {
+			return tag
+		}
+	}
+	return &Tag{}
+}
+
+// DeleteTag removes the tag from the user and from every todo it is attached
+// to, in every organization since tags belong to the user.
+func (u *User) DeleteTag(id entityid.ID) bool {
+	index := u.Tags.FindIndexByID(id)
+	if index == -1 {
+		return false
+	}
+	u.Tags = append(u.Tags[:index], u.Tags[index+1:]...)
+	for _, todo := range u.AllTodos() {
+		todo.RemoveTag(id)
+	}
+	return true
+}
+
+func (t *Todo) HasTag(id entityid.ID) bool {
+	return t.Tags.FindIndexByID(id) != -1
+}
+
+// AddTag attaches the tag unless it is already attached.
+func (t *Todo) AddTag(tag *Tag) {
+	if !t.HasTag(tag.ID) {
+		t.Tags = append(t.Tags, tag)
+	}
+}
+
+func (t *Todo) RemoveTag(id entityid.ID) {
+	if index := t.Tags.FindIndexByID(id); index != -1 {
+		t.Tags = append(t.Tags[:index], t.Tags[index+1:]...)
+	}
+}
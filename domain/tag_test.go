@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func tagIDs(tags Tags) []entityid.ID {
+	var ids []entityid.ID
+	for _, tag := range tags {
+		ids = append(ids, tag.ID)
+	}
+	return ids
+}
+
+func TestTodo_AddTag(t *testing.T) {
+	work, home := &Tag{ID: "work"}, &Tag{ID: "home"}
+
+	tests := []struct {
+		name     string
+		tags     Tags
+		add      *Tag
+		expected []entityid.ID
+	}{
+		{"attaches a tag", nil, work, []entityid.ID{"work"}},
+		{"keeps the tags already attached", Tags{home}, work, []entityid.ID{"home", "work"}},
+		{"doesn't attach a tag twice", Tags{work}, work, []entityid.ID{"work"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todo := &Todo{Tags: append(Tags(nil), tt.tags...)}
+			todo.AddTag(tt.add)
+			if actual := tagIDs(todo.Tags); !equalIDs(actual, tt.expected) {
+				t.Errorf("Tags = %v, expected %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTodo_RemoveTag(t *testing.T) {
+	work, home := &Tag{ID: "work"}, &Tag{ID: "home"}
+
+	tests := []struct {
+		name     string
+		tags     Tags
+		remove   entityid.ID
+		expected []entityid.ID
+	}{
+		{"detaches a tag", Tags{home, work}, "work", []entityid.ID{"home"}},
+		{"ignores tags that aren't attached", Tags{home}, "work", []entityid.ID{"home"}},
+		{"detaches the last tag", Tags{work}, "work", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todo := &Todo{Tags: append(Tags(nil), tt.tags...)}
+			todo.RemoveTag(tt.remove)
+			if actual := tagIDs(todo.Tags); !equalIDs(actual, tt.expected) {
+				t.Errorf("Tags = %v, expected %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUser_DeleteTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		delete   entityid.ID
+		deleted  bool
+		tags     []entityid.ID
+		todoTags map[entityid.ID][]entityid.ID
+	}{
+		{
+			"removes the tag from the user and its todos", "work", true,
+			[]entityid.ID{"home"},
+			map[entityid.ID][]entityid.ID{"a": {"home"}, "b": nil},
+		},
+		{
+			"ignores unknown tags", "play", false,
+			[]entityid.ID{"work", "home"},
+			map[entityid.ID][]entityid.ID{"a": {"work", "home"}, "b": {"work"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work, home := &Tag{ID: "work"}, &Tag{ID: "home"}
+			user := &User{
+				Tags: Tags{work, home},
+				Todos: Todos{
+					{ID: "a", Tags: Tags{work, home}},
+					{ID: "b", Tags: Tags{work}},
+				},
+			}
+
+			if deleted := user.DeleteTag(tt.delete); deleted != tt.deleted {
+				t.Errorf("DeleteTag() = %v, expected %v", deleted, tt.deleted)
+			}
+			if actual := tagIDs(user.Tags); !equalIDs(actual, tt.tags) {
+				t.Errorf("Tags = %v, expected %v", actual, tt.tags)
+			}
+			for todoID, expected := range tt.todoTags {
+				if actual := tagIDs(user.Todos.FindByID(todoID).Tags); !equalIDs(actual, expected) {
+					t.Errorf("todo %s Tags = %v, expected %v", todoID, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTags_FindByName(t *testing.T) {
+	tags := Tags{{ID: "work", Name: "Work"}}
+
+	tests := []struct {
+		name     string
+		expected entityid.ID
+	}{
+		{"Work", "work"},
+		{"work", "work"},
+		{"home", ""},
+	}
+	for _, tt := range tests {
+		if actual := tags.FindByName(tt.name).ID; actual != tt.expected {
+			t.Errorf("FindByName(%s) = %q, expected %q", tt.name, actual, tt.expected)
+		}
+	}
+}
+
+func TestPriority_Valid(t *testing.T) {
+	tests := []struct {
+		priority Priority
+		expected bool
+	}{
+		{PriorityNone, true},
+		{PriorityLow, true},
+		{PriorityMedium, true},
+		{PriorityHigh, true},
+		{"urgent", false},
+		{"High", false},
+	}
+	for _, tt := range tests {
+		if actual := tt.priority.Valid(); actual != tt.expected {
+			t.Errorf("Priority(%q).Valid() = %v, expected %v", tt.priority, actual, tt.expected)
+		}
+	}
+}
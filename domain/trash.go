@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func (t *Todo) Deleted() bool {
+	return t.DeletedAt != nil
+}
+
+// Active returns the todos that are not in the trash.
+func (todos Todos) Active() Todos {
+	return todos.Filter(func(t *Todo) bool { return !t.Deleted() })
+}
+
+// Trashed returns the todos that are in the trash.
+func (todos Todos) Trashed() Todos {
+	return todos.Filter(func(t *Todo) bool { return t.Deleted() })
+}
+
+// FindTodo looks up one of the user's todos that is not in the trash.
+func (u *User) FindTodo(id entityid.ID) *Todo {
+	todo := u.Todos.FindByID(id)
+	if todo.Deleted() {
+		return &Todo{}
+	}
+	return todo
+}
+
+// TrashTodo moves the todo and its subtasks to the trash.
+func (u *User) TrashTodo(id entityid.ID, now time.Time) bool {
+	todo := u.FindTodo(id)
+	if todo.ID == "" {
+		return false
+	}
+	todo.DeletedAt = &now
+	for _, descendant := range u.Todos.Descendants(id) {
+		if !descendant.Deleted() {
+			descendant.DeletedAt = &now
+		}
+	}
+	return true
+}
+
+// RestoreTodo takes the todo out of the trash along with the subtasks that
+// were trashed with it. A todo whose parent is still in the trash is restored
+// at the top level, and one whose project is gone or archived goes to the
+// inbox.
+func (u *User) RestoreTodo(id entityid.ID, now time.Time) bool {
+	todo := u.Todos.FindByID(id)
+	if todo.ID == "" || !todo.Deleted() {
+		return false
+	}
+
+	deletedAt := *todo.DeletedAt
+	for _, descendant := range u.Todos.Descendants(id) {
+		if descendant.Deleted() && descendant.DeletedAt.Equal(deletedAt) {
+			descendant.DeletedAt = nil
+		}
+	}
+	todo.DeletedAt = nil
+
+	if parent := u.Todos.FindByID(todo.ParentID); parent.ID == "" || parent.Deleted() {
+		todo.ParentID = ""
+	}
+	if project := u.Projects.FindByID(todo.ProjectID); project.ID == "" || project.Archived() {
+		inbox := u.EnsureInbox(now)
+		todo.ProjectID = inbox.ID
+		for _, descendant := range u.Todos.Descendants(id) {
+			descendant.ProjectID = inbox.ID
+		}
+	}
+	return true
+}
+
+// PurgeTrash removes the todos that have been in the trash since before the
+// given time, and returns how many were removed.
+func (u *User) PurgeTrash(before time.Time) int {
+	count := len(u.Todos)
+	for _, todo := range u.Todos.Trashed() {
+		if todo.DeletedAt.Before(before) {
+			u.RemoveTodo(todo.ID)
+		}
+	}
+	return count - len(u.Todos)
+}
@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUser_PurgeTrash(t *testing.T) {
+	now := time.Now()
+	longAgo := now.AddDate(0, 0, -40)
+	recently := now.AddDate(0, 0, -1)
+	user := &User{Todos: Todos{
+		{ID: "a", DeletedAt: &longAgo},
+		{ID: "b", ParentID: "a", DeletedAt: &longAgo},
+		{ID: "c", DeletedAt: &recently},
+		{ID: "d"},
+	}}
+
+	if purged := user.PurgeTrash(now.AddDate(0, 0, -30)); purged != 2 {
+		t.Errorf("PurgeTrash() = %d, expected 2", purged)
+	}
+	if len(user.Todos) != 2 || user.Todos[0].ID != "c" || user.Todos[1].ID != "d" {
+		t.Errorf("Todos = %v, expected c and d", user.Todos)
+	}
+}
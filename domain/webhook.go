@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// Webhook is an endpoint a user registered to be told about changes to their
+// todos in an organization.
+type Webhook struct {
+	ID         entityid.ID `json:"id"`
+	UserID     entityid.ID `json:"userId"`
+	OrgID      entityid.ID `json:"orgId"`
+	URL        string      `json:"url"`
+	EventTypes []string    `json:"eventTypes"`
+	// Secret signs deliveries. It is only shown when the webhook is created.
+	Secret string `json:"-"`
+	// ConsecutiveFailures counts failed delivery attempts since the last
+	// successful one. The webhook is disabled once it reaches the limit.
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	DisabledAt          *time.Time `json:"disabledAt"`
+	CreatedAt           time.Time  `json:"createdAt"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type.
+func (w *Webhook) Subscribes(eventType string) bool {
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event waiting to be, or that was, sent to a webhook.
+// Redelivering an event creates a new delivery with the same EventID.
+type WebhookDelivery struct {
+	ID        entityid.ID `json:"id"`
+	WebhookID entityid.ID `json:"webhookId"`
+	EventID   entityid.ID `json:"eventId"`
+	EventType string      `json:"eventType"`
+	// RedeliveryOf is the delivery a manual redelivery repeats. Every other
+	// delivery is the only one of its event to its webhook.
+	RedeliveryOf   entityid.ID     `json:"redeliveryOf,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt"`
+	LastAttemptAt  *time.Time      `json:"lastAttemptAt"`
+	ResponseStatus int             `json:"responseStatus"`
+	Error          string          `json:"error"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/DillonStreator/todos/domain"
+)
+
+// todoETag is the ETag of a todo's version, which reads and writes of the
+// todo return and If-Match is compared with.
+func todoETag(todo *domain.Todo) string {
+	return fmt.Sprintf(`"%d"`, todo.Version)
+}
+
+// contentETag derives an ETag from a response body, for responses like lists
+// that have no single version.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match
+// header value. Weak validators are compared by their opaque tag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch responds with 412 Precondition Failed and returns false when
+// the request's If-Match header doesn't match the todo's current version.
+func checkIfMatch(rw http.ResponseWriter, r *http.Request, todo *domain.Todo) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || etagMatches(ifMatch, todoETag(todo)) {
+		return true
+	}
+	rw.Header().Set("ETag", todoETag(todo))
+	respondError(rw, http.StatusPreconditionFailed, ErrorResponse{
+		Errors: []ErrorResponseError{{Message: "Todo has been modified"}},
+	})
+	return false
+}
+
+// notModified sets the ETag header and responds with 304 Not Modified,
+// returning true, when the request's If-None-Match header matches it.
+func notModified(rw http.ResponseWriter, r *http.Request, etag string) bool {
+	rw.Header().Set("ETag", etag)
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag) {
+		return false
+	}
+	rw.WriteHeader(http.StatusNotModified)
+	return true
+}
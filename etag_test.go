@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DillonStreator/todos/storage"
+)
+
+func Test_etagMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		etag     string
+		expected bool
+	}{
+		{"exact match", `"3"`, `"3"`, true},
+		{"different version", `"2"`, `"3"`, false},
+		{"match in list", `"1", "3"`, `"3"`, true},
+		{"weak validator", `W/"3"`, `"3"`, true},
+		{"wildcard", `*`, `"3"`, true},
+		{"unquoted", `3`, `"3"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := etagMatches(tt.header, tt.etag)
+			if actual != tt.expected {
+				t.Errorf("etagMatches(%q, %q) = %v, expected %v", tt.header, tt.etag, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_respondSaveError(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifMatch  string
+		err      error
+		expected int
+	}{
+		{"conditional request", `"3"`, storage.ErrUserConflict, http.StatusPreconditionFailed},
+		{"unconditional request", "", storage.ErrUserConflict, http.StatusConflict},
+		{"other errors", `"3"`, errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/todos/1", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			rw := httptest.NewRecorder()
+			respondSaveError(rw, r, tt.err)
+			if rw.Code != tt.expected {
+				t.Errorf("respondSaveError() status = %d, expected %d", rw.Code, tt.expected)
+			}
+		})
+	}
+}
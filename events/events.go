@@ -0,0 +1,188 @@
+// Package events fans out changes to a user's todos to the clients listening
+// for them.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+const (
+	TodoCreated = "todo.created"
+	TodoUpdated = "todo.updated"
+	TodoDeleted = "todo.deleted"
+)
+
+// Event is a change to one of a user's todos in one of their projects. OrgID is
+// the organization the user sees the project in. Data is the JSON sent to
+// clients.
+type Event struct {
+	ID        string          `json:"id"`
+	UserID    entityid.ID     `json:"userId"`
+	OrgID     entityid.ID     `json:"orgId"`
+	ProjectID entityid.ID     `json:"projectId"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Broker delivers published events to the subscribers of the event's user.
+type Broker interface {
+	Publish(ctx context.Context, event Event) error
+	// Subscribe starts delivering the user's events. If lastEventID is set,
+	// the events published after it are replayed first.
+	Subscribe(userID entityid.ID, lastEventID string) *Subscription
+}
+
+// Subscription receives a user's events until it is closed. Events is closed
+// if the subscriber falls too far behind; it should reconnect with the ID of
+// the last event it handled.
+type Subscription struct {
+	Events <-chan Event
+	// Replay holds the events published after the requested last event ID.
+	Replay []Event
+	// Reset is set when the requested last event ID is no longer in the
+	// replay buffer, so events may have been missed.
+	Reset bool
+
+	close func()
+}
+
+func (s *Subscription) Close() {
+	s.close()
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind before it
+// is dropped.
+const subscriberBuffer = 64
+
+// MemoryBroker is a Broker for a single process. It keeps the last events of
+// each user for replay, until none has been published for the user for a
+// while.
+type MemoryBroker struct {
+	replaySize int
+	replayAge  time.Duration
+	now        func() time.Time
+
+	mu          sync.Mutex
+	subscribers map[entityid.ID]map[chan Event]struct{}
+	replay      map[entityid.ID]*replayBuffer
+	// expired is when buffers were last checked for expiry.
+	expired time.Time
+}
+
+// replayBuffer is a user's last events and when the latest was published.
+type replayBuffer struct {
+	events      []Event
+	publishedAt time.Time
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// NewMemoryBroker returns a broker that keeps replaySize events per user, for
+// replayAge after the latest of them was published.
+func NewMemoryBroker(replaySize int, replayAge time.Duration) *MemoryBroker {
+	return &MemoryBroker{
+		replaySize:  replaySize,
+		replayAge:   replayAge,
+		now:         time.Now,
+		subscribers: make(map[entityid.ID]map[chan Event]struct{}),
+		replay:      make(map[entityid.ID]*replayBuffer),
+	}
+}
+
+func (mb *MemoryBroker) Publish(ctx context.Context, event Event) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	now := mb.now()
+	mb.expire(now)
+	buffer := mb.replay[event.UserID]
+	if buffer == nil {
+		buffer = &replayBuffer{}
+		mb.replay[event.UserID] = buffer
+	}
+	buffer.events = append(buffer.events, event)
+	if len(buffer.events) > mb.replaySize {
+		buffer.events = append([]Event(nil), buffer.events[len(buffer.events)-mb.replaySize:]...)
+	}
+	buffer.publishedAt = now
+
+	for events := range mb.subscribers[event.UserID] {
+		select {
+		case events <- event:
+		default:
+			mb.unsubscribe(event.UserID, events)
+		}
+	}
+	return nil
+}
+
+func (mb *MemoryBroker) Subscribe(userID entityid.ID, lastEventID string) *Subscription {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	events := make(chan Event, subscriberBuffer)
+	if mb.subscribers[userID] == nil {
+		mb.subscribers[userID] = make(map[chan Event]struct{})
+	}
+	mb.subscribers[userID][events] = struct{}{}
+
+	subscription := &Subscription{
+		Events: events,
+		close: func() {
+			mb.mu.Lock()
+			defer mb.mu.Unlock()
+			mb.unsubscribe(userID, events)
+		},
+	}
+	if lastEventID != "" {
+		subscription.Reset = true
+		var replay []Event
+		if buffer := mb.replay[userID]; buffer != nil && !mb.stale(buffer, mb.now()) {
+			replay = buffer.events
+		}
+		for i, event := range replay {
+			if event.ID == lastEventID {
+				subscription.Replay = append([]Event(nil), replay[i+1:]...)
+				subscription.Reset = false
+				break
+			}
+		}
+	}
+	return subscription
+}
+
+// expire drops the replay buffers that have gone stale. Checking every buffer
+// is only done once every replayAge, so it costs little per event. It must be
+// called with mu held.
+func (mb *MemoryBroker) expire(now time.Time) {
+	if now.Sub(mb.expired) < mb.replayAge {
+		return
+	}
+	mb.expired = now
+	for userID, buffer := range mb.replay {
+		if mb.stale(buffer, now) {
+			delete(mb.replay, userID)
+		}
+	}
+}
+
+func (mb *MemoryBroker) stale(buffer *replayBuffer, now time.Time) bool {
+	return now.Sub(buffer.publishedAt) >= mb.replayAge
+}
+
+// unsubscribe must be called with mu held.
+func (mb *MemoryBroker) unsubscribe(userID entityid.ID, events chan Event) {
+	if _, ok := mb.subscribers[userID][events]; !ok {
+		return
+	}
+	delete(mb.subscribers[userID], events)
+	if len(mb.subscribers[userID]) == 0 {
+		delete(mb.subscribers, userID)
+	}
+	close(events)
+}
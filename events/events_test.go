@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func publish(t *testing.T, broker Broker, userID entityid.ID, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := broker.Publish(context.Background(), Event{ID: id, UserID: userID, Type: TodoUpdated}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	t.Run("delivers events to the user's subscribers", func(t *testing.T) {
+		broker := NewMemoryBroker(10, time.Hour)
+		subscription := broker.Subscribe("user", "")
+		defer subscription.Close()
+		other := broker.Subscribe("other", "")
+		defer other.Close()
+
+		publish(t, broker, "user", "1")
+
+		if event := <-subscription.Events; event.ID != "1" {
+			t.Errorf("event.ID = %v, expected %v", event.ID, "1")
+		}
+		if len(other.Events) != 0 {
+			t.Errorf("other user received %d events, expected 0", len(other.Events))
+		}
+	})
+	t.Run("replays events after the last event ID", func(t *testing.T) {
+		broker := NewMemoryBroker(10, time.Hour)
+		publish(t, broker, "user", "1", "2", "3")
+
+		subscription := broker.Subscribe("user", "1")
+		defer subscription.Close()
+		if subscription.Reset || len(subscription.Replay) != 2 || subscription.Replay[0].ID != "2" {
+			t.Errorf("Subscribe() = %+v, expected replay of 2 and 3", subscription)
+		}
+	})
+	t.Run("resets when the last event ID has left the buffer", func(t *testing.T) {
+		broker := NewMemoryBroker(2, time.Hour)
+		publish(t, broker, "user", "1", "2", "3")
+
+		subscription := broker.Subscribe("user", "1")
+		defer subscription.Close()
+		if !subscription.Reset {
+			t.Errorf("Subscribe().Reset = %v, expected %v", subscription.Reset, true)
+		}
+	})
+	t.Run("expires the buffers of users without recent events", func(t *testing.T) {
+		now := time.Now()
+		broker := NewMemoryBroker(10, time.Hour)
+		broker.now = func() time.Time { return now }
+		publish(t, broker, "user", "1", "2")
+
+		now = now.Add(time.Hour)
+		subscription := broker.Subscribe("user", "1")
+		subscription.Close()
+		if !subscription.Reset {
+			t.Errorf("Subscribe().Reset = %v, expected %v", subscription.Reset, true)
+		}
+
+		publish(t, broker, "other", "3")
+		if _, ok := broker.replay["user"]; ok {
+			t.Error("replay buffer of user kept, expected it expired")
+		}
+		if len(broker.replay) != 1 {
+			t.Errorf("%d replay buffers, expected 1", len(broker.replay))
+		}
+	})
+	t.Run("drops subscribers that fall behind", func(t *testing.T) {
+		broker := NewMemoryBroker(10, time.Hour)
+		subscription := broker.Subscribe("user", "")
+		defer subscription.Close()
+
+		for i := 0; i <= subscriberBuffer; i++ {
+			publish(t, broker, "user", fmt.Sprint(i))
+		}
+
+		received := 0
+		for range subscription.Events {
+			received++
+		}
+		if received != subscriberBuffer {
+			t.Errorf("received %d events, expected %d", received, subscriberBuffer)
+		}
+	})
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/events"
+	"github.com/DillonStreator/todos/outbox"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var historyStore *storage.HistoryStore
+
+var outboxRelay *outbox.Relay
+
+type todoSnapshotsContextKey string
+
+var TODO_SNAPSHOTS_CONTEXT_KEY = todoSnapshotsContextKey("todoSnapshots")
+
+// requestSetTodoSnapshots remembers the state of the user's todos as loaded so
+// saveUser can record what the request changed.
+func requestSetTodoSnapshots(r *http.Request, user *domain.User) *http.Request {
+	snapshots := map[entityid.ID]map[entityid.ID]domain.TodoSnapshot{}
+	ctx := context.WithValue(r.Context(), TODO_SNAPSHOTS_CONTEXT_KEY, snapshots)
+	r = r.WithContext(ctx)
+	requestAddTodoSnapshots(r, user)
+	return r
+}
+
+// requestAddTodoSnapshots remembers the state of another user's todos, loaded
+// because the request works on a project they shared.
+func requestAddTodoSnapshots(r *http.Request, user *domain.User) {
+	users, _ := r.Context().Value(TODO_SNAPSHOTS_CONTEXT_KEY).(map[entityid.ID]map[entityid.ID]domain.TodoSnapshot)
+	if users == nil {
+		return
+	}
+	users[user.ID] = todoSnapshots(user)
+}
+
+func requestGetTodoSnapshots(r *http.Request, userID entityid.ID) map[entityid.ID]domain.TodoSnapshot {
+	users, _ := r.Context().Value(TODO_SNAPSHOTS_CONTEXT_KEY).(map[entityid.ID]map[entityid.ID]domain.TodoSnapshot)
+	return users[userID]
+}
+
+// saveUser saves the user aggregate along with a history entry and a domain
+// event for every todo the request created, changed or removed, then
+// publishes an event for each of them and notifies anyone a todo was newly
+// assigned to.
+func saveUser(r *http.Request, user *domain.User) error {
+	return saveUserAs(r, user, "")
+}
+
+// saveUserAs is saveUser with the history action of changed todos set
+// explicitly instead of inferred.
+func saveUserAs(r *http.Request, user *domain.User, action domain.HistoryAction) error {
+	snapshots := requestGetTodoSnapshots(r, user.ID)
+	if snapshots == nil {
+		return saveUserEvents(user)
+	}
+
+	changed, assigned := recordTodoChanges(user, snapshots, requestGetUser(r).ID, action, time.Now())
+	// Progress is computed before saving so the events raised carry it.
+	user.Todos.ComputeProgress()
+	err := saveUserEvents(user)
+	if err != nil {
+		return err
+	}
+
+	for todoID := range snapshots {
+		delete(snapshots, todoID)
+	}
+	for _, todo := range user.Todos {
+		snapshots[todo.ID] = todo.Snapshot()
+	}
+
+	publishTodoChanges(user, changed)
+	for _, todo := range assigned {
+		notifyAssignee(r, user, todo)
+	}
+	return nil
+}
+
+// maxSaveAttempts is how many times work done outside a request loads and
+// saves a user before giving up on other saves of it getting in first.
+const maxSaveAttempts = 3
+
+// changeUser makes a change outside of a request, on behalf of actorID. It
+// loads the user with the todos of every organization, lets change modify it
+// and saves it like saveUser does, starting over from a fresh copy when
+// another save of the user gets in first. change returns false when there is
+// nothing to save.
+func changeUser(userID, actorID entityid.ID, change func(user *domain.User) bool) error {
+	for attempt := 1; ; attempt++ {
+		user := &domain.User{}
+		err := store.FindByID(user, userID.String())
+		if err != nil {
+			return err
+		}
+		snapshots := todoSnapshots(user)
+		if !change(user) {
+			return nil
+		}
+
+		changed, _ := recordTodoChanges(user, snapshots, actorID, "", time.Now())
+		user.Todos.ComputeProgress()
+		err = saveUserEvents(user)
+		if err == storage.ErrUserConflict && attempt < maxSaveAttempts {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		publishTodoChanges(user, changed)
+		return nil
+	}
+}
+
+func todoSnapshots(user *domain.User) map[entityid.ID]domain.TodoSnapshot {
+	snapshots := make(map[entityid.ID]domain.TodoSnapshot, len(user.Todos))
+	for _, todo := range user.Todos {
+		snapshots[todo.ID] = todo.Snapshot()
+	}
+	return snapshots
+}
+
+// todoChange is a todo that was created, changed or removed, to be published
+// once the change is saved.
+type todoChange struct {
+	eventType string
+	todo      *domain.Todo
+}
+
+// recordTodoChanges compares the user's todos with their snapshots from when
+// the user was loaded. Each todo that was created, changed or removed moves
+// to its next version, with a history entry recorded and domain events raised
+// on the user. It returns the changes, and the todos that were newly assigned.
+func recordTodoChanges(user *domain.User, snapshots map[entityid.ID]domain.TodoSnapshot, actorID entityid.ID, action domain.HistoryAction, now time.Time) ([]todoChange, []*domain.Todo) {
+	record := func(todoID entityid.ID, version int, before, after *domain.TodoSnapshot) bool {
+		changes := domain.DiffSnapshots(before, after)
+		if len(changes) == 0 {
+			return false
+		}
+		entryAction := domain.InferHistoryAction(before, after)
+		if action != "" && entryAction == domain.HistoryUpdated {
+			entryAction = action
+		}
+		user.RecordHistory(&domain.HistoryEntry{
+			ID:        entityid.Generator.Generate(),
+			TodoID:    todoID,
+			ActorID:   actorID,
+			Action:    entryAction,
+			Version:   version,
+			Changes:   changes,
+			CreatedAt: now,
+		})
+		return true
+	}
+
+	var changed []todoChange
+	var assigned []*domain.Todo
+	current := make(map[entityid.ID]bool, len(user.Todos))
+	for _, todo := range user.Todos {
+		current[todo.ID] = true
+		after := todo.Snapshot()
+		var before *domain.TodoSnapshot
+		if snapshot, ok := snapshots[todo.ID]; ok {
+			before = &snapshot
+		}
+		if record(todo.ID, todo.Version+1, before, &after) {
+			todo.Version++
+			user.RecordTodoChange(todo.ID, todo, before, &after, now)
+			changed = append(changed, todoChange{todoEventType(before, &after), todo})
+			if after.AssigneeID != "" && (before == nil || before.AssigneeID != after.AssigneeID) {
+				assigned = append(assigned, todo)
+			}
+		}
+	}
+	for todoID, before := range snapshots {
+		if !current[todoID] {
+			before := before
+			if record(todoID, before.Version+1, &before, nil) {
+				user.RecordTodoChange(todoID, nil, &before, nil, now)
+				changed = append(changed, todoChange{events.TodoDeleted, &domain.Todo{ID: todoID, ProjectID: before.ProjectID}})
+			}
+		}
+	}
+	return changed, assigned
+}
+
+func publishTodoChanges(user *domain.User, changed []todoChange) {
+	for _, change := range changed {
+		publishTodoEvent(user, change.eventType, change.todo)
+	}
+}
+
+// saveUserEvents saves the user aggregate with the domain events raised and
+// the history recorded on it, and wakes the outbox relay to dispatch the
+// events. Both are dropped either way so a retried save can't write them
+// twice.
+func saveUserEvents(user *domain.User) error {
+	raised := len(user.Events()) > 0
+	err := storage.SaveUser(context.Background(), store, db, user)
+	user.ClearEvents()
+	user.ClearHistory()
+	if err == nil && raised && outboxRelay != nil {
+		outboxRelay.Wake()
+	}
+	return err
+}
+
+// respondSaveError responds to a failed save of a user. A user saved by
+// another request since this one loaded it is a precondition failure when the
+// request was made conditional with If-Match, since the todo it matched may
+// have changed, and a conflict otherwise. Either way the client can retry.
+func respondSaveError(rw http.ResponseWriter, r *http.Request, err error) {
+	if err != storage.ErrUserConflict {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+	status := http.StatusConflict
+	if r.Header.Get("If-Match") != "" {
+		status = http.StatusPreconditionFailed
+	}
+	respondError(rw, status, ErrorResponse{
+		Errors: []ErrorResponseError{{Message: "Changed by another request, retry"}},
+	})
+}
+
+type revertTodoInput struct {
+	Version int `json:"version"`
+}
+
+func registerHistoryRoutes(todosRouter chi.Router) {
+	todosRouter.Get("/{todoID}/history", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionView)
+		if !ok {
+			return
+		}
+
+		// History stays readable while a todo is in the trash.
+		if todo := user.Todos.FindByID(todoID); todo.ID == "" {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found"}},
+			})
+			return
+		}
+
+		entries, err := historyStore.List(context.Background(), todoID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(entries)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Post("/{todoID}/revert", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		todo := user.FindTodo(todoID)
+		if todo.ID == "" {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found"}},
+			})
+			return
+		}
+
+		var input = revertTodoInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		entries, err := historyStore.List(context.Background(), todoID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		snapshot, err := domain.SnapshotAtVersion(todo.Snapshot(), entries, input.Version)
+		if err == domain.ErrVersionNotFound {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error(), Field: "version"}},
+			})
+			return
+		}
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		todo.ApplySnapshot(snapshot, time.Now())
+		todo.UpdatedAt = time.Now()
+		err = saveUserAs(r, user, domain.HistoryReverted)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		user.Todos.ComputeProgress()
+		bytes, err := json.Marshal(todo)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+}
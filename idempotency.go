@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/storage"
+)
+
+// idempotencyKeys stores the idempotency keys requests have claimed and the
+// responses to them.
+type idempotencyKeys interface {
+	Claim(ctx context.Context, key, fingerprint string, now time.Time) (*storage.IdempotentRequest, string, error)
+	Extend(ctx context.Context, key, claim string, now time.Time) error
+	Complete(ctx context.Context, key, claim string, status int, header map[string]string, body []byte, now time.Time) error
+	Release(ctx context.Context, key, claim string) error
+}
+
+var idempotencyStore idempotencyKeys
+
+// idempotencyKeyTTL is how long the response to a key is kept for retries.
+var idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyPendingTimeout is how long a claim on a key lasts unless the
+// request holding it extends it, which it does while it runs however long
+// that takes.
+var idempotencyPendingTimeout = time.Minute
+
+// idempotencyWait is how long a request waits for an in-flight request with
+// the same idempotency key to finish before it is rejected.
+var idempotencyWait = 10 * time.Second
+
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize is the largest request body, other than an attachment
+// upload, that is read to fingerprint a request.
+var maxIdempotentBodySize int64 = 1 << 20
+
+// idempotentResponseHeaders are the response headers replayed along with the
+// stored status and body.
+var idempotentResponseHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotent makes mutating requests that carry an Idempotency-Key header safe
+// to retry. The first request with a key is handled and its response stored;
+// retries get the stored response instead of being handled again. Keys are
+// scoped to the caller, see idempotencyScope, so it must run after
+// authenticate when the route requires one.
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || idempotencyStore == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Idempotency-Key is too long"}},
+			})
+			return
+		}
+
+		// The body is read before any handler limits it, so it is limited here.
+		limit := idempotentBodyLimit(r)
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if int64(len(body)) > limit {
+			respondError(rw, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: fmt.Sprintf("request body must be at most %d bytes", limit)}},
+			})
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		key = idempotencyScope(r) + ":" + key
+		fingerprint := requestFingerprint(r, body)
+
+		deadline := time.Now().Add(idempotencyWait)
+		var claim string
+		for {
+			earlier, claimed, err := idempotencyStore.Claim(r.Context(), key, fingerprint, time.Now())
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			if claimed != "" {
+				claim = claimed
+				break
+			}
+			if earlier.Fingerprint != fingerprint {
+				respondError(rw, http.StatusUnprocessableEntity, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Idempotency-Key was already used for a different request"}},
+				})
+				return
+			}
+			if earlier.Completed {
+				for name, value := range earlier.ResponseHeader {
+					rw.Header().Set(name, value)
+				}
+				rw.Header().Set("Idempotent-Replayed", "true")
+				rw.WriteHeader(earlier.ResponseStatus)
+				rw.Write(earlier.ResponseBody)
+				return
+			}
+			if time.Now().After(deadline) {
+				respondError(rw, http.StatusConflict, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "A request with this Idempotency-Key is still in progress"}},
+				})
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		stopExtending := extendClaim(key, claim)
+		recorder := &responseRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		stopExtending()
+
+		// The outcome is recorded even if the client has gone away, since that is
+		// when it is most likely to retry. Responses that ask the client to retry
+		// aren't stored, so the retry can use the same key.
+		ctx := context.Background()
+		if retryableStatus(recorder.status) {
+			if err := idempotencyStore.Release(ctx, key, claim); err != nil {
+				log.Print(err)
+			}
+			return
+		}
+		header := make(map[string]string)
+		for _, name := range idempotentResponseHeaders {
+			if value := rw.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		err = idempotencyStore.Complete(ctx, key, claim, recorder.status, header, recorder.body.Bytes(), time.Now())
+		if err != nil {
+			log.Print(err)
+		}
+	})
+}
+
+// idempotentBodyLimit is the largest body the request's route accepts.
+func idempotentBodyLimit(r *http.Request) int64 {
+	if r.Method == http.MethodPost && strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/attachments") {
+		return maxAttachmentUploadSize()
+	}
+	return maxIdempotentBodySize
+}
+
+// idempotencyScope is the caller a key belongs to, so that callers picking the
+// same key don't see each other's responses: the signed in user or, for
+// anonymous requests, the client's address, which RealIP has already taken
+// from the proxy headers.
+func idempotencyScope(r *http.Request) string {
+	if user, ok := r.Context().Value(USER_CONTEXT_KEY).(*domain.User); ok {
+		return user.ID.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "anonymous@" + host
+}
+
+// retryableStatus reports whether a response tells the client the request
+// may succeed if made again: a server error, a conflict with a concurrent
+// change, a failed precondition the client can refresh, or a rate limit.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// extendClaim keeps extending the claim on key until the returned function is
+// called, so a request taking longer than idempotencyPendingTimeout isn't
+// handled a second time by a retry.
+func extendClaim(key, claim string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(idempotencyPendingTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := idempotencyStore.Extend(context.Background(), key, claim, time.Now()); err != nil {
+					log.Print(err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// requestFingerprint identifies a request by its method, URL and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
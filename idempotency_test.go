@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/storage"
+)
+
+type memoryIdempotencyKeys struct {
+	mu       sync.Mutex
+	requests map[string]*storage.IdempotentRequest
+	claims   map[string]string
+	claimed  int
+}
+
+func (mik *memoryIdempotencyKeys) Claim(ctx context.Context, key, fingerprint string, now time.Time) (*storage.IdempotentRequest, string, error) {
+	mik.mu.Lock()
+	defer mik.mu.Unlock()
+	if earlier, ok := mik.requests[key]; ok {
+		copied := *earlier
+		return &copied, "", nil
+	}
+	mik.claimed++
+	claim := strconv.Itoa(mik.claimed)
+	mik.requests[key] = &storage.IdempotentRequest{Fingerprint: fingerprint}
+	mik.claims[key] = claim
+	return nil, claim, nil
+}
+
+// expire lets the claim on key run out, as if its request stopped extending it.
+func (mik *memoryIdempotencyKeys) expire(key string) {
+	mik.mu.Lock()
+	defer mik.mu.Unlock()
+	delete(mik.requests, key)
+	delete(mik.claims, key)
+}
+
+// holds reports whether claim is the current, pending claim on key.
+func (mik *memoryIdempotencyKeys) holds(key, claim string) bool {
+	request, ok := mik.requests[key]
+	return ok && !request.Completed && mik.claims[key] == claim
+}
+
+func (mik *memoryIdempotencyKeys) Extend(ctx context.Context, key, claim string, now time.Time) error {
+	mik.mu.Lock()
+	defer mik.mu.Unlock()
+	if !mik.holds(key, claim) {
+		return storage.ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+func (mik *memoryIdempotencyKeys) Complete(ctx context.Context, key, claim string, status int, header map[string]string, body []byte, now time.Time) error {
+	mik.mu.Lock()
+	defer mik.mu.Unlock()
+	if !mik.holds(key, claim) {
+		return storage.ErrIdempotencyClaimLost
+	}
+	request := mik.requests[key]
+	request.Completed = true
+	request.ResponseStatus = status
+	request.ResponseHeader = header
+	request.ResponseBody = body
+	return nil
+}
+
+func (mik *memoryIdempotencyKeys) Release(ctx context.Context, key, claim string) error {
+	mik.mu.Lock()
+	defer mik.mu.Unlock()
+	if !mik.holds(key, claim) {
+		return storage.ErrIdempotencyClaimLost
+	}
+	delete(mik.requests, key)
+	delete(mik.claims, key)
+	return nil
+}
+
+func useMemoryIdempotencyKeys(t *testing.T) *memoryIdempotencyKeys {
+	keys := &memoryIdempotencyKeys{
+		requests: make(map[string]*storage.IdempotentRequest),
+		claims:   make(map[string]string),
+	}
+	idempotencyStore = keys
+	t.Cleanup(func() { idempotencyStore = nil })
+	return keys
+}
+
+func idempotentRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", "key")
+	return r
+}
+
+func Test_idempotent_replay(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	calls := 0
+	handler := idempotent(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		rw.Header().Set("Location", "/todos/1")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte(`{"id":"1"}`))
+	}))
+
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, idempotentRequest(`{"title":"a"}`))
+		if rw.Code != http.StatusCreated || rw.Body.String() != `{"id":"1"}` || rw.Header().Get("Location") != "/todos/1" {
+			t.Errorf("request %d = %d %s, expected the first response", i, rw.Code, rw.Body)
+		}
+		if replayed := rw.Header().Get("Idempotent-Replayed") == "true"; replayed != (i > 0) {
+			t.Errorf("request %d replayed = %v, expected %v", i, replayed, i > 0)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, expected 1", calls)
+	}
+}
+
+func Test_idempotent_differentBody(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	handler := idempotent(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest(`{"title":"a"}`))
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, idempotentRequest(`{"title":"b"}`))
+	if rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, expected %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func Test_idempotent_tooLarge(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	calls := 0
+	handler := idempotent(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, idempotentRequest(`{"title":"`+strings.Repeat("a", int(maxIdempotentBodySize))+`"}`))
+	if rw.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Errorf("status = %d with %d calls, expected %d without calling the handler", rw.Code, calls, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func Test_idempotent_inProgress(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	defer func(wait time.Duration) { idempotencyWait = wait }(idempotencyWait)
+	idempotencyWait = 300 * time.Millisecond
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := idempotent(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		rw.WriteHeader(http.StatusCreated)
+	}))
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(first, idempotentRequest(`{"title":"a"}`))
+	}()
+	<-started
+
+	begin := time.Now()
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, idempotentRequest(`{"title":"a"}`))
+	if rw.Code != http.StatusConflict {
+		t.Errorf("status = %d, expected %d", rw.Code, http.StatusConflict)
+	}
+	if waited := time.Since(begin); waited < idempotencyWait {
+		t.Errorf("waited %v, expected at least %v", waited, idempotencyWait)
+	}
+
+	close(release)
+	<-done
+	if first.Code != http.StatusCreated {
+		t.Errorf("first status = %d, expected %d", first.Code, http.StatusCreated)
+	}
+}
+
+func Test_idempotent_claimLost(t *testing.T) {
+	keys := useMemoryIdempotencyKeys(t)
+	var handler http.Handler
+	calls := 0
+	handler = idempotent(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls++
+		call := calls
+		if call == 1 {
+			// The first request's claim runs out and a retry claims the key
+			// and completes before the first request does.
+			keys.expire(idempotencyScope(r) + ":key")
+			handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest(`{"title":"a"}`))
+		}
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte(strconv.Itoa(call)))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest(`{"title":"a"}`))
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, idempotentRequest(`{"title":"a"}`))
+	if rw.Header().Get("Idempotent-Replayed") != "true" || rw.Body.String() != "2" {
+		t.Errorf("replayed response = %s, expected the retry's response", rw.Body)
+	}
+}
+
+func Test_idempotent_retryable(t *testing.T) {
+	for _, status := range []int{http.StatusConflict, http.StatusPreconditionFailed, http.StatusTooManyRequests, http.StatusInternalServerError} {
+		useMemoryIdempotencyKeys(t)
+		calls := 0
+		handler := idempotent(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				rw.WriteHeader(status)
+				return
+			}
+			rw.WriteHeader(http.StatusCreated)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest(`{"title":"a"}`))
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, idempotentRequest(`{"title":"a"}`))
+		if rw.Code != http.StatusCreated || rw.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("retry after %d = %d, expected the retry to be handled", status, rw.Code)
+		}
+		if calls != 2 {
+			t.Errorf("handler called %d times after %d, expected 2", calls, status)
+		}
+	}
+}
+
+func Test_idempotencyScope(t *testing.T) {
+	anonymous := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/users", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+	signedIn := requestSetUser(anonymous("192.0.2.1:1234"), &domain.User{ID: "u"})
+
+	tests := []struct {
+		a, b     *http.Request
+		expected bool
+	}{
+		{anonymous("192.0.2.1:1234"), anonymous("192.0.2.1:5678"), true},
+		{anonymous("192.0.2.1:1234"), anonymous("192.0.2.2:1234"), false},
+		{anonymous("192.0.2.1"), anonymous("192.0.2.1:1234"), true},
+		{signedIn, anonymous("192.0.2.1:1234"), false},
+	}
+
+	for _, test := range tests {
+		if same := idempotencyScope(test.a) == idempotencyScope(test.b); same != test.expected {
+			t.Errorf("idempotencyScope(%s) == idempotencyScope(%s) = %v, expected %v", idempotencyScope(test.a), idempotencyScope(test.b), same, test.expected)
+		}
+	}
+}
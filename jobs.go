@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/jobs"
+	"github.com/DillonStreator/todos/notify"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var jobStore *storage.JobStore
+
+// jobTimeout limits how long a job may run.
+var jobTimeout = 5 * time.Minute
+
+// adminToken authorizes the /admin routes. They are disabled when it is empty.
+var adminToken string
+
+// The types of job the worker runs.
+const (
+	sendNotificationJob = "notification.send"
+)
+
+type jobsResponse struct {
+	Jobs []*jobs.Job `json:"jobs"`
+	// NextCursor is passed back as ?cursor to get the next page. It is empty
+	// on the last page.
+	NextCursor entityid.ID `json:"nextCursor"`
+}
+
+// enqueueJob queues a job of the given type to run now.
+func enqueueJob(ctx context.Context, jobType string, payload interface{}) error {
+	job, err := jobs.New(jobType, payload, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = jobStore.Enqueue(ctx, job)
+	return err
+}
+
+// sendNotification queues the notification so a failing notifier is retried
+// in the background. Without a job queue it is sent right away.
+func sendNotification(n notify.Notification) error {
+	if jobStore == nil {
+		return notifier.Notify(context.Background(), n)
+	}
+	return enqueueJob(context.Background(), sendNotificationJob, n)
+}
+
+// registerJobHandlers tells the worker how to run each type of job.
+func registerJobHandlers(worker *jobs.Worker) {
+	worker.Handle(sendNotificationJob, func(ctx context.Context, job *jobs.Job) error {
+		var n notify.Notification
+		if err := job.Decode(&n); err != nil {
+			return err
+		}
+		return notifier.Notify(ctx, n)
+	})
+	worker.Handle(runTaskJob, runTask)
+}
+
+// authenticateAdmin checks the Authorization header against adminToken.
+func authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Not found"}},
+			})
+			return
+		}
+		token := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			respondError(rw, http.StatusUnauthorized, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Not authorized"}},
+			})
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func adminRouter(adminRouter chi.Router) {
+	adminRouter.Use(authenticateAdmin)
+
+	registerTaskRoutes(adminRouter)
+
+	adminRouter.Get("/jobs", func(rw http.ResponseWriter, r *http.Request) {
+		filter := storage.JobFilter{
+			Type:   r.URL.Query().Get("type"),
+			Status: jobs.Status(r.URL.Query().Get("status")),
+		}
+		if filter.Status != "" && !filter.Status.Valid() {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "status must be one of pending, running, succeeded, dead", Field: "status"}},
+			})
+			return
+		}
+
+		limit := 50
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			var err error
+			limit, err = strconv.Atoi(limitParam)
+			if err != nil || limit < 1 || limit > 200 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "limit must be between 1 and 200", Field: "limit"}},
+				})
+				return
+			}
+		}
+		var after *jobs.Job
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			var err error
+			after, err = jobStore.Find(context.Background(), entityid.ID(cursor))
+			if err == storage.ErrJobNotFound {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "invalid cursor", Field: "cursor"}},
+				})
+				return
+			}
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+		}
+
+		// Fetching one extra job tells whether there is another page.
+		list, err := jobStore.List(context.Background(), filter, after, limit+1)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		response := jobsResponse{Jobs: list}
+		if len(list) > limit {
+			response.Jobs = list[:limit]
+			response.NextCursor = list[limit-1].ID
+		}
+
+		bytes, err := json.Marshal(response)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	adminRouter.Get("/jobs/stats", func(rw http.ResponseWriter, r *http.Request) {
+		counts, err := jobStore.Counts(context.Background())
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if counts == nil {
+			counts = []storage.JobCount{}
+		}
+
+		bytes, err := json.Marshal(counts)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	adminRouter.Get("/jobs/{jobID}", func(rw http.ResponseWriter, r *http.Request) {
+		job, ok := findJob(rw, r)
+		if !ok {
+			return
+		}
+
+		bytes, err := json.Marshal(job)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	// Retrying runs a dead job, or a pending one waiting out its backoff,
+	// right away with a fresh set of attempts.
+	adminRouter.Post("/jobs/{jobID}/retry", func(rw http.ResponseWriter, r *http.Request) {
+		job, ok := findJob(rw, r)
+		if !ok {
+			return
+		}
+		if job.Status != jobs.StatusDead && job.Status != jobs.StatusPending {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Only dead and pending jobs can be retried"}},
+			})
+			return
+		}
+
+		job, err := jobStore.Retry(context.Background(), job.ID, time.Now())
+		if err == storage.ErrJobNotFound {
+			// The job started or finished in the meantime.
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Only dead and pending jobs can be retried"}},
+			})
+			return
+		}
+		if err == storage.ErrJobQueued {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "A job with the same unique key is already queued"}},
+			})
+			return
+		}
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(job)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+}
+
+// findJob looks up the job in the URL, responding with 404 if there is none.
+func findJob(rw http.ResponseWriter, r *http.Request) (*jobs.Job, bool) {
+	job, err := jobStore.Find(context.Background(), entityid.ID(chi.URLParam(r, "jobID")))
+	if err == storage.ErrJobNotFound {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Job not found"}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	return job, true
+}
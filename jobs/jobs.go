@@ -0,0 +1,288 @@
+// Package jobs runs durable background work queued in the database, retrying
+// jobs that fail until they succeed or run out of attempts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+type Status string
+
+const (
+	// StatusPending jobs run once their RunAt passes, including failed jobs
+	// waiting to be retried.
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	// StatusDead jobs ran out of attempts and only run again if retried by
+	// hand.
+	StatusDead Status = "dead"
+)
+
+func (s Status) Valid() bool {
+	return s == StatusPending || s == StatusRunning || s == StatusSucceeded || s == StatusDead
+}
+
+// DefaultMaxAttempts is how many times a job is tried unless it says otherwise.
+const DefaultMaxAttempts = 10
+
+type Job struct {
+	ID      entityid.ID     `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	// UniqueKey, when set, stops another job of the same type and key from
+	// being queued while this one is pending or running.
+	UniqueKey   string     `json:"uniqueKey,omitempty"`
+	Status      Status     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"maxAttempts"`
+	RunAt       time.Time  `json:"runAt"`
+	LastError   string     `json:"lastError,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	StartedAt   *time.Time `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt"`
+}
+
+// New returns a pending job of the given type with payload marshalled, due at
+// runAt. Set UniqueKey and MaxAttempts on it before queueing to change them.
+func New(jobType string, payload interface{}, runAt time.Time) (*Job, error) {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Job{
+		ID:          entityid.Generator.Generate(),
+		Type:        jobType,
+		Payload:     bytes,
+		Status:      StatusPending,
+		MaxAttempts: DefaultMaxAttempts,
+		RunAt:       runAt,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// Decode unmarshals the job's payload into v, typically the struct its
+// handler's type was queued with.
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler does the work of a job. Jobs run at least once, so handlers must
+// tolerate running again after a worker dies mid-job.
+type Handler func(ctx context.Context, job *Job) error
+
+// Store keeps the queue.
+type Store interface {
+	// Enqueue adds the job to the queue. It returns false, without an error,
+	// if a job with the same type and unique key is already pending or
+	// running.
+	Enqueue(ctx context.Context, job *Job) (bool, error)
+	// Claim marks up to limit due jobs of the given types running and
+	// returns them, skipping jobs other workers are claiming. Running jobs
+	// whose worker's lease ran out are claimed again.
+	Claim(ctx context.Context, now time.Time, types []string, limit int) ([]*Job, error)
+	// Record saves the job after it ran.
+	Record(ctx context.Context, job *Job) error
+}
+
+// Backoff returns how long to wait before retrying a job that has failed
+// attempts times: ten seconds, doubling with every attempt up to a day.
+func Backoff(attempts int) time.Duration {
+	const max = 24 * time.Hour
+	backoff := 10 * time.Second
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	return backoff
+}
+
+// Worker runs queued jobs with the handlers registered for their types.
+type Worker struct {
+	Store Store
+	// Concurrency is how many jobs run at once.
+	Concurrency int
+	// Interval is how often due jobs are looked for while there is room for
+	// more.
+	Interval time.Duration
+	// Timeout limits how long a job may run. It must be shorter than the
+	// store's lease, or jobs may run twice at once.
+	Timeout time.Duration
+	Now     func() time.Time
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	types    []string
+}
+
+// Handle registers the handler for jobs of the given type. Register every
+// handler before calling Run.
+func (w *Worker) Handle(jobType string, handler Handler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.handlers == nil {
+		w.handlers = make(map[string]Handler)
+	}
+	if _, ok := w.handlers[jobType]; !ok {
+		w.types = append(w.types, jobType)
+	}
+	w.handlers[jobType] = handler
+}
+
+func (w *Worker) now() time.Time {
+	if w.Now != nil {
+		return w.Now()
+	}
+	return time.Now()
+}
+
+func (w *Worker) concurrency() int {
+	if w.Concurrency < 1 {
+		return 1
+	}
+	return w.Concurrency
+}
+
+// Run claims and runs jobs, up to Concurrency at a time, until ctx is
+// cancelled. A finished job frees its slot for the next one right away; with
+// slots to spare, the queue is checked again every Interval. Cancelling ctx
+// only stops claiming: running jobs aren't cancelled, and Run waits for them
+// to finish, or reach Timeout, before returning.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	concurrency := w.concurrency()
+	done := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	running := 0
+	for {
+		if free := concurrency - running; free > 0 {
+			w.mu.RLock()
+			types := w.types
+			w.mu.RUnlock()
+
+			jobs, err := w.Store.Claim(ctx, w.now(), types, free)
+			if err != nil {
+				log.Printf("jobs: %s", err)
+			}
+			for _, job := range jobs {
+				running++
+				wg.Add(1)
+				go func(job *Job) {
+					defer wg.Done()
+					w.Process(ctx, job)
+					done <- struct{}{}
+				}(job)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			running--
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick claims and runs due jobs, Concurrency at a time, until none are left.
+func (w *Worker) Tick(ctx context.Context) error {
+	w.mu.RLock()
+	types := w.types
+	w.mu.RUnlock()
+
+	for {
+		jobs, err := w.Store.Claim(ctx, w.now(), types, w.concurrency())
+		if err != nil {
+			return err
+		}
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job *Job) {
+				defer wg.Done()
+				w.Process(ctx, job)
+			}(job)
+		}
+		wg.Wait()
+		if len(jobs) < w.concurrency() {
+			return nil
+		}
+	}
+}
+
+// Process runs a claimed job and records the outcome: succeeded, pending a
+// retry after Backoff, or dead once it has used up its attempts.
+func (w *Worker) Process(ctx context.Context, job *Job) {
+	err := w.run(ctx, job)
+	now := w.now()
+	if err == nil {
+		job.Status = StatusSucceeded
+		job.LastError = ""
+		job.FinishedAt = &now
+	} else {
+		job.LastError = err.Error()
+		if job.Attempts >= job.MaxAttempts {
+			job.Status = StatusDead
+			job.FinishedAt = &now
+			log.Printf("jobs: %s job %s is dead after %d attempts: %s", job.Type, job.ID, job.Attempts, err)
+		} else {
+			job.Status = StatusPending
+			job.RunAt = now.Add(Backoff(job.Attempts))
+		}
+	}
+
+	// The job's outcome is recorded even if the worker is shutting down.
+	if err := w.Store.Record(context.Background(), job); err != nil {
+		log.Printf("jobs: recording %s job %s: %s", job.Type, job.ID, err)
+	}
+}
+
+// detachedContext keeps the values of its parent but not its cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// run calls the job's handler with Timeout, turning a panic into an error so
+// one bad job can't stop the worker.
+func (w *Worker) run(ctx context.Context, job *Job) (err error) {
+	w.mu.RLock()
+	handler, ok := w.handlers[job.Type]
+	w.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for %s jobs", job.Type)
+	}
+
+	// The job runs to completion, or until Timeout, even once the worker is
+	// told to stop.
+	ctx = detachedContext{ctx}
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, job)
+}
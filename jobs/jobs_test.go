@@ -0,0 +1,212 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{14, 81920 * time.Second},
+		{15, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := Backoff(test.attempts); got != test.expected {
+			t.Errorf("Backoff(%d) = %v, expected %v", test.attempts, got, test.expected)
+		}
+	}
+}
+
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func (ms *memoryStore) Enqueue(ctx context.Context, job *Job) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, queued := range ms.jobs {
+		if job.UniqueKey != "" && queued.Type == job.Type && queued.UniqueKey == job.UniqueKey &&
+			(queued.Status == StatusPending || queued.Status == StatusRunning) {
+			return false, nil
+		}
+	}
+	ms.jobs = append(ms.jobs, job)
+	return true, nil
+}
+
+func (ms *memoryStore) Claim(ctx context.Context, now time.Time, types []string, limit int) ([]*Job, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var claimed []*Job
+	for _, job := range ms.jobs {
+		if len(claimed) == limit {
+			break
+		}
+		if job.Status != StatusPending || job.RunAt.After(now) {
+			continue
+		}
+		for _, jobType := range types {
+			if job.Type == jobType {
+				job.Status = StatusRunning
+				job.Attempts++
+				job.StartedAt = &now
+				claimed = append(claimed, job)
+				break
+			}
+		}
+	}
+	return claimed, nil
+}
+
+func (ms *memoryStore) Record(ctx context.Context, job *Job) error {
+	return nil
+}
+
+type emailPayload struct {
+	To string `json:"to"`
+}
+
+func TestWorker_Tick(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	worker := &Worker{
+		Store:       store,
+		Concurrency: 2,
+		Now:         func() time.Time { return now },
+	}
+
+	var mu sync.Mutex
+	var sent []string
+	fail := errors.New("unavailable")
+	var sendErr error
+	worker.Handle("email", func(ctx context.Context, job *Job) error {
+		var payload emailPayload
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if sendErr != nil {
+			return sendErr
+		}
+		sent = append(sent, payload.To)
+		return nil
+	})
+
+	enqueue := func(to string, runAt time.Time, uniqueKey string) *Job {
+		job, err := New("email", emailPayload{To: to}, runAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.UniqueKey = uniqueKey
+		job.MaxAttempts = 2
+		if _, err := store.Enqueue(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	t.Run("runs due jobs", func(t *testing.T) {
+		a := enqueue("a@example.com", now, "")
+		b := enqueue("b@example.com", now, "")
+		c := enqueue("c@example.com", now, "")
+		later := enqueue("later@example.com", now.Add(time.Hour), "")
+
+		if err := worker.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(sent) != 3 {
+			t.Errorf("sent %v, expected a, b and c", sent)
+		}
+		for _, job := range []*Job{a, b, c} {
+			if job.Status != StatusSucceeded || job.FinishedAt == nil {
+				t.Errorf("Status, FinishedAt = %v, %v, expected %v, set", job.Status, job.FinishedAt, StatusSucceeded)
+			}
+		}
+		if later.Status != StatusPending || later.Attempts != 0 {
+			t.Errorf("delayed job Status, Attempts = %v, %d, expected %v, 0", later.Status, later.Attempts, StatusPending)
+		}
+	})
+	t.Run("skips jobs with a queued unique key", func(t *testing.T) {
+		enqueue("digest@example.com", now.Add(time.Hour), "digest:user")
+		job, _ := New("email", emailPayload{To: "digest@example.com"}, now)
+		job.UniqueKey = "digest:user"
+		if ok, err := store.Enqueue(context.Background(), job); ok || err != nil {
+			t.Errorf("Enqueue() = %v, %v, expected false, nil", ok, err)
+		}
+	})
+	t.Run("retries failed jobs until they are dead", func(t *testing.T) {
+		sendErr = fail
+		job := enqueue("failing@example.com", now, "")
+
+		if err := worker.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != StatusPending || job.LastError != fail.Error() {
+			t.Fatalf("Status, LastError = %v, %q, expected %v, %q", job.Status, job.LastError, StatusPending, fail)
+		}
+		if expected := now.Add(10 * time.Second); !job.RunAt.Equal(expected) {
+			t.Errorf("RunAt = %v, expected %v", job.RunAt, expected)
+		}
+
+		now = now.Add(time.Minute)
+		if err := worker.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != StatusDead || job.Attempts != 2 || job.FinishedAt == nil {
+			t.Errorf("Status, Attempts, FinishedAt = %v, %d, %v, expected %v, 2, set", job.Status, job.Attempts, job.FinishedAt, StatusDead)
+		}
+	})
+}
+
+func TestWorker_Process_panic(t *testing.T) {
+	worker := &Worker{Store: &memoryStore{}}
+	worker.Handle("panics", func(ctx context.Context, job *Job) error {
+		panic("boom")
+	})
+
+	job := &Job{Type: "panics", Attempts: 1, MaxAttempts: 3}
+	worker.Process(context.Background(), job)
+	if job.Status != StatusPending || job.LastError == "" {
+		t.Errorf("Status, LastError = %v, %q, expected %v, set", job.Status, job.LastError, StatusPending)
+	}
+}
+
+func TestWorker_Process_shutdown(t *testing.T) {
+	worker := &Worker{Store: &memoryStore{}, Timeout: time.Second}
+	worker.Handle("slow", func(ctx context.Context, job *Job) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job := &Job{Type: "slow", Attempts: 1, MaxAttempts: 3}
+	worker.Process(ctx, job)
+	if job.Status != StatusSucceeded {
+		t.Errorf("Status = %v after the worker was stopped, expected %v", job.Status, StatusSucceeded)
+	}
+
+	worker.Timeout = time.Millisecond
+	job = &Job{Type: "slow", Attempts: 1, MaxAttempts: 3}
+	worker.Process(context.Background(), job)
+	if job.Status != StatusPending || job.LastError != context.DeadlineExceeded.Error() {
+		t.Errorf("Status, LastError = %v, %q, expected %v, %q", job.Status, job.LastError, StatusPending, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_authenticateAdmin(t *testing.T) {
+	handler := authenticateAdmin(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer func(token string) { adminToken = token }(adminToken)
+
+	tests := []struct {
+		adminToken string
+		header     string
+		expected   int
+	}{
+		{"", "", http.StatusNotFound},
+		{"", "anything", http.StatusNotFound},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		adminToken = test.adminToken
+		r := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+		r.Header.Set("Authorization", test.header)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		if rw.Code != test.expected {
+			t.Errorf("authenticateAdmin(%q, %q) = %d, expected %d", test.adminToken, test.header, rw.Code, test.expected)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decoding %s: %v", s, err)
+	}
+	return v
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{"replaces a member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"adds a member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes a member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"arrays are replaced", `{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{"merges nested objects", `{"a":{"b":"c","d":"e"}}`, `{"a":{"b":null,"f":"g"}}`, `{"a":{"d":"e","f":"g"}}`},
+		{"non-object patch replaces", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"object patch on non-object", `["a"]`, `{"a":"b"}`, `{"a":"b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+			actual := MergePatch(doc, decode(t, tt.patch))
+			expected := decode(t, tt.expected)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("MergePatch() = %v, expected %v", actual, expected)
+			}
+			if !reflect.DeepEqual(doc, decode(t, tt.doc)) {
+				t.Errorf("MergePatch() modified doc to %v", doc)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+		err      error
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"add to end of array", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"baz"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"add null value", `{}`, `[{"op":"add","path":"/foo","value":null}]`, `{"foo":null}`, nil},
+		{"remove member", `{"foo":"bar","baz":"qux"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"replace member", `{"foo":"bar"}`, `[{"op":"replace","path":"/foo","value":"baz"}]`, `{"foo":"baz"}`, nil},
+		{"move member", `{"foo":{"bar":"baz"},"qux":{}}`, `[{"op":"move","from":"/foo/bar","path":"/qux/thud"}]`, `{"foo":{},"qux":{"thud":"baz"}}`, nil},
+		{"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"copy member", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`, nil},
+		{"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, `{"a/b":3}`, nil},
+		{"test passes", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"test fails", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``, ErrTestFailed},
+		{"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, ``, ErrPathNotFound},
+		{"replace missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, ``, ErrPathNotFound},
+		{"add past end of array", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"baz"}]`, ``, ErrPathNotFound},
+		{"leading zero index", `{"foo":["bar","baz"]}`, `[{"op":"remove","path":"/foo/01"}]`, ``, ErrInvalidIndex},
+		{"missing value", `{}`, `[{"op":"add","path":"/foo"}]`, ``, ErrInvalidOperation},
+		{"unknown op", `{}`, `[{"op":"frobnicate","path":"/foo"}]`, ``, ErrInvalidOperation},
+		{"move into itself", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, ``, ErrInvalidOperation},
+		{"invalid pointer", `{}`, `[{"op":"add","path":"foo","value":1}]`, ``, ErrInvalidPointer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var operations []Operation
+			if err := json.Unmarshal([]byte(tt.patch), &operations); err != nil {
+				t.Fatalf("decoding patch: %v", err)
+			}
+			doc := decode(t, tt.doc)
+			actual, err := Apply(doc, operations)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Apply() error = %v, expected %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if !reflect.DeepEqual(doc, decode(t, tt.doc)) {
+					t.Errorf("Apply() modified doc to %v", doc)
+				}
+				return
+			}
+			expected := decode(t, tt.expected)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Apply() = %v, expected %v", actual, expected)
+			}
+		})
+	}
+}
@@ -12,10 +12,14 @@ import (
 type Input struct {
 	UserID entityid.ID
 	Email  string
+	// OrgID selects the organization the token works in. Empty means the
+	// user's personal organization.
+	OrgID entityid.ID
 }
 type claim struct {
 	UserID entityid.ID `json:"userId"`
 	Email  string      `json:"email"`
+	OrgID  entityid.ID `json:"orgId,omitempty"`
 	jwtgo.StandardClaims
 }
 
@@ -27,6 +31,7 @@ func SignJWT(input Input) (string, error) {
 	c := claim{
 		UserID: input.UserID,
 		Email:  input.Email,
+		OrgID:  input.OrgID,
 		StandardClaims: jwtgo.StandardClaims{
 			ExpiresAt: time.Now().Add(15 * time.Minute).Unix(),
 		},
@@ -1,19 +1,52 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
+	"github.com/DillonStreator/todos/blobs"
+	"github.com/DillonStreator/todos/cron"
+	"github.com/DillonStreator/todos/events"
+	"github.com/DillonStreator/todos/jobs"
+	"github.com/DillonStreator/todos/notify"
+	"github.com/DillonStreator/todos/ordering"
+	"github.com/DillonStreator/todos/outbox"
+	"github.com/DillonStreator/todos/reminders"
 	"github.com/DillonStreator/todos/storage"
+	"github.com/DillonStreator/todos/webhooks"
 	"github.com/eleanorhealth/milo"
 	"github.com/go-pg/pg/v10"
 	"github.com/joho/godotenv"
 )
 
 var store *milo.Store
+var db *pg.DB
 
+// allOrgsDB isn't limited to one organization by row level security; see
+// storage.AllOrgs.
+var allOrgsDB *pg.DB
+var notifier notify.Notifier = &notify.LogNotifier{}
+
+// main runs the API server along with the background work. Run it with
+// "server" to only serve the API, or "worker" to only do the background
+// work, so each can be scaled on its own.
 func main() {
+	mode := "all"
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	if mode != "all" && mode != "server" && mode != "worker" {
+		log.Fatalf("unknown run mode %s, expected server or worker", mode)
+	}
+
 	_, jwtSecretEnvSet := os.LookupEnv("JWT_SECRET")
 	if !jwtSecretEnvSet {
 		log.Fatal("JWT_SECRET env must be set")
@@ -37,21 +70,109 @@ func main() {
 		network = "tcp"
 	}
 
-	db := pg.Connect(&pg.Options{
+	options := &pg.Options{
 		Network:  network,
 		Addr:     addr,
-		User:     getEnv("DB_USER", "postgres"),
+		User:     getEnv("DB_USER", "todos"),
 		Password: getEnv("DB_PASS", "password"),
 		Database: getEnv("DB_NAME", "todos"),
-	})
+	}
+	db = pg.Connect(options)
 	defer db.Close()
+	allOrgsDB = pg.Connect(storage.AllOrgs(options))
+	defer allOrgsDB.Close()
 
-	err = storage.CreateSchema(db)
+	err = storage.CreateSchema(allOrgsDB)
 	if err != nil {
 		log.Fatal(err)
 	}
+	bypasses, err := storage.BypassesRowLevelSecurity(context.Background(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bypasses {
+		log.Printf("database user %s is a superuser or has BYPASSRLS, so row level security won't keep organizations apart", options.User)
+	}
 
-	store = milo.NewStore(db, storage.MiloEntityModelMap)
+	// The store loads and saves users with their data in every organization,
+	// for work done outside of requests. Requests use storage.FindUserInOrg.
+	store = milo.NewStore(allOrgsDB, storage.MiloEntityModelMap)
+
+	historyStore = &storage.HistoryStore{DB: db}
+	syncStore = &storage.SyncStore{DB: db}
+	shareStore = &storage.ShareStore{DB: allOrgsDB}
+	orgStore = &storage.OrgStore{DB: db}
+	commentStore = &storage.CommentStore{DB: db}
+	attachmentStore = &storage.AttachmentStore{DB: db}
+	webhookTimeout = getEnvDuration("WEBHOOK_TIMEOUT", webhookTimeout)
+	// Deliveries claimed together are sent one after another, so the lease
+	// outlasts sending the whole batch and none is sent twice at once.
+	webhookStore = &storage.WebhookStore{DB: db, Lease: time.Duration(webhookBatchSize)*webhookTimeout + time.Minute}
+	jobTimeout = getEnvDuration("JOB_TIMEOUT", jobTimeout)
+	// The lease outlasts the timeout so a job never runs twice at once.
+	jobStore = &storage.JobStore{DB: db, Lease: jobTimeout + time.Minute}
+	switch getEnv("BLOB_STORE", "local") {
+	case "local":
+		blobStore = &blobs.LocalStore{Dir: getEnv("BLOB_DIR", "data/blobs")}
+	case "s3":
+		blobStore = &blobs.S3Store{
+			Endpoint:        getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:          getEnv("S3_REGION", "us-east-1"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+	default:
+		log.Fatal("BLOB_STORE must be local or s3")
+	}
+	localBroker := events.NewMemoryBroker(getEnvInt("EVENTS_REPLAY_BUFFER", 256), getEnvDuration("EVENTS_REPLAY_AGE", time.Hour))
+	eventBroker = localBroker
+	if getEnv("EVENTS_BROKER", "memory") == "postgres" {
+		postgresBroker := &storage.PostgresBroker{DB: db, Local: localBroker}
+		go postgresBroker.Run(context.Background())
+		eventBroker = postgresBroker
+	}
+	idempotencyKeyTTL = getEnvDuration("IDEMPOTENCY_KEY_TTL", idempotencyKeyTTL)
+	idempotencyPendingTimeout = getEnvDuration("IDEMPOTENCY_PENDING_TIMEOUT", idempotencyPendingTimeout)
+	if idempotencyPendingTimeout <= 0 {
+		log.Fatal("IDEMPOTENCY_PENDING_TIMEOUT must be positive")
+	}
+	idempotencyStore = &storage.IdempotencyStore{
+		DB:             db,
+		TTL:            idempotencyKeyTTL,
+		PendingTimeout: idempotencyPendingTimeout,
+	}
+	positionRebalancer = ordering.NewRebalancer(ordering.BalancerFunc(rebalancePositions))
+	go positionRebalancer.Run(context.Background())
+
+	syncTokenLifetime = getEnvDuration("SYNC_TOKEN_LIFETIME", syncTokenLifetime)
+	adminToken = os.Getenv("ADMIN_TOKEN")
+
+	cronTimezone, err := time.LoadLocation(getEnv("CRON_TIMEZONE", "UTC"))
+	if err != nil {
+		log.Fatalf("invalid CRON_TIMEZONE: %s", err)
+	}
+	cronStore = &storage.CronStore{DB: db}
+	cronScheduler = &cron.Scheduler{Store: cronStore, Location: cronTimezone}
+	registerCronTasks(cronScheduler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var workerDone <-chan struct{}
+	if mode != "server" {
+		workerDone = startBackgroundWork(ctx)
+	}
+	if mode == "worker" {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		// Claiming stops, and running jobs get to finish, or reach
+		// JOB_TIMEOUT, before the worker exits.
+		log.Print("worker shutting down")
+		cancel()
+		<-workerDone
+		return
+	}
 
 	err = startServer()
 	if err != nil {
@@ -59,9 +180,90 @@ func main() {
 	}
 }
 
+// startBackgroundWork runs the dispatchers, the job worker and the scheduled
+// tasks until ctx is cancelled. The returned channel is closed once running
+// jobs and tasks have finished.
+func startBackgroundWork(ctx context.Context) <-chan struct{} {
+	reminderScheduler := &reminders.Scheduler{
+		Source:   reminderSource{&storage.ReminderSource{DB: allOrgsDB}},
+		Notifier: notifier,
+		Locker:   cronStore,
+		Interval: getEnvDuration("REMINDER_SCHEDULER_INTERVAL", time.Minute),
+	}
+	go reminderScheduler.Run(ctx)
+
+	webhookDispatcher := &webhooks.Dispatcher{
+		Source:       webhookStore,
+		Client:       webhooks.NewClient(webhookTimeout),
+		Interval:     getEnvDuration("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second),
+		BatchSize:    webhookBatchSize,
+		MaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		DisableAfter: getEnvInt("WEBHOOK_DISABLE_AFTER", 50),
+	}
+	go webhookDispatcher.Run(ctx)
+
+	outboxRelay = &outbox.Relay{
+		Source:      &storage.OutboxStore{DB: db, Lease: time.Minute},
+		Interval:    getEnvDuration("OUTBOX_RELAY_INTERVAL", 5*time.Second),
+		BatchSize:   100,
+		MaxAttempts: getEnvInt("OUTBOX_MAX_ATTEMPTS", 20),
+	}
+	subscribeWebhooks(outboxRelay)
+	go outboxRelay.Run(ctx)
+
+	jobWorker := &jobs.Worker{
+		Store:       jobStore,
+		Concurrency: getEnvInt("JOB_WORKER_CONCURRENCY", 4),
+		Interval:    getEnvDuration("JOB_POLL_INTERVAL", time.Second),
+		Timeout:     jobTimeout,
+	}
+	registerJobHandlers(jobWorker)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		jobWorker.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		cronScheduler.Run(ctx)
+	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid duration %s for key %s", value, key)
+	}
+	return duration
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid integer %s for key %s", value, key)
+	}
+	return i
+}
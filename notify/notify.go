@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+type Notification struct {
+	UserID  entityid.ID `json:"userId"`
+	Email   string      `json:"email"`
+	Subject string      `json:"subject"`
+	Body    string      `json:"body"`
+}
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier writes notifications to a logger instead of delivering them.
+type LogNotifier struct {
+	Logger *log.Logger
+}
+
+func (ln *LogNotifier) Notify(ctx context.Context, n Notification) error {
+	printf := log.Printf
+	if ln.Logger != nil {
+		printf = ln.Logger.Printf
+	}
+	printf("notify %s <%s>: %s: %s", n.UserID, n.Email, n.Subject, n.Body)
+	return nil
+}
+
+// MemoryNotifier records notifications so tests can assert on them.
+type MemoryNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (mn *MemoryNotifier) Notify(ctx context.Context, n Notification) error {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	mn.sent = append(mn.sent, n)
+	return nil
+}
+
+// Sent returns a copy of every notification recorded so far.
+func (mn *MemoryNotifier) Sent() []Notification {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	sent := make([]Notification, len(mn.sent))
+	copy(sent, mn.sent)
+	return sent
+}
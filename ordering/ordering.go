@@ -0,0 +1,147 @@
+// Package ordering generates lexicographically sortable position keys so an
+// item can be moved between two neighbours by writing only its own key.
+package ordering
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// digits are in ASCII order so keys compare correctly as plain strings.
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// MaxKeyLength is the key length past which a rebalance should be scheduled.
+const MaxKeyLength = 24
+
+var ErrInvalidRange = errors.New("ordering: lower key must sort before upper key")
+
+// Between returns a key that sorts strictly between lower and upper. An empty
+// lower means the start of the list and an empty upper means the end.
+func Between(lower, upper string) (string, error) {
+	if upper != "" && lower >= upper {
+		return "", ErrInvalidRange
+	}
+	return midpoint(lower, upper), nil
+}
+
+// midpoint follows the approach of David Greenspan's fractional indexing:
+// keys never end in the smallest digit, so there is always room below them.
+func midpoint(lower, upper string) string {
+	if upper != "" {
+		n := 0
+		for n < len(upper) && digitAt(lower, n) == upper[n] {
+			n++
+		}
+		if n > 0 {
+			return upper[:n] + midpoint(suffix(lower, n), upper[n:])
+		}
+	}
+
+	lowerDigit := 0
+	if lower != "" {
+		lowerDigit = strings.IndexByte(digits, lower[0])
+	}
+	upperDigit := len(digits)
+	if upper != "" {
+		upperDigit = strings.IndexByte(digits, upper[0])
+	}
+
+	if upperDigit-lowerDigit > 1 {
+		return string(digits[(lowerDigit+upperDigit+1)/2])
+	}
+	if len(upper) > 1 {
+		return upper[:1]
+	}
+	return string(digits[lowerDigit]) + midpoint(suffix(lower, 1), "")
+}
+
+func digitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return digits[0]
+}
+
+func suffix(s string, i int) string {
+	if i < len(s) {
+		return s[i:]
+	}
+	return ""
+}
+
+// Spread returns n evenly spaced keys of equal length, used when assigning
+// positions from scratch.
+func Spread(n int) []string {
+	width := 1
+	for capacity := len(digits) - 1; capacity < n; capacity *= len(digits) {
+		width++
+	}
+	total := 1
+	for i := 0; i < width; i++ {
+		total *= len(digits)
+	}
+	step := total / (n + 1)
+
+	keys := make([]string, n)
+	for i := range keys {
+		value := step * (i + 1)
+		key := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			key[j] = digits[value%len(digits)]
+			value /= len(digits)
+		}
+		keys[i] = strings.TrimRight(string(key), digits[:1])
+	}
+	return keys
+}
+
+// Balancer spreads out the position keys of a user's items, keeping their
+// order.
+type Balancer interface {
+	Rebalance(ctx context.Context, userID entityid.ID) error
+}
+
+// BalancerFunc adapts a function to a Balancer.
+type BalancerFunc func(ctx context.Context, userID entityid.ID) error
+
+func (f BalancerFunc) Rebalance(ctx context.Context, userID entityid.ID) error {
+	return f(ctx, userID)
+}
+
+// Rebalancer reassigns evenly spaced keys in the background once repeated
+// moves into the same gap have made keys too long.
+type Rebalancer struct {
+	Balancer Balancer
+	requests chan entityid.ID
+}
+
+func NewRebalancer(balancer Balancer) *Rebalancer {
+	return &Rebalancer{Balancer: balancer, requests: make(chan entityid.ID, 100)}
+}
+
+// Request schedules a rebalance of the user's positions. It never blocks; if
+// the queue is full the request is dropped and retried on the next long key.
+func (rb *Rebalancer) Request(userID entityid.ID) {
+	select {
+	case rb.requests <- userID:
+	default:
+	}
+}
+
+// Run processes rebalance requests until ctx is cancelled.
+func (rb *Rebalancer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case userID := <-rb.requests:
+			if err := rb.Balancer.Rebalance(ctx, userID); err != nil {
+				log.Printf("ordering: rebalancing %s: %s", userID, err)
+			}
+		}
+	}
+}
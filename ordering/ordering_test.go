@@ -0,0 +1,62 @@
+package ordering
+
+import "testing"
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		lower, upper string
+	}{
+		{"", ""},
+		{"", "V"},
+		{"V", ""},
+		{"V", "W"},
+		{"V", "V1"},
+		{"Vz", "W"},
+		{"z", ""},
+		{"", "01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lower+"|"+tt.upper, func(t *testing.T) {
+			key, err := Between(tt.lower, tt.upper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key <= tt.lower || (tt.upper != "" && key >= tt.upper) {
+				t.Errorf("Between(%q, %q) = %q, not between", tt.lower, tt.upper, key)
+			}
+		})
+	}
+
+	t.Run("rejects an inverted range", func(t *testing.T) {
+		if _, err := Between("b", "a"); err != ErrInvalidRange {
+			t.Errorf("Between() error = %v, expected %v", err, ErrInvalidRange)
+		}
+	})
+	t.Run("keeps finding room in the same gap", func(t *testing.T) {
+		lower, upper := "a", "b"
+		for i := 0; i < 100; i++ {
+			key, err := Between(lower, upper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key <= lower || key >= upper {
+				t.Fatalf("Between(%q, %q) = %q, not between", lower, upper, key)
+			}
+			upper = key
+		}
+	})
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{1, 10, 61, 62, 500} {
+		keys := Spread(n)
+		for i := 1; i < len(keys); i++ {
+			if keys[i-1] >= keys[i] {
+				t.Fatalf("Spread(%d) keys %q and %q are out of order", n, keys[i-1], keys[i])
+			}
+		}
+		if keys[0] == "" {
+			t.Errorf("Spread(%d) produced an empty key", n)
+		}
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/jwt"
+	"github.com/DillonStreator/todos/notify"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var orgStore *storage.OrgStore
+
+type orgMembershipContextKey string
+
+var ORG_MEMBERSHIP_CONTEXT_KEY = orgMembershipContextKey("orgMembership")
+
+// orgMembership is the organization a request is for and the signed in
+// user's membership of it.
+type orgMembership struct {
+	Org    *domain.Organization
+	Member *domain.OrgMember
+}
+
+func requestGetOrgMembership(r *http.Request) *orgMembership {
+	return r.Context().Value(ORG_MEMBERSHIP_CONTEXT_KEY).(*orgMembership)
+}
+
+type orgInput struct {
+	Name *string `json:"name"`
+}
+
+type orgMemberInput struct {
+	Email *string         `json:"email"`
+	Role  *domain.OrgRole `json:"role"`
+}
+
+type orgResponse struct {
+	*domain.Organization
+	Role domain.OrgRole `json:"role"`
+}
+
+func validateOrg(org *domain.Organization) []ErrorResponseError {
+	var errors []ErrorResponseError
+	if org.Name == "" || len(org.Name) > 100 {
+		errors = append(errors, ErrorResponseError{Message: "name must be between 1 and 100 characters", Field: "name"})
+	}
+	return errors
+}
+
+// requireOrgManager responds 403 unless the signed in user may manage the
+// organization's members.
+func requireOrgManager(rw http.ResponseWriter, r *http.Request) (*orgMembership, bool) {
+	membership := requestGetOrgMembership(r)
+	if !membership.Member.Role.CanManage() {
+		respondError(rw, http.StatusForbidden, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: fmt.Sprintf("A %s of this organization can't do that", membership.Member.Role)}},
+		})
+		return nil, false
+	}
+	return membership, true
+}
+
+func orgsRouter(orgsRouter chi.Router) {
+	orgsRouter.Use(authenticate)
+	orgsRouter.Use(idempotent)
+
+	orgsRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		members, err := orgStore.ListAccepted(context.Background(), user.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		roles := make(map[entityid.ID]domain.OrgRole)
+		ids := make([]entityid.ID, 0, len(members))
+		for _, member := range members {
+			roles[member.OrgID] = member.Role
+			ids = append(ids, member.OrgID)
+		}
+		orgs, err := orgStore.List(context.Background(), ids)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		var response = make([]orgResponse, 0, len(orgs))
+		for _, org := range orgs {
+			response = append(response, orgResponse{Organization: org, Role: roles[org.ID]})
+		}
+		bytes, err := json.Marshal(response)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	orgsRouter.Post("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var input = orgInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		now := time.Now()
+		org := &domain.Organization{
+			ID:        entityid.Generator.Generate(),
+			CreatedAt: now,
+		}
+		if input.Name != nil {
+			org.Name = strings.TrimSpace(*input.Name)
+		}
+		if errors := validateOrg(org); len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+		owner := &domain.OrgMember{
+			ID:          entityid.Generator.Generate(),
+			OrgID:       org.ID,
+			UserID:      user.ID,
+			Email:       user.Email,
+			Role:        domain.OrgRoleOwner,
+			Status:      domain.InvitationAccepted,
+			InvitedBy:   user.ID,
+			CreatedAt:   now,
+			RespondedAt: &now,
+		}
+		err = orgStore.Create(context.Background(), org, owner)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(orgResponse{Organization: org, Role: owner.Role})
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+
+	orgsRouter.Get("/invitations", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		invitations, err := orgStore.ListPending(context.Background(), user.Email)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if invitations == nil {
+			invitations = make([]*domain.OrgMember, 0)
+		}
+
+		bytes, err := json.Marshal(invitations)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	orgsRouter.Post("/invitations/{invitationID}/accept", func(rw http.ResponseWriter, r *http.Request) {
+		respondToOrgInvitation(rw, r, domain.InvitationAccepted)
+	})
+	orgsRouter.Post("/invitations/{invitationID}/decline", func(rw http.ResponseWriter, r *http.Request) {
+		respondToOrgInvitation(rw, r, domain.InvitationDeclined)
+	})
+
+	orgsRouter.Route("/{orgID}", func(orgRouter chi.Router) {
+		orgRouter.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				user := requestGetUser(r)
+				orgID := entityid.ID(chi.URLParam(r, "orgID"))
+
+				member, err := orgStore.Membership(context.Background(), orgID, user.ID)
+				var org *domain.Organization
+				if err == nil {
+					org, err = orgStore.Find(context.Background(), orgID)
+				}
+				if err == storage.ErrOrgMemberNotFound || err == storage.ErrOrgNotFound {
+					respondError(rw, http.StatusNotFound, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "Organization not found"}},
+					})
+					return
+				}
+				if err != nil {
+					respondError(rw, http.StatusInternalServerError, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: err.Error()}},
+					})
+					return
+				}
+
+				membership := &orgMembership{Org: org, Member: member}
+				ctx := context.WithValue(r.Context(), ORG_MEMBERSHIP_CONTEXT_KEY, membership)
+				next.ServeHTTP(rw, r.WithContext(ctx))
+			})
+		})
+
+		orgRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			membership := requestGetOrgMembership(r)
+
+			bytes, err := json.Marshal(orgResponse{Organization: membership.Org, Role: membership.Member.Role})
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		orgRouter.Put("/", func(rw http.ResponseWriter, r *http.Request) {
+			membership, ok := requireOrgManager(rw, r)
+			if !ok {
+				return
+			}
+
+			var input = orgInput{}
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&input)
+			if err != nil {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "invalid input"}},
+				})
+				return
+			}
+
+			updatedOrg := *membership.Org
+			if input.Name != nil {
+				updatedOrg.Name = strings.TrimSpace(*input.Name)
+			}
+			if errors := validateOrg(&updatedOrg); len(errors) > 0 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+				return
+			}
+
+			err = orgStore.Update(context.Background(), &updatedOrg)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			bytes, err := json.Marshal(orgResponse{Organization: &updatedOrg, Role: membership.Member.Role})
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		// Switching organizations issues a token scoped to the other one.
+		orgRouter.Post("/token", func(rw http.ResponseWriter, r *http.Request) {
+			user := requestGetUser(r)
+			membership := requestGetOrgMembership(r)
+
+			token, err := jwt.SignJWT(jwt.Input{
+				UserID: user.ID,
+				Email:  user.Email,
+				OrgID:  membership.Org.ID,
+			})
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			bytes, err := json.Marshal(struct {
+				Token string `json:"token"`
+			}{Token: token})
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		registerOrgMemberRoutes(orgRouter)
+	})
+}
+
+func registerOrgMemberRoutes(orgRouter chi.Router) {
+	orgRouter.Get("/members", func(rw http.ResponseWriter, r *http.Request) {
+		membership := requestGetOrgMembership(r)
+
+		members, err := orgStore.ListMembers(context.Background(), membership.Org.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if members == nil {
+			members = make([]*domain.OrgMember, 0)
+		}
+
+		bytes, err := json.Marshal(members)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	orgRouter.Post("/members", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+		membership, ok := requireOrgManager(rw, r)
+		if !ok {
+			return
+		}
+		org := membership.Org
+		if org.Personal {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "A personal organization cannot have other members"}},
+			})
+			return
+		}
+
+		var input = orgMemberInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		var errors []ErrorResponseError
+		var email string
+		if input.Email != nil {
+			email = strings.TrimSpace(*input.Email)
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			errors = append(errors, ErrorResponseError{Message: "must be a valid email address", Field: "email"})
+		}
+		if input.Role == nil || !input.Role.Valid() {
+			errors = append(errors, ErrorResponseError{Message: "role must be one of member, admin, owner", Field: "role"})
+		} else if *input.Role == domain.OrgRoleOwner && membership.Member.Role != domain.OrgRoleOwner {
+			errors = append(errors, ErrorResponseError{Message: "only owners can invite owners", Field: "role"})
+		}
+		if len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		member, err := orgStore.FindMemberByEmail(context.Background(), org.ID, email)
+		if err != nil && err != storage.ErrOrgMemberNotFound {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if member != nil && member.Status != domain.InvitationDeclined {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "already invited", Field: "email"}},
+			})
+			return
+		}
+
+		// Inviting someone who declined asks them again.
+		isNew := member == nil
+		if isNew {
+			member = &domain.OrgMember{
+				ID:    entityid.Generator.Generate(),
+				OrgID: org.ID,
+				Email: email,
+			}
+		}
+		member.UserID = ""
+		member.Role = *input.Role
+		member.Status = domain.InvitationPending
+		member.InvitedBy = user.ID
+		member.CreatedAt = time.Now()
+		member.RespondedAt = nil
+		if isNew {
+			err = orgStore.CreateMember(context.Background(), member)
+		} else {
+			err = orgStore.UpdateMember(context.Background(), member)
+		}
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		err = sendNotification(notify.Notification{
+			Email:   email,
+			Subject: "You've been invited to join " + org.Name,
+			Body:    fmt.Sprintf("%s invited you to join %s as a %s.", user.Email, org.Name, member.Role),
+		})
+		if err != nil {
+			log.Print(err)
+		}
+
+		bytes, err := json.Marshal(member)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+	orgRouter.Put("/members/{memberID}", func(rw http.ResponseWriter, r *http.Request) {
+		membership, ok := requireOrgManager(rw, r)
+		if !ok {
+			return
+		}
+		member, ok := findOrgMember(rw, r, membership.Org)
+		if !ok {
+			return
+		}
+
+		var input = orgMemberInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil || input.Email != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+		if input.Role == nil || !input.Role.Valid() {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "role must be one of member, admin, owner", Field: "role"}},
+			})
+			return
+		}
+		// Only owners make or unmake owners.
+		if (*input.Role == domain.OrgRoleOwner || member.Role == domain.OrgRoleOwner) && membership.Member.Role != domain.OrgRoleOwner {
+			respondError(rw, http.StatusForbidden, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Only owners can change who owns the organization"}},
+			})
+			return
+		}
+		if member.Role == domain.OrgRoleOwner && *input.Role != domain.OrgRoleOwner && !hasOtherOwner(rw, membership.Org) {
+			return
+		}
+
+		member.Role = *input.Role
+		err = orgStore.UpdateMember(context.Background(), member)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(member)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	orgRouter.Delete("/members/{memberID}", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+		membership := requestGetOrgMembership(r)
+		member, ok := findOrgMember(rw, r, membership.Org)
+		if !ok {
+			return
+		}
+		// Members may always leave; removing anyone else takes an admin, and
+		// removing an owner takes an owner.
+		if member.UserID != user.ID {
+			role := membership.Member.Role
+			if !role.CanManage() || (member.Role == domain.OrgRoleOwner && role != domain.OrgRoleOwner) {
+				respondError(rw, http.StatusForbidden, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: fmt.Sprintf("A %s of this organization can't do that", role)}},
+				})
+				return
+			}
+		}
+		if membership.Org.Personal {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Nobody can leave their personal organization"}},
+			})
+			return
+		}
+		if member.Role == domain.OrgRoleOwner && member.Status == domain.InvitationAccepted && !hasOtherOwner(rw, membership.Org) {
+			return
+		}
+
+		err := orgStore.DeleteMember(context.Background(), member.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// hasOtherOwner responds 409 when the organization has only one owner, who
+// can't be removed or demoted.
+func hasOtherOwner(rw http.ResponseWriter, org *domain.Organization) bool {
+	owners, err := orgStore.CountOwners(context.Background(), org.ID)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return false
+	}
+	if owners < 2 {
+		respondError(rw, http.StatusConflict, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "An organization must keep at least one owner"}},
+		})
+		return false
+	}
+	return true
+}
+
+func findOrgMember(rw http.ResponseWriter, r *http.Request, org *domain.Organization) (*domain.OrgMember, bool) {
+	member, err := orgStore.FindMember(context.Background(), entityid.ID(chi.URLParam(r, "memberID")))
+	if err == storage.ErrOrgMemberNotFound || (err == nil && member.OrgID != org.ID) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Member not found"}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+func respondToOrgInvitation(rw http.ResponseWriter, r *http.Request, status domain.InvitationStatus) {
+	user := requestGetUser(r)
+
+	invitation, err := orgStore.FindMember(context.Background(), entityid.ID(chi.URLParam(r, "invitationID")))
+	if err == storage.ErrOrgMemberNotFound || (err == nil && !strings.EqualFold(invitation.Email, user.Email)) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Invitation not found"}},
+		})
+		return
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+	if invitation.Status != domain.InvitationPending {
+		respondError(rw, http.StatusConflict, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Invitation already " + string(invitation.Status)}},
+		})
+		return
+	}
+
+	now := time.Now()
+	invitation.Status = status
+	invitation.RespondedAt = &now
+	if status == domain.InvitationAccepted {
+		invitation.UserID = user.ID
+	}
+	err = orgStore.UpdateMember(context.Background(), invitation)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	bytes, err := json.Marshal(invitation)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bytes)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DillonStreator/todos/domain"
+)
+
+// Requests only see the organization the token selects: the signed in user is
+// scoped to it when loaded, so todos in other organizations can't be found,
+// let alone changed.
+func Test_otherOrgTodos(t *testing.T) {
+	user := &domain.User{
+		ID: "u",
+		Projects: domain.Projects{
+			{ID: "pa", OrgID: "a"},
+			{ID: "pb", OrgID: "b"},
+		},
+		Todos: domain.Todos{
+			{ID: "ta", ProjectID: "pa", Title: "a"},
+			{ID: "tb", ProjectID: "pb", Title: "b"},
+		},
+	}
+	user.ScopeToOrg("a")
+
+	if todo := user.FindTodo("tb"); todo.ID != "" {
+		t.Errorf("FindTodo(tb) = %+v, expected it hidden in organization a", todo)
+	}
+	for _, todo := range user.Todos {
+		if todo.ID == "tb" {
+			t.Error("Todos includes tb, expected only organization a's todos")
+		}
+	}
+
+	for _, body := range []string{
+		`{"operations":[{"op":"update","id":"tb","todo":{"title":"taken"}}]}`,
+		`{"operations":[{"op":"delete","id":"tb"}]}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/todos/batch", strings.NewReader(body))
+		r = requestSetUser(r, user)
+		rw := httptest.NewRecorder()
+
+		batchHandler(&countingLimit{limit: 1}).ServeHTTP(rw, r)
+		if rw.Code != http.StatusNotFound {
+			t.Errorf("%s status = %d, expected %d", body, rw.Code, http.StatusNotFound)
+		}
+	}
+	for _, todo := range user.AllTodos() {
+		if todo.ID == "tb" && (todo.Title != "b" || todo.DeletedAt != nil) {
+			t.Errorf("todo tb = %+v, expected it unchanged", todo)
+		}
+	}
+}
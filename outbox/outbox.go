@@ -0,0 +1,200 @@
+// Package outbox relays the domain events written to the outbox alongside the
+// changes that raised them to the subscribers in this process.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// AllEvents subscribes a handler to every type of event.
+const AllEvents = "*"
+
+// Event is a domain event as stored in the outbox, along with how relaying it
+// has gone so far.
+type Event struct {
+	Seq        int64
+	ID         entityid.ID
+	Type       string
+	UserID     entityid.ID
+	OrgID      entityid.ID
+	SubjectID  entityid.ID
+	Payload    json.RawMessage
+	OccurredAt time.Time
+
+	Attempts      int
+	NextAttemptAt *time.Time
+	LastError     string
+	DispatchedAt  *time.Time
+	FailedAt      *time.Time
+}
+
+// Handler reacts to an event. Events are delivered at least once, so handlers
+// must tolerate seeing the same event ID again.
+type Handler func(ctx context.Context, event Event) error
+
+// Source claims the events that are due and records how relaying them went.
+type Source interface {
+	// Pending claims up to limit events that are neither dispatched nor
+	// failed and whose next attempt is due, oldest first, so that other
+	// relays skip them for a while.
+	Pending(ctx context.Context, now time.Time, limit int) ([]Event, error)
+	// Record saves the event after an attempt.
+	Record(ctx context.Context, event Event) error
+}
+
+// Backoff returns how long to wait before retrying an event that has failed
+// attempts times: a second, doubling with every attempt up to an hour.
+func Backoff(attempts int) time.Duration {
+	const max = time.Hour
+	backoff := time.Second
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	return backoff
+}
+
+type Relay struct {
+	Source Source
+	// Interval is how often pending events are looked for when the relay
+	// isn't woken.
+	Interval time.Duration
+	// BatchSize is how many events are claimed at a time.
+	BatchSize int
+	// MaxAttempts is how many times an event is tried before it fails.
+	MaxAttempts int
+	Now         func() time.Time
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	wakeOnce sync.Once
+	wake     chan struct{}
+}
+
+// Subscribe calls handler for every event of the given type, or of every
+// type for AllEvents. Subscribe before calling Run.
+func (r *Relay) Subscribe(eventType string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[string][]Handler)
+	}
+	r.handlers[eventType] = append(r.handlers[eventType], handler)
+}
+
+func (r *Relay) wakeup() chan struct{} {
+	r.wakeOnce.Do(func() {
+		r.wake = make(chan struct{}, 1)
+	})
+	return r.wake
+}
+
+// Wake makes a running relay look for events now instead of at its next
+// interval, typically right after events were saved. It never blocks.
+func (r *Relay) Wake() {
+	select {
+	case r.wakeup() <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays pending events every Interval, or when woken, until ctx is
+// cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Tick(ctx); err != nil {
+			log.Printf("outbox: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wakeup():
+		}
+	}
+}
+
+func (r *Relay) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// Tick relays every event that is due, a batch at a time.
+func (r *Relay) Tick(ctx context.Context) error {
+	for {
+		events, err := r.Source.Pending(ctx, r.now(), r.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			event = r.Dispatch(ctx, event)
+			if err := r.Source.Record(ctx, event); err != nil {
+				return err
+			}
+		}
+		if len(events) < r.BatchSize {
+			return nil
+		}
+	}
+}
+
+// Dispatch hands the event to its subscribers and returns it updated with the
+// outcome. If any of them fails, the event is retried later, so the ones that
+// succeeded see it again.
+func (r *Relay) Dispatch(ctx context.Context, event Event) Event {
+	r.mu.RLock()
+	handlers := append(append([]Handler(nil), r.handlers[event.Type]...), r.handlers[AllEvents]...)
+	r.mu.RUnlock()
+
+	now := r.now()
+	event.Attempts++
+	event.LastError = ""
+	var err error
+	for _, handler := range handlers {
+		if err = r.handle(ctx, handler, event); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		event.NextAttemptAt = nil
+		event.DispatchedAt = &now
+		return event
+	}
+
+	event.LastError = err.Error()
+	if event.Attempts >= r.MaxAttempts {
+		event.NextAttemptAt = nil
+		event.FailedAt = &now
+		log.Printf("outbox: giving up on %s event %s: %s", event.Type, event.ID, err)
+	} else {
+		next := now.Add(Backoff(event.Attempts))
+		event.NextAttemptAt = &next
+	}
+	return event
+}
+
+// handle calls handler, turning a panic into an error so one bad subscriber
+// can't stop the relay.
+func (r *Relay) handle(ctx context.Context, handler Handler, event Event) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, event)
+}
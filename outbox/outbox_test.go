@@ -0,0 +1,160 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{12, 2048 * time.Second},
+		{13, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := Backoff(test.attempts); got != test.expected {
+			t.Errorf("Backoff(%d) = %v, expected %v", test.attempts, got, test.expected)
+		}
+	}
+}
+
+type memorySource struct {
+	events []Event
+}
+
+func (ms *memorySource) Pending(ctx context.Context, now time.Time, limit int) ([]Event, error) {
+	var pending []Event
+	for _, event := range ms.events {
+		if event.DispatchedAt != nil || event.FailedAt != nil {
+			continue
+		}
+		if event.NextAttemptAt != nil && event.NextAttemptAt.After(now) {
+			continue
+		}
+		pending = append(pending, event)
+		if len(pending) == limit {
+			break
+		}
+	}
+	return pending, nil
+}
+
+func (ms *memorySource) Record(ctx context.Context, event Event) error {
+	for i := range ms.events {
+		if ms.events[i].Seq == event.Seq {
+			ms.events[i] = event
+		}
+	}
+	return nil
+}
+
+func TestRelay_Tick(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	source := &memorySource{events: []Event{
+		{Seq: 1, ID: "created", Type: "todo.created"},
+		{Seq: 2, ID: "registered", Type: "user.registered"},
+		{Seq: 3, ID: "completed", Type: "todo.completed"},
+	}}
+	relay := &Relay{
+		Source:      source,
+		BatchSize:   2,
+		MaxAttempts: 2,
+		Now:         func() time.Time { return now },
+	}
+
+	var created, all []string
+	fail := errors.New("unavailable")
+	var completedErr error = fail
+	relay.Subscribe("todo.created", func(ctx context.Context, event Event) error {
+		created = append(created, event.ID.String())
+		return nil
+	})
+	relay.Subscribe("todo.completed", func(ctx context.Context, event Event) error {
+		return completedErr
+	})
+	relay.Subscribe(AllEvents, func(ctx context.Context, event Event) error {
+		all = append(all, event.ID.String())
+		return nil
+	})
+
+	t.Run("dispatches events to their subscribers", func(t *testing.T) {
+		if err := relay.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(created) != 1 || created[0] != "created" {
+			t.Errorf("todo.created handler saw %v, expected [created]", created)
+		}
+		if len(all) != 2 || all[0] != "created" || all[1] != "registered" {
+			t.Errorf("%s handler saw %v, expected [created registered]", AllEvents, all)
+		}
+		for _, event := range source.events[:2] {
+			if event.DispatchedAt == nil {
+				t.Errorf("%s DispatchedAt = nil, expected set", event.ID)
+			}
+		}
+	})
+	t.Run("retries events whose subscribers fail", func(t *testing.T) {
+		event := source.events[2]
+		if event.DispatchedAt != nil || event.Attempts != 1 || event.LastError != fail.Error() {
+			t.Fatalf("DispatchedAt, Attempts, LastError = %v, %d, %q, expected nil, 1, %q", event.DispatchedAt, event.Attempts, event.LastError, fail)
+		}
+		if expected := now.Add(time.Second); !event.NextAttemptAt.Equal(expected) {
+			t.Errorf("NextAttemptAt = %v, expected %v", event.NextAttemptAt, expected)
+		}
+
+		now = now.Add(time.Minute)
+		completedErr = nil
+		if err := relay.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if event := source.events[2]; event.DispatchedAt == nil || event.LastError != "" {
+			t.Errorf("DispatchedAt, LastError = %v, %q, expected set, empty", event.DispatchedAt, event.LastError)
+		}
+	})
+	t.Run("fails events after MaxAttempts", func(t *testing.T) {
+		source.events = append(source.events, Event{Seq: 4, ID: "failing", Type: "todo.completed"})
+		completedErr = fail
+		for i := 0; i < 2; i++ {
+			now = now.Add(time.Minute)
+			if err := relay.Tick(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if event := source.events[3]; event.FailedAt == nil || event.Attempts != 2 {
+			t.Errorf("FailedAt, Attempts = %v, %d, expected set, 2", event.FailedAt, event.Attempts)
+		}
+	})
+}
+
+func TestRelay_Dispatch_panic(t *testing.T) {
+	relay := &Relay{MaxAttempts: 3}
+	relay.Subscribe(AllEvents, func(ctx context.Context, event Event) error {
+		panic("boom")
+	})
+
+	event := relay.Dispatch(context.Background(), Event{ID: "event", Type: "todo.created"})
+	if event.DispatchedAt != nil || event.NextAttemptAt == nil || event.LastError == "" {
+		t.Errorf("DispatchedAt, NextAttemptAt, LastError = %v, %v, %q, expected nil, set, set", event.DispatchedAt, event.NextAttemptAt, event.LastError)
+	}
+}
+
+func TestRelay_Wake(t *testing.T) {
+	relay := &Relay{}
+	relay.Wake()
+	// A second wake while one is pending doesn't block.
+	relay.Wake()
+	select {
+	case <-relay.wakeup():
+	default:
+		t.Error("Wake() didn't wake the relay")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/jsonpatch"
+	"github.com/go-chi/chi"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// todoReadOnlyFields are the members of a todo's JSON document that a patch
+// must leave unchanged.
+var todoReadOnlyFields = map[string]bool{
+	"id":              true,
+	"version":         true,
+	"createdAt":       true,
+	"updatedAt":       true,
+	"progress":        true,
+	"position":        true,
+	"recurrenceStart": true,
+	"completedAt":     true,
+	"archivedAt":      true,
+	"deletedAt":       true,
+}
+
+func registerPatchRoutes(todosRouter chi.Router) {
+	todosRouter.Patch("/{todoID}", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		todo := user.FindTodo(todoID)
+		if todo.ID == "" {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found"}},
+			})
+			return
+		}
+		if !checkIfMatch(rw, r, todo) {
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != mergePatchMediaType && mediaType != jsonPatchMediaType {
+			rw.Header().Set("Accept-Patch", mergePatchMediaType+", "+jsonPatchMediaType)
+			respondError(rw, http.StatusUnsupportedMediaType, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Content-Type must be " + mergePatchMediaType + " or " + jsonPatchMediaType}},
+			})
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		document, err := todoDocument(todo)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		var patched interface{}
+		if mediaType == mergePatchMediaType {
+			var patch interface{}
+			err = json.Unmarshal(body, &patch)
+			if err != nil {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			patched = jsonpatch.MergePatch(document, patch)
+		} else {
+			var operations []jsonpatch.Operation
+			err = json.Unmarshal(body, &operations)
+			if err != nil {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			patched, err = jsonpatch.Apply(document, operations)
+			if err != nil {
+				status := http.StatusUnprocessableEntity
+				if errors.Is(err, jsonpatch.ErrTestFailed) {
+					status = http.StatusConflict
+				}
+				var field string
+				var operationErr *jsonpatch.OperationError
+				if errors.As(err, &operationErr) {
+					field = operationErr.Path
+				}
+				respondError(rw, status, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error(), Field: field}},
+				})
+				return
+			}
+		}
+
+		updatedTodo, fieldErrors := decodePatchedTodo(document, patched)
+		if len(fieldErrors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: fieldErrors})
+			return
+		}
+		if status, errors := updateTodo(r, user, todo, updatedTodo); len(errors) > 0 {
+			respondError(rw, status, ErrorResponse{Errors: errors})
+			return
+		}
+
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		user.Todos.ComputeProgress()
+		bytes, err := json.Marshal(todo)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.Header().Set("ETag", todoETag(todo))
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+}
+
+// todoDocument returns the todo as the generic JSON document patches apply to.
+func todoDocument(todo *domain.Todo) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(todo)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	err = json.Unmarshal(encoded, &document)
+	return document, err
+}
+
+// decodePatchedTodo checks a patched todo document against the original and
+// decodes it, reporting unknown members, changes to read-only members and
+// values of the wrong type against the member they were found in.
+func decodePatchedTodo(original map[string]interface{}, patched interface{}) (*domain.Todo, []ErrorResponseError) {
+	document, ok := patched.(map[string]interface{})
+	if !ok {
+		return nil, []ErrorResponseError{{Message: "patched todo must be a JSON object"}}
+	}
+
+	var fields []string
+	for field := range document {
+		fields = append(fields, field)
+	}
+	for field := range todoReadOnlyFields {
+		if _, ok := document[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var errors []ErrorResponseError
+	for _, field := range fields {
+		if _, ok := original[field]; !ok {
+			errors = append(errors, ErrorResponseError{Message: "unknown field", Field: field})
+			continue
+		}
+		if todoReadOnlyFields[field] {
+			if !reflect.DeepEqual(original[field], document[field]) {
+				errors = append(errors, ErrorResponseError{Message: "field is read-only", Field: field})
+			}
+			continue
+		}
+		if err := decodeTodo(map[string]interface{}{field: document[field]}, &domain.Todo{}); err != nil {
+			errors = append(errors, ErrorResponseError{Message: err.Error(), Field: field})
+		}
+	}
+	if len(errors) > 0 {
+		return nil, errors
+	}
+
+	todo := &domain.Todo{}
+	if err := decodeTodo(document, todo); err != nil {
+		return nil, []ErrorResponseError{{Message: err.Error()}}
+	}
+	return todo, nil
+}
+
+func decodeTodo(document map[string]interface{}, todo *domain.Todo) error {
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(todo)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/events"
+	"github.com/DillonStreator/todos/ordering"
+	"github.com/go-chi/chi"
+)
+
+var positionRebalancer *ordering.Rebalancer
+
+type moveTodoInput struct {
+	After  entityid.ID `json:"after"`
+	Before entityid.ID `json:"before"`
+}
+
+type reorderTodosInput struct {
+	IDs []entityid.ID `json:"ids"`
+}
+
+// nextPosition returns a position after every existing todo.
+func nextPosition(user *domain.User) string {
+	position, _ := ordering.Between(user.Todos.LastPosition(), "")
+	requestRebalanceIfLong(user, position)
+	return position
+}
+
+func requestRebalanceIfLong(user *domain.User, position string) {
+	if len(position) > ordering.MaxKeyLength && positionRebalancer != nil {
+		positionRebalancer.Request(user.ID)
+	}
+}
+
+// rebalancePositions spreads out the positions of the user's todos, saving
+// them through the user like any other change so a concurrent save can't
+// bring back the old positions.
+func rebalancePositions(ctx context.Context, userID entityid.ID) error {
+	return changeUser(userID, userID, func(user *domain.User) bool {
+		return len(user.RebalancePositions()) > 0
+	})
+}
+
+var errNeighbourNotFound = errors.New("neighbouring todo not found")
+
+// movePosition finds the position for a todo placed between the given
+// neighbours. When only one neighbour is given the other is whichever todo
+// currently sits next to it.
+func movePosition(user *domain.User, todo *domain.Todo, input moveTodoInput) (string, error) {
+	others := user.Todos.Active().Filter(func(t *domain.Todo) bool { return t.ID != todo.ID })
+	others.SortByPosition()
+
+	lower, upper := "", ""
+	if input.After != "" {
+		index := others.FindIndexByID(input.After)
+		if index == -1 {
+			return "", errNeighbourNotFound
+		}
+		lower = others[index].Position
+		if input.Before == "" && index+1 < len(others) {
+			upper = others[index+1].Position
+		}
+	}
+	if input.Before != "" {
+		index := others.FindIndexByID(input.Before)
+		if index == -1 {
+			return "", errNeighbourNotFound
+		}
+		upper = others[index].Position
+		if input.After == "" && index > 0 {
+			lower = others[index-1].Position
+		}
+	}
+
+	return ordering.Between(lower, upper)
+}
+
+func registerPositionRoutes(todosRouter chi.Router) {
+	todosRouter.Post("/{todoID}/move", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		todo := user.FindTodo(todoID)
+		if todo.ID == "" {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found"}},
+			})
+			return
+		}
+
+		var input = moveTodoInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil || (input.After == "" && input.Before == "") {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "must provide after or before"}},
+			})
+			return
+		}
+
+		position, err := movePosition(user, todo, input)
+		if err == errNeighbourNotFound {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if err == ordering.ErrInvalidRange {
+			// Neighbours sharing a key leave no room between them; spreading
+			// the keys out lets the client retry.
+			positionRebalancer.Request(user.ID)
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "after must come directly before before"}},
+			})
+			return
+		}
+
+		user.MoveTodo(todo, position, time.Now())
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+		requestRebalanceIfLong(user, position)
+		publishTodoEvent(user, events.TodoUpdated, todo)
+
+		user.Todos.ComputeProgress()
+		bytes, err := json.Marshal(todo)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Post("/reorder", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var input = reorderTodosInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		// The listed todos keep the positions they occupy between them and
+		// are shuffled into the requested order, so todos that weren't listed
+		// don't move.
+		var todos domain.Todos
+		for _, id := range input.IDs {
+			todo := user.FindTodo(id)
+			if todo.ID == "" || todos.FindIndexByID(id) != -1 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "ids must be distinct todo ids", Field: "ids"}},
+				})
+				return
+			}
+			todos = append(todos, todo)
+		}
+		slots := append(domain.Todos(nil), todos...)
+		slots.SortByPosition()
+		for i := 1; i < len(slots); i++ {
+			if slots[i-1].Position == slots[i].Position {
+				positionRebalancer.Request(user.ID)
+				respondError(rw, http.StatusConflict, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "todos share a position, retry shortly"}},
+				})
+				return
+			}
+		}
+
+		positions := make(map[entityid.ID]string)
+		newPositions := make([]string, len(slots))
+		for i, slot := range slots {
+			newPositions[i] = slot.Position
+		}
+		for i, todo := range todos {
+			if todo.Position != newPositions[i] {
+				positions[todo.ID] = newPositions[i]
+			}
+		}
+
+		now := time.Now()
+		for _, todo := range todos {
+			if position, ok := positions[todo.ID]; ok {
+				user.MoveTodo(todo, position, now)
+			}
+		}
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+		user.Todos.ComputeProgress()
+		for _, todo := range todos {
+			if _, ok := positions[todo.ID]; ok {
+				publishTodoEvent(user, events.TodoUpdated, todo)
+			}
+		}
+
+		var reordered = make(domain.Todos, 0)
+		reordered = append(reordered, todos...)
+		bytes, err := marshalTodoList(r, user, reordered)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+}
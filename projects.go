@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-chi/chi"
+)
+
+type projectInput struct {
+	Name *string `json:"name"`
+}
+
+type projectResponse struct {
+	*domain.Project
+	OpenCount      int `json:"openCount"`
+	CompletedCount int `json:"completedCount"`
+}
+
+func newProjectResponse(user *domain.User, project *domain.Project) projectResponse {
+	response := projectResponse{Project: project}
+	for _, todo := range user.ProjectTodos(project.ID) {
+		if todo.Completed {
+			response.CompletedCount++
+		} else {
+			response.OpenCount++
+		}
+	}
+	return response
+}
+
+func validateProject(project *domain.Project) []ErrorResponseError {
+	var errors []ErrorResponseError
+	if project.Name == "" || len(project.Name) > 100 {
+		errors = append(errors, ErrorResponseError{Message: "name must be between 1 and 100 characters", Field: "name"})
+	}
+	return errors
+}
+
+// validateTodoProject checks that a todo is being put into one of the user's
+// active projects. Callers working on a shared project also check the signed
+// in user may edit it; see projectPermitted.
+func validateTodoProject(user *domain.User, todo *domain.Todo) []ErrorResponseError {
+	project := user.Projects.FindByID(todo.ProjectID)
+	if project.ID == "" {
+		return []ErrorResponseError{{Message: "project not found", Field: "projectId"}}
+	}
+	if project.Archived() {
+		return []ErrorResponseError{{Message: "project is archived", Field: "projectId"}}
+	}
+	return nil
+}
+
+func projectsRouter(projectsRouter chi.Router) {
+	projectsRouter.Use(authenticate)
+	projectsRouter.Use(idempotent)
+
+	projectsRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+		includeArchived := r.URL.Query().Get("archived") == "true"
+
+		var projects = make([]projectResponse, 0)
+		for _, project := range user.Projects {
+			if project.Archived() && !includeArchived {
+				continue
+			}
+			projects = append(projects, newProjectResponse(user, project))
+		}
+		bytes, err := json.Marshal(projects)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	projectsRouter.Post("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var input = projectInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		project := &domain.Project{
+			ID:        entityid.Generator.Generate(),
+			OrgID:     user.OrgID,
+			CreatedAt: time.Now(),
+		}
+		if input.Name != nil {
+			project.Name = strings.TrimSpace(*input.Name)
+		}
+		if errors := validateProject(project); len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		user.Projects = append(user.Projects, project)
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		bytes, err := json.Marshal(newProjectResponse(user, project))
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+	registerSharedProjectRoutes(projectsRouter)
+
+	projectsRouter.Route("/{projectID}", func(projectRouter chi.Router) {
+		projectRouter.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				projectID := entityid.ID(chi.URLParam(r, "projectID"))
+				owner, role, ok := projectOwner(rw, r, projectID, domain.PermissionView)
+				if !ok {
+					return
+				}
+				project := owner.Projects.FindByID(projectID)
+				if project.ID == "" {
+					respondError(rw, http.StatusNotFound, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "Project not found"}},
+					})
+					return
+				}
+				access := &projectAccess{Owner: owner, Project: project, Role: role}
+				ctx := context.WithValue(r.Context(), PROJECT_ACCESS_CONTEXT_KEY, access)
+				next.ServeHTTP(rw, r.WithContext(ctx))
+			})
+		})
+
+		projectRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			access := requestGetProjectAccess(r)
+			user, project := access.Owner, access.Project
+
+			bytes, err := json.Marshal(newProjectResponse(user, project))
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		projectRouter.Put("/", func(rw http.ResponseWriter, r *http.Request) {
+			user, project, ok := requireProjectPermission(rw, r, domain.PermissionManage)
+			if !ok {
+				return
+			}
+
+			var input = projectInput{}
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&input)
+			if err != nil {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "invalid input"}},
+				})
+				return
+			}
+
+			updatedProject := *project
+			if input.Name != nil {
+				updatedProject.Name = strings.TrimSpace(*input.Name)
+			}
+			if errors := validateProject(&updatedProject); len(errors) > 0 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+				return
+			}
+			*project = updatedProject
+
+			err = saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
+			bytes, err := json.Marshal(newProjectResponse(user, project))
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		projectRouter.Delete("/", func(rw http.ResponseWriter, r *http.Request) {
+			user, project, ok := requireProjectPermission(rw, r, domain.PermissionOwn)
+			if !ok {
+				return
+			}
+			projectID := project.ID
+
+			if project.Inbox {
+				respondError(rw, http.StatusConflict, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "The inbox cannot be deleted"}},
+				})
+				return
+			}
+
+			// The project's todos are moved into the inbox rather than deleted.
+			user.DeleteProject(projectID, time.Now())
+			err := saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+			if shareStore != nil {
+				err = shareStore.DeleteProject(context.Background(), projectID)
+				if err != nil {
+					respondError(rw, http.StatusInternalServerError, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: err.Error()}},
+					})
+					return
+				}
+			}
+
+			rw.WriteHeader(http.StatusNoContent)
+		})
+		projectRouter.Post("/archive", func(rw http.ResponseWriter, r *http.Request) {
+			setProjectArchived(rw, r, true)
+		})
+		projectRouter.Post("/unarchive", func(rw http.ResponseWriter, r *http.Request) {
+			setProjectArchived(rw, r, false)
+		})
+		projectRouter.Get("/todos", func(rw http.ResponseWriter, r *http.Request) {
+			access := requestGetProjectAccess(r)
+			user, projectID := access.Owner, access.Project.ID
+
+			filters, errors := todoListFilters(r, user)
+			if len(errors) > 0 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+				return
+			}
+
+			var todos = make(domain.Todos, 0)
+			todos = append(todos, user.ProjectTodos(projectID)...)
+			for _, keep := range filters {
+				todos = todos.Filter(keep)
+			}
+			bytes, err := marshalTodoList(r, user, todos)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		registerProjectMemberRoutes(projectRouter)
+	})
+}
+
+func setProjectArchived(rw http.ResponseWriter, r *http.Request, archived bool) {
+	user, project, ok := requireProjectPermission(rw, r, domain.PermissionOwn)
+	if !ok {
+		return
+	}
+
+	if project.Inbox {
+		respondError(rw, http.StatusConflict, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "The inbox cannot be archived"}},
+		})
+		return
+	}
+
+	if archived && !project.Archived() {
+		now := time.Now()
+		project.ArchivedAt = &now
+	} else if !archived {
+		project.ArchivedAt = nil
+	}
+
+	err := saveUser(r, user)
+	if err != nil {
+		respondSaveError(rw, r, err)
+		return
+	}
+
+	bytes, err := json.Marshal(newProjectResponse(user, project))
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bytes)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func Test_newProjectResponse(t *testing.T) {
+	deletedAt := time.Now()
+	user := &domain.User{
+		Projects: domain.Projects{{ID: "work"}, {ID: "home"}, {ID: "empty"}},
+		Todos: domain.Todos{
+			{ID: "a", ProjectID: "work"},
+			{ID: "b", ProjectID: "work", Completed: true},
+			{ID: "c", ProjectID: "work", DeletedAt: &deletedAt},
+			{ID: "d", ProjectID: "home", Completed: true},
+		},
+	}
+
+	tests := []struct {
+		project         entityid.ID
+		open, completed int
+	}{
+		{"work", 1, 1},
+		{"home", 0, 1},
+		{"empty", 0, 0},
+	}
+	for _, tt := range tests {
+		response := newProjectResponse(user, user.Projects.FindByID(tt.project))
+		if response.OpenCount != tt.open || response.CompletedCount != tt.completed {
+			t.Errorf("project %s counts = %d open, %d completed, expected %d open, %d completed", tt.project, response.OpenCount, response.CompletedCount, tt.open, tt.completed)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package recurrence implements the subset of RFC 5545 recurrence rules
+// supported for repeating todos: FREQ (DAILY, WEEKLY, MONTHLY, YEARLY),
+// INTERVAL, BYDAY, COUNT and UNTIL.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// maxPeriods bounds how far ahead occurrences are searched so that rules
+// which rarely or never match cannot loop forever.
+const maxPeriods = 10000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// WeekdayNum is a BYDAY entry. N is the ordinal within the month (1 for the
+// first, -1 for the last) and is zero when every matching weekday applies.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []WeekdayNum
+	Count    int
+	Until    time.Time
+}
+
+// Parse parses an RRULE value such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE".
+// A leading "RRULE:" is accepted.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("recurrence rule is empty")
+	}
+
+	rule := &Rule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid recurrence rule part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), kv[1]
+
+		switch key {
+		case "FREQ":
+			switch freq := Frequency(strings.ToUpper(value)); freq {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = freq
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			rule.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid COUNT %q", value)
+			}
+			rule.Count = count
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekdayNum, err := parseWeekdayNum(day)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, weekdayNum)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported recurrence rule part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, errors.New("recurrence rule requires FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, errors.New("recurrence rule cannot have both COUNT and UNTIL")
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly {
+			return nil, errors.New("BYDAY ordinals are only supported with FREQ=MONTHLY")
+		}
+	}
+	if len(rule.ByDay) > 0 && rule.Freq == Yearly {
+		return nil, errors.New("BYDAY is not supported with FREQ=YEARLY")
+	}
+
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102"} {
+		if until, err := time.Parse(layout, value); err == nil {
+			if layout == "20060102" {
+				// A date-only UNTIL includes the whole day.
+				until = until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			return until, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	weekday, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	var n int
+	if ordinal := s[:len(s)-2]; ordinal != "" {
+		var err error
+		n, err = strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+		}
+	}
+	return WeekdayNum{N: n, Weekday: weekday}, nil
+}
+
+// Occurrences returns up to n occurrences of the rule that fall strictly after
+// after. dtstart is the first occurrence of the series; its wall clock time in
+// loc is kept for every later occurrence.
+func (r *Rule) Occurrences(dtstart, after time.Time, n int, loc *time.Location) []time.Time {
+	dtstart = dtstart.In(loc)
+	occurrences := make([]time.Time, 0, n)
+	if n <= 0 {
+		return occurrences
+	}
+
+	index := 1
+	if dtstart.After(after) {
+		occurrences = append(occurrences, dtstart)
+	}
+
+	for period := 0; period < maxPeriods && len(occurrences) < n; period++ {
+		for _, candidate := range r.candidates(dtstart, period) {
+			if !candidate.After(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && candidate.After(r.Until) {
+				return occurrences
+			}
+			index++
+			if r.Count > 0 && index > r.Count {
+				return occurrences
+			}
+			if candidate.After(after) {
+				occurrences = append(occurrences, candidate)
+				if len(occurrences) == n {
+					return occurrences
+				}
+			}
+		}
+	}
+
+	return occurrences
+}
+
+// Next returns the first occurrence after after, or false when the series
+// has ended.
+func (r *Rule) Next(dtstart, after time.Time, loc *time.Location) (time.Time, bool) {
+	occurrences := r.Occurrences(dtstart, after, 1, loc)
+	if len(occurrences) == 0 {
+		return time.Time{}, false
+	}
+	return occurrences[0], true
+}
+
+// candidates returns the sorted occurrences of the given period, counted in
+// units of Interval from the period containing dtstart.
+func (r *Rule) candidates(dtstart time.Time, period int) []time.Time {
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, dtstart.Nanosecond(), loc)
+	}
+	steps := period * r.Interval
+
+	var candidates []time.Time
+	switch r.Freq {
+	case Daily:
+		day := at(y, m, d+steps)
+		if r.matchesWeekday(day.Weekday()) {
+			candidates = append(candidates, day)
+		}
+	case Weekly:
+		weekStart := at(y, m, d-(int(dtstart.Weekday())+6)%7+7*steps)
+		if len(r.ByDay) == 0 {
+			candidates = append(candidates, at(y, m, d+7*steps))
+			break
+		}
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			if r.matchesWeekday(day.Weekday()) {
+				candidates = append(candidates, day)
+			}
+		}
+	case Monthly:
+		first := at(y, m+time.Month(steps), 1)
+		if len(r.ByDay) == 0 {
+			if day := at(first.Year(), first.Month(), d); day.Month() == first.Month() {
+				candidates = append(candidates, day)
+			}
+			break
+		}
+		candidates = r.monthlyByDay(first)
+	case Yearly:
+		if day := at(y+steps, m, d); day.Month() == m {
+			candidates = append(candidates, day)
+		}
+	}
+
+	return candidates
+}
+
+func (r *Rule) matchesWeekday(weekday time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, day := range r.ByDay {
+		if day.Weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// monthlyByDay expands BYDAY within the month starting at first.
+func (r *Rule) monthlyByDay(first time.Time) []time.Time {
+	var days []time.Time
+	for day := first; day.Month() == first.Month(); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	var candidates []time.Time
+	for _, day := range days {
+		for _, byDay := range r.ByDay {
+			if day.Weekday() != byDay.Weekday {
+				continue
+			}
+			if byDay.N == 0 || byDay.N == ordinalInMonth(day, len(days), byDay.N < 0) {
+				candidates = append(candidates, day)
+				break
+			}
+		}
+	}
+	return candidates
+}
+
+// ordinalInMonth returns which occurrence of its weekday day is within the
+// month, counting from the end (as a negative number) when fromEnd is set.
+func ordinalInMonth(day time.Time, daysInMonth int, fromEnd bool) int {
+	if fromEnd {
+		return -((daysInMonth-day.Day())/7 + 1)
+	}
+	return (day.Day()-1)/7 + 1
+}
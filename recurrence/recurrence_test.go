@@ -0,0 +1,79 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("parses supported parts", func(t *testing.T) {
+		rule, err := Parse("RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=MO,-1FR;COUNT=4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule.Freq != Monthly || rule.Interval != 2 || rule.Count != 4 || len(rule.ByDay) != 2 {
+			t.Errorf("Parse() = %+v", rule)
+		}
+		if rule.ByDay[1] != (WeekdayNum{N: -1, Weekday: time.Friday}) {
+			t.Errorf("ByDay[1] = %+v, expected last friday", rule.ByDay[1])
+		}
+	})
+	for _, s := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;COUNT=2;UNTIL=20210901",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=DAILY;BYMONTH=1",
+	} {
+		t.Run("rejects "+s, func(t *testing.T) {
+			if _, err := Parse(s); err == nil {
+				t.Errorf("Parse(%q) expected an error", s)
+			}
+		})
+	}
+}
+
+func TestRule_Occurrences(t *testing.T) {
+	dtstart := time.Date(2021, 9, 1, 9, 0, 0, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		rule     string
+		after    time.Time
+		n        int
+		expected []string
+	}{
+		{"FREQ=DAILY;INTERVAL=2", dtstart, 3, []string{"2021-09-03", "2021-09-05", "2021-09-07"}},
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR", dtstart, 3, []string{"2021-09-03", "2021-09-06", "2021-09-08"}},
+		{"FREQ=WEEKLY;INTERVAL=2", dtstart, 2, []string{"2021-09-15", "2021-09-29"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", dtstart, 2, []string{"2021-09-24", "2021-10-29"}},
+		{"FREQ=YEARLY", dtstart, 2, []string{"2022-09-01", "2023-09-01"}},
+		{"FREQ=DAILY;COUNT=3", dtstart.Add(-time.Hour), 5, []string{"2021-09-01", "2021-09-02", "2021-09-03"}},
+		{"FREQ=DAILY;COUNT=3", dtstart.AddDate(0, 0, 1), 5, []string{"2021-09-03"}},
+		{"FREQ=DAILY;UNTIL=20210903", dtstart, 5, []string{"2021-09-02", "2021-09-03"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := rule.Occurrences(dtstart, tt.after, tt.n, time.UTC)
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("Occurrences() = %v, expected %v", actual, tt.expected)
+			}
+			for i := range actual {
+				if actual[i].Format("2006-01-02") != tt.expected[i] {
+					t.Errorf("Occurrences()[%d] = %v, expected %v", i, actual[i], tt.expected[i])
+				}
+			}
+		})
+	}
+	t.Run("skips months without the start day", func(t *testing.T) {
+		dtstart := time.Date(2021, 1, 31, 9, 0, 0, 0, time.UTC)
+		rule, _ := Parse("FREQ=MONTHLY")
+		actual := rule.Occurrences(dtstart, dtstart, 2, time.UTC)
+		if len(actual) != 2 || actual[0].Month() != time.March || actual[1].Month() != time.May {
+			t.Errorf("Occurrences() = %v, expected March 31 and May 31", actual)
+		}
+	})
+}
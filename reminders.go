@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/reminders"
+	"github.com/DillonStreator/todos/storage"
+)
+
+// reminderSource marks delivered reminders through the user aggregate, so a
+// save of a copy of the user loaded before the reminder went out can't bring
+// it back.
+type reminderSource struct {
+	*storage.ReminderSource
+}
+
+var _ reminders.Source = reminderSource{}
+
+func (rs reminderSource) MarkReminded(ctx context.Context, userID, todoID entityid.ID, at time.Time) error {
+	return changeUser(userID, userID, func(user *domain.User) bool {
+		todo := user.Todos.FindByID(todoID)
+		if todo.ID == "" {
+			return false
+		}
+		todo.RemindedAt = &at
+		return true
+	})
+}
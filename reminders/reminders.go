@@ -0,0 +1,110 @@
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/notify"
+)
+
+// Candidate is a todo that may have a reminder due, along with its owner.
+type Candidate struct {
+	User *domain.User
+	Todo *domain.Todo
+}
+
+// Source finds todos with reminders and records delivered reminders.
+type Source interface {
+	// FindCandidates returns the todos that may have a reminder due at now.
+	// It may return more than are due, but never fewer.
+	FindCandidates(ctx context.Context, now time.Time) ([]Candidate, error)
+	MarkReminded(ctx context.Context, userID, todoID entityid.ID, at time.Time) error
+}
+
+// Locker runs fn only while holding a lock no other replica holds, reporting
+// whether it ran.
+type Locker interface {
+	Exclusive(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error)
+}
+
+type Scheduler struct {
+	Source   Source
+	Notifier notify.Notifier
+	// Locker, if set, keeps replicas from delivering the same reminders: a
+	// tick is skipped while another replica is running one.
+	Locker   Locker
+	Interval time.Duration
+	Now      func() time.Time
+}
+
+// Run checks for due reminders every Interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Tick(ctx); err != nil {
+			log.Printf("reminders: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick delivers every reminder that is due.
+func (s *Scheduler) Tick(ctx context.Context) error {
+	if s.Locker == nil {
+		return s.deliver(ctx)
+	}
+	_, err := s.Locker.Exclusive(ctx, "reminders", s.deliver)
+	return err
+}
+
+// deliver sends the due reminders. A reminder that fails is logged and left
+// for the next tick, without holding up the others.
+func (s *Scheduler) deliver(ctx context.Context) error {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	candidates, err := s.Source.FindCandidates(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		remindAt, ok := c.Todo.PendingReminder(now, c.User.Location())
+		if !ok {
+			continue
+		}
+
+		err := s.Notifier.Notify(ctx, notify.Notification{
+			UserID:  c.User.ID,
+			Email:   c.User.Email,
+			Subject: fmt.Sprintf("Reminder: %s", c.Todo.Title),
+			Body:    fmt.Sprintf("%q is due %s", c.Todo.Title, c.Todo.DueAt.In(c.User.Location()).Format(time.RFC1123)),
+		})
+		if err != nil {
+			log.Printf("reminders: notifying about %s: %s", c.Todo.ID, err)
+			continue
+		}
+
+		err = s.Source.MarkReminded(ctx, c.User.ID, c.Todo.ID, remindAt)
+		if err != nil {
+			log.Printf("reminders: marking %s reminded: %s", c.Todo.ID, err)
+			continue
+		}
+		c.Todo.RemindedAt = &remindAt
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/notify"
+)
+
+type memorySource struct {
+	candidates []Candidate
+}
+
+func (ms *memorySource) FindCandidates(ctx context.Context, now time.Time) ([]Candidate, error) {
+	return ms.candidates, nil
+}
+
+func (ms *memorySource) MarkReminded(ctx context.Context, userID, todoID entityid.ID, at time.Time) error {
+	return nil
+}
+
+// failingNotifier fails to deliver notifications about one todo.
+type failingNotifier struct {
+	notify.MemoryNotifier
+	title string
+}
+
+func (fn *failingNotifier) Notify(ctx context.Context, n notify.Notification) error {
+	if n.Subject == "Reminder: "+fn.title {
+		return errors.New("unreachable")
+	}
+	return fn.MemoryNotifier.Notify(ctx, n)
+}
+
+type busyLocker struct{}
+
+func (busyLocker) Exclusive(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error) {
+	return false, nil
+}
+
+func TestScheduler_Tick(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	dueAt := now.Add(30 * time.Minute)
+	todo := &domain.Todo{
+		ID:              "todo",
+		Title:           "water plants",
+		CreatedAt:       now.Add(-time.Hour),
+		DueAt:           &dueAt,
+		ReminderOffsets: []int{60, 30, 10},
+	}
+	source := &memorySource{candidates: []Candidate{{
+		User: &domain.User{ID: "user", Email: "user@example.com"},
+		Todo: todo,
+	}}}
+	notifier := &notify.MemoryNotifier{}
+	scheduler := &Scheduler{Source: source, Notifier: notifier, Now: func() time.Time { return now }}
+
+	t.Run("delivers a single notification for passed reminders", func(t *testing.T) {
+		if err := scheduler.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.Sent()) != 1 {
+			t.Fatalf("sent %d notifications, expected 1", len(notifier.Sent()))
+		}
+		expected := dueAt.Add(-30 * time.Minute)
+		if !todo.RemindedAt.Equal(expected) {
+			t.Errorf("RemindedAt = %v, expected %v", todo.RemindedAt, expected)
+		}
+	})
+	t.Run("does not repeat delivered reminders", func(t *testing.T) {
+		if err := scheduler.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.Sent()) != 1 {
+			t.Fatalf("sent %d notifications, expected 1", len(notifier.Sent()))
+		}
+	})
+	t.Run("delivers the next reminder once it passes", func(t *testing.T) {
+		now = now.Add(25 * time.Minute)
+		if err := scheduler.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.Sent()) != 2 {
+			t.Fatalf("sent %d notifications, expected 2", len(notifier.Sent()))
+		}
+	})
+}
+
+func TestScheduler_Tick_failures(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	dueAt := now.Add(30 * time.Minute)
+	newCandidate := func(id entityid.ID, title string) Candidate {
+		return Candidate{
+			User: &domain.User{ID: "user", Email: "user@example.com"},
+			Todo: &domain.Todo{ID: id, Title: title, CreatedAt: now.Add(-time.Hour), DueAt: &dueAt, ReminderOffsets: []int{60}},
+		}
+	}
+	source := &memorySource{candidates: []Candidate{newCandidate("a", "broken"), newCandidate("b", "fine")}}
+
+	t.Run("carries on past a failed notification", func(t *testing.T) {
+		notifier := &failingNotifier{title: "broken"}
+		scheduler := &Scheduler{Source: source, Notifier: notifier, Now: func() time.Time { return now }}
+		if err := scheduler.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.Sent()) != 1 || notifier.Sent()[0].Subject != "Reminder: fine" {
+			t.Errorf("sent %+v, expected only the reminder for fine", notifier.Sent())
+		}
+		if source.candidates[0].Todo.RemindedAt != nil {
+			t.Errorf("RemindedAt = %v for a failed reminder, expected nil", source.candidates[0].Todo.RemindedAt)
+		}
+	})
+	t.Run("skips ticks while another replica holds the lock", func(t *testing.T) {
+		notifier := &notify.MemoryNotifier{}
+		scheduler := &Scheduler{Source: source, Notifier: notifier, Locker: busyLocker{}, Now: func() time.Time { return now }}
+		if err := scheduler.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(notifier.Sent()) != 0 {
+			t.Errorf("sent %d notifications, expected 0", len(notifier.Sent()))
+		}
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	"github.com/DillonStreator/todos/entityid"
 	"github.com/DillonStreator/todos/jwt"
 	"github.com/DillonStreator/todos/passwords"
+	"github.com/DillonStreator/todos/storage"
 	"github.com/eleanorhealth/milo"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -144,19 +146,391 @@ func newInMemoryLimiterMiddleware(r limiter.Rate) *stdlib.Middleware {
 	return limiterMiddleware
 }
 
+// signedInUser verifies the JWT and loads the user it was issued to, scoped to
+// the organization the token selects. On failure it returns the status to
+// respond with.
+func signedInUser(token string) (*domain.User, int, error) {
+	if token == "" {
+		return nil, http.StatusUnauthorized, errors.New("Not authorized")
+	}
+	claim, err := jwt.Verify(token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	orgID := claim.OrgID
+	if orgID == "" {
+		orgID = claim.UserID
+	}
+	if orgID != claim.UserID && orgStore != nil {
+		_, err = orgStore.Membership(context.Background(), orgID, claim.UserID)
+		if err == storage.ErrOrgMemberNotFound {
+			return nil, http.StatusForbidden, errors.New("Not a member of this organization")
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	// Only the organization's projects and todos are read, under row level
+	// security, so nothing of the others' can be reached.
+	user, err := storage.FindUserInOrg(context.Background(), db, claim.UserID, orgID)
+	if err == milo.ErrNotFound {
+		return nil, http.StatusNotFound, errors.New("User not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return user, http.StatusOK, nil
+}
+
+// lastSeenResolution is how out of date LastSeenAt may get before a request
+// saves the user just to update it.
+const lastSeenResolution = time.Minute
+
+// touchUser records that the user was seen and gives them the inbox and todo
+// positions the rest of the application expects. The user is only saved when
+// that changed something, so requests made in parallel don't all write it.
+func touchUser(user *domain.User, now time.Time) error {
+	projects := len(user.Projects)
+	unfiled := user.Todos.Filter(func(t *domain.Todo) bool { return t.ProjectID == "" })
+	user.EnsureInbox(now)
+	repositioned := user.EnsurePositions()
+	if len(user.Projects) == projects && len(unfiled) == 0 && len(repositioned) == 0 &&
+		now.Sub(user.LastSeenAt) < lastSeenResolution {
+		return nil
+	}
+	user.LastSeenAt = now
+	return storage.SaveUser(context.Background(), store, db, user)
+}
+
+// authenticate verifies the JWT in the Authorization header and stores the
+// signed in user on the request context.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		for attempt := 1; ; attempt++ {
+			user, status, err := signedInUser(r.Header.Get("Authorization"))
+			if err != nil {
+				respondError(rw, status, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			// Another request touching the user first leaves nothing to do
+			// once it's loaded again.
+			err = touchUser(user, time.Now())
+			if err == storage.ErrUserConflict && attempt < 3 {
+				continue
+			}
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
+			next.ServeHTTP(rw, requestSetTodoSnapshots(requestSetUser(r, user), user))
+			return
+		}
+	})
+}
+
 type userCredentialsInput struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type userSettingsInput struct {
+	Timezone             *string `json:"timezone"`
+	AutoArchiveAfterDays *int    `json:"autoArchiveAfterDays"`
+}
+
+func validateTodo(todo *domain.Todo) []ErrorResponseError {
+	var errors []ErrorResponseError
+	if len(todo.ReminderOffsets) > 0 && todo.DueAt == nil {
+		errors = append(errors, ErrorResponseError{Message: "reminders require a due date", Field: "reminderOffsets"})
+	}
+	for _, offset := range todo.ReminderOffsets {
+		if offset < 0 {
+			errors = append(errors, ErrorResponseError{Message: "reminder offsets must not be negative", Field: "reminderOffsets"})
+			break
+		}
+	}
+	if !todo.Priority.Valid() {
+		errors = append(errors, ErrorResponseError{Message: "priority must be one of low, medium, high", Field: "priority"})
+	}
+	if _, err := todo.RecurrenceRule(); err != nil {
+		errors = append(errors, ErrorResponseError{Message: err.Error(), Field: "recurrence"})
+	}
+	return errors
+}
+
+// createTodo validates todo and adds it to the user's todos as a new todo,
+// filling in the fields the server owns.
+func createTodo(user *domain.User, todo *domain.Todo) []ErrorResponseError {
+	if errors := validateTodo(todo); len(errors) > 0 {
+		return errors
+	}
+	tags, errors := resolveTags(user, todo.Tags)
+	if len(errors) > 0 {
+		return errors
+	}
+	todo.Tags = tags
+	if errors := validateTodoParent(user, todo, todo.ParentID); len(errors) > 0 {
+		return errors
+	}
+	if todo.ParentID != "" {
+		todo.ProjectID = user.Todos.FindByID(todo.ParentID).ProjectID
+	}
+	if todo.ProjectID == "" {
+		todo.ProjectID = user.EnsureInbox(time.Now()).ID
+	}
+	todo.Position = nextPosition(user)
+	if errors := validateTodoProject(user, todo); len(errors) > 0 {
+		return errors
+	}
+	if errors := validateTodoAssignee(user, todo); len(errors) > 0 {
+		return errors
+	}
+
+	todo.ID = entityid.Generator.Generate()
+	todo.Version = 0
+	todo.Progress = nil
+	todo.CompletedAt = nil
+	todo.ArchivedAt = nil
+	todo.DeletedAt = nil
+	if todo.Completed {
+		now := time.Now()
+		todo.CompletedAt = &now
+	}
+	todo.CreatedAt = time.Now()
+	todo.UpdatedAt = time.Now()
+	todo.RemindedAt = nil
+	todo.NormalizeDue(user.Location())
+	todo.RecurrenceStart = nil
+	if todo.Recurrence != "" {
+		todo.RecurrenceStart = todo.DueAt
+	}
+	user.Todos = append(user.Todos, todo)
+
+	return nil
+}
+
+// editableTodo returns a copy of todo's editable fields, to decode a partial
+// update onto.
+func editableTodo(todo *domain.Todo) *domain.Todo {
+	return &domain.Todo{
+		ProjectID:       todo.ProjectID,
+		ParentID:        todo.ParentID,
+		AssigneeID:      todo.AssigneeID,
+		Completed:       todo.Completed,
+		Title:           todo.Title,
+		Description:     todo.Description,
+		DueAt:           todo.DueAt,
+		AllDay:          todo.AllDay,
+		ReminderOffsets: todo.ReminderOffsets,
+		Recurrence:      todo.Recurrence,
+		Priority:        todo.Priority,
+		Tags:            todo.Tags,
+	}
+}
+
+// updateTodo validates updated, which holds the requested state of todo's
+// editable fields, and applies it to todo and the todos it affects. Nothing is
+// changed unless it all validates, so a failed update can be saved along with
+// other changes. On failure it returns the status and errors to respond with.
+func updateTodo(r *http.Request, user *domain.User, todo, updated *domain.Todo) (int, []ErrorResponseError) {
+	if errors := validateTodo(updated); len(errors) > 0 {
+		return http.StatusBadRequest, errors
+	}
+	tags, errors := resolveTags(user, updated.Tags)
+	if len(errors) > 0 {
+		return http.StatusBadRequest, errors
+	}
+	updated.Tags = tags
+	if updated.ParentID != todo.ParentID {
+		if errors := validateTodoParent(user, todo, updated.ParentID); len(errors) > 0 {
+			return http.StatusBadRequest, errors
+		}
+		if updated.ParentID != "" {
+			updated.ProjectID = user.Todos.FindByID(updated.ParentID).ProjectID
+		}
+	}
+	if updated.ProjectID != todo.ProjectID {
+		if errors := validateTodoProject(user, updated); len(errors) > 0 {
+			return http.StatusBadRequest, errors
+		}
+		if !projectPermitted(r, user, updated.ProjectID, domain.PermissionEdit) {
+			return http.StatusBadRequest, []ErrorResponseError{{Message: "project not found", Field: "projectId"}}
+		}
+	}
+	if updated.AssigneeID != todo.AssigneeID || updated.ProjectID != todo.ProjectID {
+		if errors := validateTodoAssignee(user, updated); len(errors) > 0 {
+			return http.StatusBadRequest, errors
+		}
+	}
+	updated.NormalizeDue(user.Location())
+
+	recurrenceStart := todo.RecurrenceStart
+	if updated.Recurrence != todo.Recurrence || recurrenceStart == nil {
+		recurrenceStart = updated.DueAt
+	}
+	if updated.Recurrence == "" {
+		recurrenceStart = nil
+	}
+	var next *domain.Todo
+	if !todo.Completed && updated.Completed && updated.Recurrence != "" {
+		updated.RecurrenceStart = recurrenceStart
+		var err error
+		next, err = updated.NextOccurrence(user.Location())
+		if err != nil && err != domain.ErrSeriesEnded {
+			return http.StatusInternalServerError, []ErrorResponseError{{Message: err.Error()}}
+		}
+		// The series continues on the next occurrence.
+		updated.Recurrence = ""
+		recurrenceStart = nil
+	}
+
+	if next != nil {
+		next.ID = entityid.Generator.Generate()
+		next.Position = nextPosition(user)
+		next.CreatedAt = time.Now()
+		next.UpdatedAt = time.Now()
+		user.Todos = append(user.Todos, next)
+	}
+	if updated.ProjectID != todo.ProjectID {
+		for _, descendant := range user.Todos.Descendants(todo.ID) {
+			descendant.ProjectID = updated.ProjectID
+		}
+	}
+	if updated.Completed && r.URL.Query().Get("completeSubtasks") == "true" {
+		for _, subtask := range user.Todos.CompleteSubtasks(todo.ID, time.Now()) {
+			subtask.UpdatedAt = time.Now()
+		}
+	}
+	if !sameTime(todo.DueAt, updated.DueAt) {
+		todo.RemindedAt = nil
+	}
+	todo.RecurrenceStart = recurrenceStart
+	todo.SetCompleted(updated.Completed, time.Now())
+	todo.Title = updated.Title
+	todo.Description = updated.Description
+	todo.DueAt = updated.DueAt
+	todo.AllDay = updated.AllDay
+	todo.ReminderOffsets = updated.ReminderOffsets
+	todo.Recurrence = updated.Recurrence
+	todo.Priority = updated.Priority
+	todo.Tags = updated.Tags
+	todo.ProjectID = updated.ProjectID
+	todo.ParentID = updated.ParentID
+	todo.AssigneeID = updated.AssigneeID
+	todo.UpdatedAt = time.Now()
+
+	return http.StatusOK, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// splitQueryValues flattens repeated and comma separated query values.
+func splitQueryValues(values []string) []string {
+	var split []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				split = append(split, v)
+			}
+		}
+	}
+	return split
+}
+
+// todoListFilters builds the filters requested by the GET /todos query string.
+func todoListFilters(r *http.Request, user *domain.User) ([]func(*domain.Todo) bool, []ErrorResponseError) {
+	var filters []func(*domain.Todo) bool
+	var errors []ErrorResponseError
+	query := r.URL.Query()
+	now := time.Now()
+	loc := user.Location()
+
+	switch due := query.Get("due"); due {
+	case "":
+	case "today":
+		filters = append(filters, func(t *domain.Todo) bool { return t.IsDueToday(now, loc) })
+	case "overdue":
+		filters = append(filters, func(t *domain.Todo) bool { return t.IsOverdue(now, loc) })
+	case "upcoming":
+		filters = append(filters, func(t *domain.Todo) bool { return t.IsUpcoming(now, loc) })
+	default:
+		errors = append(errors, ErrorResponseError{Message: "due must be one of today, overdue, upcoming", Field: "due"})
+	}
+
+	switch archived := query.Get("archived"); archived {
+	case "", "false":
+		filters = append(filters, func(t *domain.Todo) bool { return !t.Archived() })
+	case "true":
+		filters = append(filters, func(t *domain.Todo) bool { return t.Archived() })
+	default:
+		errors = append(errors, ErrorResponseError{Message: "archived must be true or false", Field: "archived"})
+	}
+
+	switch assignee := query.Get("assignee"); assignee {
+	case "":
+	case "me":
+		userID := requestGetUser(r).ID
+		filters = append(filters, func(t *domain.Todo) bool { return t.AssigneeID == userID })
+	default:
+		errors = append(errors, ErrorResponseError{Message: "assignee must be me", Field: "assignee"})
+	}
+
+	if projectID := query.Get("project"); projectID != "" {
+		filters = append(filters, func(t *domain.Todo) bool { return t.ProjectID.String() == projectID })
+	}
+
+	for _, name := range splitQueryValues(query["tag"]) {
+		tag := user.Tags.FindByName(name)
+		filters = append(filters, func(t *domain.Todo) bool { return tag.ID != "" && t.HasTag(tag.ID) })
+	}
+
+	if priorityValues := splitQueryValues(query["priority"]); len(priorityValues) > 0 {
+		priorities := make(map[domain.Priority]bool)
+		for _, value := range priorityValues {
+			priority := domain.Priority(value)
+			if value == "none" {
+				priority = domain.PriorityNone
+			} else if priority == domain.PriorityNone || !priority.Valid() {
+				errors = append(errors, ErrorResponseError{Message: "priority must be one of none, low, medium, high", Field: "priority"})
+				continue
+			}
+			priorities[priority] = true
+		}
+		filters = append(filters, func(t *domain.Todo) bool { return priorities[t.Priority] })
+	}
+
+	return filters, errors
+}
+
 func getMux() http.Handler {
+	maxSubtaskDepth = getEnvInt("MAX_SUBTASK_DEPTH", maxSubtaskDepth)
+	maxBatchOperations = getEnvInt("MAX_BATCH_OPERATIONS", maxBatchOperations)
+	eventsHeartbeatInterval = getEnvDuration("EVENTS_HEARTBEAT_INTERVAL", eventsHeartbeatInterval)
+	wsSendBuffer = getEnvInt("WS_SEND_BUFFER", wsSendBuffer)
+	maxAttachmentSize = int64(getEnvInt("MAX_ATTACHMENT_SIZE", int(maxAttachmentSize)))
+	attachmentQuota = int64(getEnvInt("ATTACHMENT_QUOTA", int(attachmentQuota)))
+	attachmentURLLifetime = getEnvDuration("ATTACHMENT_URL_LIFETIME", attachmentURLLifetime)
+	attachmentURLSecret = getAttachmentURLSecret()
+
 	r := chi.NewRouter()
 
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 			rw.Header().Set("Access-Control-Allow-Origin", "*")
-			rw.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
-			rw.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			rw.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, PATCH, DELETE, OPTIONS")
+			rw.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match, If-None-Match, Idempotency-Key")
+			rw.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed")
 			if r.Method == "OPTIONS" {
 				return
 			}
@@ -227,7 +601,7 @@ func getMux() http.Handler {
 			}
 
 			user.LastSeenAt = time.Now()
-			store.Save(context.Background(), user)
+			storage.SaveUser(context.Background(), store, db, user)
 			jwtInput := jwt.Input{
 				UserID: user.ID,
 				Email:  user.Email,
@@ -263,7 +637,7 @@ func getMux() http.Handler {
 				Limit:        5,
 			}),
 		)
-		usersRouter.With(userCreationLimiter.Handler).Post("/", func(rw http.ResponseWriter, r *http.Request) {
+		usersRouter.With(idempotent, userCreationLimiter.Handler).Post("/", func(rw http.ResponseWriter, r *http.Request) {
 			var userCredsInput = userCredentialsInput{}
 			decoder := json.NewDecoder(r.Body)
 			decoder.DisallowUnknownFields()
@@ -308,10 +682,29 @@ func getMux() http.Handler {
 				CreatedAt:  time.Now(),
 				LastSeenAt: time.Now(),
 				Todos:      make([]*domain.Todo, 0),
+				Tags:       make([]*domain.Tag, 0),
 				Email:      userCredsInput.Email,
 				Password:   string(hashedPassword),
 			}
-			store.Save(context.Background(), user)
+			user.ScopeToOrg(user.ID)
+			user.EnsureInbox(time.Now())
+			user.Register(time.Now())
+			// The personal organization the token scopes the user to is created
+			// first, so a user is never saved without it.
+			if orgStore != nil {
+				err = orgStore.EnsurePersonal(context.Background(), user)
+				if err != nil {
+					respondError(rw, http.StatusInternalServerError, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: err.Error()}},
+					})
+					return
+				}
+			}
+			err = saveUserEvents(user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
 			bytes, err := json.Marshal(user)
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
@@ -323,59 +716,115 @@ func getMux() http.Handler {
 			rw.WriteHeader(http.StatusCreated)
 			rw.Write(bytes)
 		})
-	})
+		usersRouter.With(authenticate, idempotent).Put("/me", func(rw http.ResponseWriter, r *http.Request) {
+			user := requestGetUser(r)
 
-	r.Route("/todos", func(todosRouter chi.Router) {
-		todosRouter.Use(func(next http.Handler) http.Handler {
-			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-				token := r.Header.Get("Authorization")
-				if token == "" {
-					respondError(rw, http.StatusUnauthorized, ErrorResponse{
-						Errors: []ErrorResponseError{{Message: "Not authorized"}},
+			var settingsInput = userSettingsInput{}
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&settingsInput)
+			if err != nil {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "invalid input"}},
+				})
+				return
+			}
+
+			if settingsInput.Timezone != nil {
+				if _, err := time.LoadLocation(*settingsInput.Timezone); err != nil {
+					respondError(rw, http.StatusBadRequest, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "unknown time zone", Field: "timezone"}},
 					})
 					return
 				}
-				claim, err := jwt.Verify(token)
-				if err != nil {
-					respondError(rw, http.StatusUnauthorized, ErrorResponse{
-						Errors: []ErrorResponseError{{Message: err.Error()}},
+				user.Timezone = *settingsInput.Timezone
+			}
+			if settingsInput.AutoArchiveAfterDays != nil {
+				if *settingsInput.AutoArchiveAfterDays < 0 {
+					respondError(rw, http.StatusBadRequest, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "must not be negative", Field: "autoArchiveAfterDays"}},
 					})
 					return
 				}
+				user.AutoArchiveAfterDays = *settingsInput.AutoArchiveAfterDays
+			}
 
-				var user = &domain.User{}
-				store.FindByID(user, claim.UserID)
-				if user.ID == "" {
-					respondError(rw, http.StatusNotFound, ErrorResponse{
-						Errors: []ErrorResponseError{{Message: "User not found"}},
-					})
+			err = storage.SaveUser(context.Background(), store, db, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
+			bytes, err := json.Marshal(user)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+	})
+
+	registerWebSocketRoutes(r, r)
+	registerAttachmentDownloadRoutes(r)
+
+	r.Route("/tags", tagsRouter)
+	r.Route("/projects", projectsRouter)
+	r.Route("/invitations", invitationsRouter)
+	r.Route("/orgs", orgsRouter)
+	r.Route("/webhooks", webhooksRouter)
+	r.Route("/admin", adminRouter)
+
+	r.Route("/todos", func(todosRouter chi.Router) {
+		todosRouter.Use(authenticate)
+		todosRouter.Use(idempotent)
+
+		todosRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			user := requestGetUser(r)
+			// A shared project's todos are listed from its owner's todos.
+			if projectID := r.URL.Query().Get("project"); projectID != "" {
+				var ok bool
+				user, _, ok = projectOwner(rw, r, entityid.ID(projectID), domain.PermissionView)
+				if !ok {
 					return
 				}
+			}
+			filters, errors := todoListFilters(r, user)
+			if len(errors) > 0 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+				return
+			}
 
-				user.LastSeenAt = time.Now()
-				err = store.Save(context.Background(), user)
+			var todos = make(domain.Todos, 0)
+			todos = append(todos, user.Todos.Active()...)
+			for _, keep := range filters {
+				todos = todos.Filter(keep)
+			}
+			// Todos assigned to the user are listed from every project they
+			// can see.
+			if r.URL.Query().Get("assignee") == "me" && r.URL.Query().Get("project") == "" {
+				shared, err := sharedAssignedTodos(r)
 				if err != nil {
 					respondError(rw, http.StatusInternalServerError, ErrorResponse{
 						Errors: []ErrorResponseError{{Message: err.Error()}},
 					})
 					return
 				}
-
-				next.ServeHTTP(rw, requestSetUser(r, user))
-			})
-		})
-
-		todosRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
-			user := requestGetUser(r)
-			var todos = make([]*domain.Todo, 0)
-			todos = append(todos, user.Todos...)
-			bytes, err := json.Marshal(todos)
+				todos = append(todos, shared...)
+			}
+			bytes, err := marshalTodoList(r, user, todos)
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
 					Errors: []ErrorResponseError{{Message: err.Error()}},
 				})
 				return
 			}
+			if notModified(rw, r, contentETag(bytes)) {
+				return
+			}
 
 			rw.WriteHeader(http.StatusOK)
 			rw.Write(bytes)
@@ -401,11 +850,32 @@ func getMux() http.Handler {
 				return
 			}
 
-			todo.ID = entityid.Generator.Generate()
-			todo.CreatedAt = time.Now()
-			todo.UpdatedAt = time.Now()
-			user.Todos = append(user.Todos, todo)
-			err = store.Save(context.Background(), user)
+			// Todos created under a shared todo or in a shared project belong
+			// to the project's owner.
+			var ok bool
+			if todo.ParentID != "" {
+				user, ok = todoOwner(rw, r, todo.ParentID, domain.PermissionEdit)
+			} else if todo.ProjectID != "" {
+				user, _, ok = projectOwner(rw, r, todo.ProjectID, domain.PermissionEdit)
+			} else {
+				ok = true
+			}
+			if !ok {
+				return
+			}
+
+			if errors := createTodo(user, todo); len(errors) > 0 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+				return
+			}
+			err = saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
+			user.Todos.ComputeProgress()
+			bytes, err := json.Marshal(todo)
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
 					Errors: []ErrorResponseError{{Message: err.Error()}},
@@ -413,6 +883,29 @@ func getMux() http.Handler {
 				return
 			}
 
+			rw.WriteHeader(http.StatusCreated)
+			rw.Write(bytes)
+		})
+		todosRouter.Get("/{todoID}", func(rw http.ResponseWriter, r *http.Request) {
+			todoID := entityid.ID(chi.URLParam(r, "todoID"))
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionView)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
+			if todo.ID == "" {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Todo not found"}},
+				})
+				return
+			}
+			// The ETag is the todo's version, the same one writes return and
+			// If-Match is compared with.
+			if notModified(rw, r, todoETag(todo)) {
+				return
+			}
+
+			user.Todos.ComputeProgress()
 			bytes, err := json.Marshal(todo)
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
@@ -421,26 +914,27 @@ func getMux() http.Handler {
 				return
 			}
 
-			rw.WriteHeader(http.StatusCreated)
+			rw.WriteHeader(http.StatusOK)
 			rw.Write(bytes)
 		})
 		todosRouter.Put("/{todoID}", func(rw http.ResponseWriter, r *http.Request) {
-			user := requestGetUser(r)
-
 			todoID := entityid.ID(chi.URLParam(r, "todoID"))
-			todo := user.Todos.FindByID(todoID)
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
 			if todo.ID == "" {
 				respondError(rw, http.StatusNotFound, ErrorResponse{
 					Errors: []ErrorResponseError{{Message: "Todo not found"}},
 				})
 				return
 			}
-
-			var updatedTodo = &domain.Todo{
-				Completed:   todo.Completed,
-				Title:       todo.Title,
-				Description: todo.Description,
+			if !checkIfMatch(rw, r, todo) {
+				return
 			}
+
+			var updatedTodo = editableTodo(todo)
 			decoder := json.NewDecoder(r.Body)
 			decoder.DisallowUnknownFields()
 			err := decoder.Decode(updatedTodo)
@@ -451,19 +945,83 @@ func getMux() http.Handler {
 				return
 			}
 
-			todo.Completed = updatedTodo.Completed
-			todo.Title = updatedTodo.Title
-			todo.Description = updatedTodo.Description
-			todo.UpdatedAt = time.Now()
+			if status, errors := updateTodo(r, user, todo, updatedTodo); len(errors) > 0 {
+				respondError(rw, status, ErrorResponse{Errors: errors})
+				return
+			}
 
-			err = store.Save(context.Background(), user)
+			err = saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
+			user.Todos.ComputeProgress()
+			bytes, err := json.Marshal(todo)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.Header().Set("ETag", todoETag(todo))
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		registerBatchRoutes(todosRouter, todoCreationLimiter.Limiter)
+		registerSyncRoutes(todosRouter)
+		registerEventRoutes(todosRouter)
+		registerPatchRoutes(todosRouter)
+		registerPositionRoutes(todosRouter)
+		registerTrashRoutes(todosRouter)
+		registerArchiveRoutes(todosRouter)
+		registerHistoryRoutes(todosRouter)
+		registerAssigneeRoutes(todosRouter)
+		registerCommentRoutes(todosRouter)
+		registerAttachmentRoutes(todosRouter)
+		todosRouter.Post("/{todoID}/skip", func(rw http.ResponseWriter, r *http.Request) {
+			todoID := entityid.ID(chi.URLParam(r, "todoID"))
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
+			if todo.ID == "" {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Todo not found"}},
+				})
+				return
+			}
+			if todo.Recurrence == "" {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Todo does not recur"}},
+				})
+				return
+			}
+
+			err := todo.SkipOccurrence(user.Location())
+			if err == domain.ErrSeriesEnded {
+				respondError(rw, http.StatusConflict, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
 					Errors: []ErrorResponseError{{Message: err.Error()}},
 				})
 				return
 			}
+			todo.UpdatedAt = time.Now()
+
+			err = saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
 
+			user.Todos.ComputeProgress()
 			bytes, err := json.Marshal(todo)
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
@@ -475,20 +1033,85 @@ func getMux() http.Handler {
 			rw.WriteHeader(http.StatusOK)
 			rw.Write(bytes)
 		})
-		todosRouter.Delete("/{todoID}", func(rw http.ResponseWriter, r *http.Request) {
-			user := requestGetUser(r)
+		todosRouter.Get("/{todoID}/occurrences", func(rw http.ResponseWriter, r *http.Request) {
+			todoID := entityid.ID(chi.URLParam(r, "todoID"))
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionView)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
+			if todo.ID == "" {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Todo not found"}},
+				})
+				return
+			}
+
+			count := 5
+			if countParam := r.URL.Query().Get("count"); countParam != "" {
+				var err error
+				count, err = strconv.Atoi(countParam)
+				if err != nil || count < 1 || count > 100 {
+					respondError(rw, http.StatusBadRequest, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "count must be between 1 and 100", Field: "count"}},
+					})
+					return
+				}
+			}
+
+			occurrences, err := todo.UpcomingOccurrences(count, user.Location())
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			if occurrences == nil {
+				occurrences = make([]time.Time, 0)
+			}
+
+			bytes, err := json.Marshal(occurrences)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
 
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		todosRouter.Put("/{todoID}/tags/{tagID}", func(rw http.ResponseWriter, r *http.Request) {
 			todoID := entityid.ID(chi.URLParam(r, "todoID"))
-			index := user.Todos.FindIndexByID(todoID)
-			if index == -1 {
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
+			if todo.ID == "" {
 				respondError(rw, http.StatusNotFound, ErrorResponse{
 					Errors: []ErrorResponseError{{Message: "Todo not found"}},
 				})
 				return
 			}
+			tag := user.Tags.FindByID(entityid.ID(chi.URLParam(r, "tagID")))
+			if tag.ID == "" {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Tag not found"}},
+				})
+				return
+			}
+
+			todo.AddTag(tag)
+			todo.UpdatedAt = time.Now()
+			err := saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
 
-			user.Todos = append(user.Todos[:index], user.Todos[index+1:]...)
-			err := store.Save(context.Background(), user)
+			user.Todos.ComputeProgress()
+			bytes, err := json.Marshal(todo)
 			if err != nil {
 				respondError(rw, http.StatusInternalServerError, ErrorResponse{
 					Errors: []ErrorResponseError{{Message: err.Error()}},
@@ -496,6 +1119,65 @@ func getMux() http.Handler {
 				return
 			}
 
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		todosRouter.Delete("/{todoID}/tags/{tagID}", func(rw http.ResponseWriter, r *http.Request) {
+			todoID := entityid.ID(chi.URLParam(r, "todoID"))
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
+			if todo.ID == "" {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Todo not found"}},
+				})
+				return
+			}
+			tagID := entityid.ID(chi.URLParam(r, "tagID"))
+			if !todo.HasTag(tagID) {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Tag not attached to todo"}},
+				})
+				return
+			}
+
+			todo.RemoveTag(tagID)
+			todo.UpdatedAt = time.Now()
+			err := saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
+			rw.WriteHeader(http.StatusNoContent)
+		})
+		todosRouter.Delete("/{todoID}", func(rw http.ResponseWriter, r *http.Request) {
+			todoID := entityid.ID(chi.URLParam(r, "todoID"))
+			user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+			if !ok {
+				return
+			}
+			todo := user.FindTodo(todoID)
+			if todo.ID == "" {
+				respondError(rw, http.StatusNotFound, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Todo not found"}},
+				})
+				return
+			}
+			if !checkIfMatch(rw, r, todo) {
+				return
+			}
+
+			user.TrashTodo(todoID, time.Now())
+
+			err := saveUser(r, user)
+			if err != nil {
+				respondSaveError(rw, r, err)
+				return
+			}
+
 			rw.WriteHeader(http.StatusNoContent)
 		})
 	})
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/notify"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/eleanorhealth/milo"
+	"github.com/go-chi/chi"
+)
+
+var shareStore *storage.ShareStore
+
+type projectAccessContextKey string
+
+var PROJECT_ACCESS_CONTEXT_KEY = projectAccessContextKey("projectAccess")
+
+// projectAccess is how the signed in user reached a project: the user whose
+// aggregate holds it and the role they have on it.
+type projectAccess struct {
+	Owner   *domain.User
+	Project *domain.Project
+	Role    domain.Role
+}
+
+func requestGetProjectAccess(r *http.Request) *projectAccess {
+	return r.Context().Value(PROJECT_ACCESS_CONTEXT_KEY).(*projectAccess)
+}
+
+// projectOwner returns the user whose aggregate holds the project and the
+// signed in user's role on it. Projects that aren't the user's own are found
+// through an accepted membership in the organization the user is signed in
+// to, which is read on every request so revoked access stops working
+// immediately. When the project isn't shared with the
+// user it returns them with no role, leaving the caller to report it missing.
+// It responds and returns false when the role doesn't allow perm.
+func projectOwner(rw http.ResponseWriter, r *http.Request, projectID entityid.ID, perm domain.Permission) (*domain.User, domain.Role, bool) {
+	user := requestGetUser(r)
+	if user.Projects.FindByID(projectID).ID != "" {
+		return user, domain.RoleOwner, true
+	}
+	if shareStore == nil {
+		return user, "", true
+	}
+
+	member, err := shareStore.OrgMembership(context.Background(), projectID, user.ID, user.OrgID)
+	if err == storage.ErrMemberNotFound {
+		return user, "", true
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, "", false
+	}
+	if !member.Role.Allows(perm) {
+		respondError(rw, http.StatusForbidden, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: fmt.Sprintf("A %s of this project can't do that", member.Role)}},
+		})
+		return nil, "", false
+	}
+
+	// The project is shared in the organization the user is signed in to, so
+	// the owner is loaded in it like the user was.
+	owner, err := storage.FindUserInOrg(context.Background(), db, member.OwnerID, user.OrgID)
+	if err == milo.ErrNotFound {
+		return user, "", true
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, "", false
+	}
+	requestAddTodoSnapshots(r, owner)
+	return owner, member.Role, true
+}
+
+// todoOwner returns the user whose aggregate holds the todo, checking the
+// signed in user may work on it with perm. Todos the user can't reach are
+// left for the caller to report missing.
+func todoOwner(rw http.ResponseWriter, r *http.Request, todoID entityid.ID, perm domain.Permission) (*domain.User, bool) {
+	user := requestGetUser(r)
+	if user.Todos.FindByID(todoID).ID != "" || shareStore == nil {
+		return user, true
+	}
+
+	projectID, err := shareStore.TodoProject(context.Background(), todoID)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	if projectID == "" {
+		return user, true
+	}
+	owner, _, ok := projectOwner(rw, r, projectID, perm)
+	return owner, ok
+}
+
+// projectPermitted reports whether the signed in user may work on one of the
+// owner's projects with perm.
+func projectPermitted(r *http.Request, owner *domain.User, projectID entityid.ID, perm domain.Permission) bool {
+	if owner.ID == requestGetUser(r).ID {
+		return true
+	}
+	if shareStore == nil {
+		return false
+	}
+	user := requestGetUser(r)
+	member, err := shareStore.OrgMembership(context.Background(), projectID, user.ID, user.OrgID)
+	return err == nil && member.Role.Allows(perm)
+}
+
+// requireProjectPermission returns the project the request is for and the
+// user who owns it, responding 403 when the signed in user's role doesn't
+// allow perm.
+func requireProjectPermission(rw http.ResponseWriter, r *http.Request, perm domain.Permission) (*domain.User, *domain.Project, bool) {
+	access := requestGetProjectAccess(r)
+	if !access.Role.Allows(perm) {
+		respondError(rw, http.StatusForbidden, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: fmt.Sprintf("A %s of this project can't do that", access.Role)}},
+		})
+		return nil, nil, false
+	}
+	return access.Owner, access.Project, true
+}
+
+type projectMemberInput struct {
+	Email *string      `json:"email"`
+	Role  *domain.Role `json:"role"`
+}
+
+type sharedProjectResponse struct {
+	projectResponse
+	OwnerID entityid.ID `json:"ownerId"`
+	Role    domain.Role `json:"role"`
+}
+
+func registerSharedProjectRoutes(projectsRouter chi.Router) {
+	projectsRouter.Get("/shared", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		members, err := shareStore.ListAccepted(context.Background(), user.ID, user.OrgID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		var projects = make([]sharedProjectResponse, 0)
+		owners := make(map[entityid.ID]*domain.User)
+		for _, member := range members {
+			owner, ok := owners[member.OwnerID]
+			if !ok {
+				var err error
+				owner, err = storage.FindUserInOrg(context.Background(), db, member.OwnerID, user.OrgID)
+				if err == milo.ErrNotFound {
+					owner, err = &domain.User{}, nil
+				}
+				if err != nil {
+					respondError(rw, http.StatusInternalServerError, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: err.Error()}},
+					})
+					return
+				}
+				owners[member.OwnerID] = owner
+			}
+			project := owner.Projects.FindByID(member.ProjectID)
+			if project.ID == "" {
+				continue
+			}
+			projects = append(projects, sharedProjectResponse{
+				projectResponse: newProjectResponse(owner, project),
+				OwnerID:         owner.ID,
+				Role:            member.Role,
+			})
+		}
+
+		bytes, err := json.Marshal(projects)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+}
+
+func registerProjectMemberRoutes(projectRouter chi.Router) {
+	projectRouter.Get("/members", func(rw http.ResponseWriter, r *http.Request) {
+		_, project, ok := requireProjectPermission(rw, r, domain.PermissionView)
+		if !ok {
+			return
+		}
+
+		members, err := shareStore.ListProject(context.Background(), project.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if members == nil {
+			members = make([]*domain.ProjectMember, 0)
+		}
+
+		bytes, err := json.Marshal(members)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	projectRouter.Post("/members", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+		owner, project, ok := requireProjectPermission(rw, r, domain.PermissionManage)
+		if !ok {
+			return
+		}
+		if project.Inbox {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "The inbox cannot be shared"}},
+			})
+			return
+		}
+
+		var input = projectMemberInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		var errors []ErrorResponseError
+		var email string
+		if input.Email != nil {
+			email = strings.TrimSpace(*input.Email)
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			errors = append(errors, ErrorResponseError{Message: "must be a valid email address", Field: "email"})
+		} else if strings.EqualFold(email, owner.Email) || strings.EqualFold(email, user.Email) {
+			errors = append(errors, ErrorResponseError{Message: "cannot invite yourself or the owner", Field: "email"})
+		}
+		if input.Role == nil || !input.Role.Valid() {
+			errors = append(errors, ErrorResponseError{Message: "role must be one of viewer, editor, admin", Field: "role"})
+		}
+		if len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		// Projects in a shared organization stay inside it.
+		if project.OrgID != owner.ID && orgStore != nil {
+			orgMember, err := orgStore.FindMemberByEmail(context.Background(), project.OrgID, email)
+			if err != nil && err != storage.ErrOrgMemberNotFound {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			if orgMember == nil || orgMember.Status != domain.InvitationAccepted {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "must be a member of the project's organization", Field: "email"}},
+				})
+				return
+			}
+		}
+
+		member, err := shareStore.FindByEmail(context.Background(), project.ID, email)
+		if err != nil && err != storage.ErrMemberNotFound {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if member != nil && member.Status != domain.InvitationDeclined {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "already invited", Field: "email"}},
+			})
+			return
+		}
+
+		// Inviting someone who declined asks them again.
+		isNew := member == nil
+		if isNew {
+			member = &domain.ProjectMember{
+				ID:        entityid.Generator.Generate(),
+				ProjectID: project.ID,
+				OwnerID:   owner.ID,
+				Email:     email,
+			}
+		}
+		member.UserID = ""
+		member.Role = *input.Role
+		member.Status = domain.InvitationPending
+		member.InvitedBy = user.ID
+		member.CreatedAt = time.Now()
+		member.RespondedAt = nil
+		if isNew {
+			err = shareStore.Create(context.Background(), member)
+		} else {
+			err = shareStore.Update(context.Background(), member)
+		}
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		err = sendNotification(notify.Notification{
+			Email:   email,
+			Subject: "You've been invited to " + project.Name,
+			Body:    fmt.Sprintf("%s invited you to %s as a %s.", user.Email, project.Name, member.Role),
+		})
+		if err != nil {
+			log.Print(err)
+		}
+
+		bytes, err := json.Marshal(member)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+	projectRouter.Put("/members/{memberID}", func(rw http.ResponseWriter, r *http.Request) {
+		_, project, ok := requireProjectPermission(rw, r, domain.PermissionManage)
+		if !ok {
+			return
+		}
+		member, ok := findProjectMember(rw, r, project)
+		if !ok {
+			return
+		}
+
+		var input = projectMemberInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil || input.Email != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+		if input.Role == nil || !input.Role.Valid() {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "role must be one of viewer, editor, admin", Field: "role"}},
+			})
+			return
+		}
+
+		member.Role = *input.Role
+		err = shareStore.Update(context.Background(), member)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(member)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	projectRouter.Delete("/members/{memberID}", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+		access := requestGetProjectAccess(r)
+		member, ok := findProjectMember(rw, r, access.Project)
+		if !ok {
+			return
+		}
+		// Members may always leave; removing anyone else takes an admin.
+		if member.UserID != user.ID && !access.Role.Allows(domain.PermissionManage) {
+			respondError(rw, http.StatusForbidden, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: fmt.Sprintf("A %s of this project can't do that", access.Role)}},
+			})
+			return
+		}
+
+		err := shareStore.Delete(context.Background(), member.ID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if member.UserID != "" {
+			err = changeUser(member.OwnerID, user.ID, func(owner *domain.User) bool {
+				return len(owner.Unassign(member.ProjectID, member.UserID)) > 0
+			})
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			wsPresence.revoke(member.ProjectID, member.UserID)
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func findProjectMember(rw http.ResponseWriter, r *http.Request, project *domain.Project) (*domain.ProjectMember, bool) {
+	member, err := shareStore.Find(context.Background(), entityid.ID(chi.URLParam(r, "memberID")))
+	if err == storage.ErrMemberNotFound || (err == nil && member.ProjectID != project.ID) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Member not found"}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+func invitationsRouter(invitationsRouter chi.Router) {
+	invitationsRouter.Use(authenticate)
+	invitationsRouter.Use(idempotent)
+
+	invitationsRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		invitations, err := shareStore.ListPending(context.Background(), user.Email)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if invitations == nil {
+			invitations = make([]*domain.ProjectMember, 0)
+		}
+
+		bytes, err := json.Marshal(invitations)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	invitationsRouter.Post("/{invitationID}/accept", func(rw http.ResponseWriter, r *http.Request) {
+		respondToInvitation(rw, r, domain.InvitationAccepted)
+	})
+	invitationsRouter.Post("/{invitationID}/decline", func(rw http.ResponseWriter, r *http.Request) {
+		respondToInvitation(rw, r, domain.InvitationDeclined)
+	})
+}
+
+func respondToInvitation(rw http.ResponseWriter, r *http.Request, status domain.InvitationStatus) {
+	user := requestGetUser(r)
+
+	invitation, err := shareStore.Find(context.Background(), entityid.ID(chi.URLParam(r, "invitationID")))
+	if err == storage.ErrMemberNotFound || (err == nil && !strings.EqualFold(invitation.Email, user.Email)) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Invitation not found"}},
+		})
+		return
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+	if invitation.Status != domain.InvitationPending {
+		respondError(rw, http.StatusConflict, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Invitation already " + string(invitation.Status)}},
+		})
+		return
+	}
+
+	now := time.Now()
+	invitation.Status = status
+	invitation.RespondedAt = &now
+	if status == domain.InvitationAccepted {
+		invitation.UserID = user.ID
+	}
+	err = shareStore.Update(context.Background(), invitation)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	bytes, err := json.Marshal(invitation)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bytes)
+}
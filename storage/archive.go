@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+// AutoArchiveUsers returns the users who have opted in to auto-archiving and
+// have top level todos that have been completed for longer than their
+// setting. The todos are archived through the user aggregate, see
+// domain.User.AutoArchive.
+func AutoArchiveUsers(ctx context.Context, db *pg.DB, now time.Time) ([]entityid.ID, error) {
+	var ids []string
+	_, err := db.QueryContext(ctx, &ids, `
+		SELECT DISTINCT u.id
+		FROM users u
+		JOIN todos t ON t.user_id = u.id
+		WHERE u.auto_archive_after_days > 0
+			AND t.completed
+			AND COALESCE(t.parent_id, '') = ''
+			AND t.archived_at IS NULL
+			AND t.deleted_at IS NULL
+			AND COALESCE(t.completed_at, t.updated_at) < ?0 - make_interval(days => u.auto_archive_after_days::int)
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]entityid.ID, len(ids))
+	for i, id := range ids {
+		userIDs[i] = entityid.ID(id)
+	}
+	return userIDs, nil
+}
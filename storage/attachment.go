@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// ErrAttachmentQuotaExceeded is returned by Create when the attachment would
+// take its user over their quota.
+var ErrAttachmentQuotaExceeded = errors.New("attachment quota exceeded")
+
+type todoAttachment struct {
+	tableName struct{} `pg:"todo_attachments"`
+
+	ID          string    `pg:"id"`
+	TodoID      string    `pg:"todo_id"`
+	UserID      string    `pg:"user_id"`
+	UploaderID  string    `pg:"uploader_id"`
+	Filename    string    `pg:"filename"`
+	ContentType string    `pg:"content_type"`
+	Size        int64     `pg:"size,use_zero"`
+	Key         string    `pg:"key"`
+	CreatedAt   time.Time `pg:"created_at"`
+	// OrphanedAt is set by a trigger when the todo is deleted, leaving the
+	// blob for CleanupAttachments to remove.
+	OrphanedAt *time.Time `pg:"orphaned_at"`
+}
+
+func (a *todoAttachment) toEntity() *domain.Attachment {
+	return &domain.Attachment{
+		ID:          entityid.ID(a.ID),
+		TodoID:      entityid.ID(a.TodoID),
+		UserID:      entityid.ID(a.UserID),
+		UploaderID:  entityid.ID(a.UploaderID),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Key:         a.Key,
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+func toAttachments(rows []*todoAttachment) []*domain.Attachment {
+	attachments := make([]*domain.Attachment, 0, len(rows))
+	for _, row := range rows {
+		attachments = append(attachments, row.toEntity())
+	}
+	return attachments
+}
+
+// AttachmentStore keeps the metadata of todo attachments. The files
+// themselves are kept in a blob store.
+type AttachmentStore struct {
+	DB *pg.DB
+}
+
+// Create inserts the attachment unless it would take the user's attachments
+// over quota bytes. The user's attachments are locked while they are summed,
+// so concurrent uploads can't each fit in what remains.
+func (as *AttachmentStore) Create(ctx context.Context, a *domain.Attachment, quota int64) error {
+	return as.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext(?))`, "attachments:"+a.UserID.String())
+		if err != nil {
+			return err
+		}
+		var used int64
+		_, err = tx.QueryOneContext(ctx, pg.Scan(&used), `
+			SELECT coalesce(sum(size), 0) FROM todo_attachments WHERE user_id = ?`, a.UserID.String())
+		if err != nil {
+			return err
+		}
+		if used+a.Size > quota {
+			return ErrAttachmentQuotaExceeded
+		}
+
+		_, err = tx.ModelContext(ctx, &todoAttachment{
+			ID:          a.ID.String(),
+			TodoID:      a.TodoID.String(),
+			UserID:      a.UserID.String(),
+			UploaderID:  a.UploaderID.String(),
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Key:         a.Key,
+			CreatedAt:   a.CreatedAt,
+		}).Insert()
+		return err
+	})
+}
+
+func (as *AttachmentStore) Delete(ctx context.Context, id entityid.ID) error {
+	_, err := as.DB.ExecContext(ctx, `DELETE FROM todo_attachments WHERE id = ?`, id.String())
+	return err
+}
+
+// Find finds an attachment whose todo still exists.
+func (as *AttachmentStore) Find(ctx context.Context, id entityid.ID) (*domain.Attachment, error) {
+	row := &todoAttachment{}
+	err := as.DB.ModelContext(ctx, row).Where("id = ? AND orphaned_at IS NULL", id.String()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+func (as *AttachmentStore) List(ctx context.Context, todoID entityid.ID) ([]*domain.Attachment, error) {
+	var rows []*todoAttachment
+	err := as.DB.ModelContext(ctx, &rows).
+		Where("todo_id = ? AND orphaned_at IS NULL", todoID.String()).
+		Order("created_at ASC").
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return toAttachments(rows), nil
+}
+
+// UsedBytes returns the size of every attachment counting against the
+// user's quota, including ones waiting to be cleaned up. Create enforces the
+// quota; this is only a quick check before an upload is stored.
+func (as *AttachmentStore) UsedBytes(ctx context.Context, userID entityid.ID) (int64, error) {
+	var used int64
+	_, err := as.DB.QueryOneContext(ctx, pg.Scan(&used), `
+		SELECT coalesce(sum(size), 0) FROM todo_attachments WHERE user_id = ?`, userID.String())
+	return used, err
+}
+
+// Orphaned returns up to limit attachments whose todo has been deleted.
+func (as *AttachmentStore) Orphaned(ctx context.Context, limit int) ([]*domain.Attachment, error) {
+	var rows []*todoAttachment
+	err := as.DB.ModelContext(ctx, &rows).
+		Where("orphaned_at IS NOT NULL").
+		Order("orphaned_at ASC").
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return toAttachments(rows), nil
+}
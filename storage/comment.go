@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+var ErrCommentNotFound = errors.New("comment not found")
+
+type todoComment struct {
+	tableName struct{} `pg:"todo_comments"`
+
+	ID        string    `pg:"id"`
+	TodoID    string    `pg:"todo_id"`
+	AuthorID  string    `pg:"author_id"`
+	Body      string    `pg:"body"`
+	CreatedAt time.Time `pg:"created_at"`
+	UpdatedAt time.Time `pg:"updated_at"`
+}
+
+func newTodoComment(c *domain.Comment) *todoComment {
+	return &todoComment{
+		ID:        c.ID.String(),
+		TodoID:    c.TodoID.String(),
+		AuthorID:  c.AuthorID.String(),
+		Body:      c.Body,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+func (c *todoComment) toEntity() *domain.Comment {
+	return &domain.Comment{
+		ID:        entityid.ID(c.ID),
+		TodoID:    entityid.ID(c.TodoID),
+		AuthorID:  entityid.ID(c.AuthorID),
+		Body:      c.Body,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+// CommentStore keeps the comments on todos. Comments live outside the user
+// aggregate and are removed by a trigger when their todo is deleted.
+type CommentStore struct {
+	DB *pg.DB
+}
+
+func (cs *CommentStore) Create(ctx context.Context, comment *domain.Comment) error {
+	_, err := cs.DB.ModelContext(ctx, newTodoComment(comment)).Insert()
+	return err
+}
+
+func (cs *CommentStore) Update(ctx context.Context, comment *domain.Comment) error {
+	_, err := cs.DB.ModelContext(ctx, newTodoComment(comment)).WherePK().Update()
+	return err
+}
+
+func (cs *CommentStore) Delete(ctx context.Context, id entityid.ID) error {
+	_, err := cs.DB.ExecContext(ctx, `DELETE FROM todo_comments WHERE id = ?`, id.String())
+	return err
+}
+
+func (cs *CommentStore) Find(ctx context.Context, id entityid.ID) (*domain.Comment, error) {
+	row := &todoComment{}
+	err := cs.DB.ModelContext(ctx, row).Where("id = ?", id.String()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+// List returns up to limit of the todo's comments, oldest first, starting
+// after the given comment when after is set.
+func (cs *CommentStore) List(ctx context.Context, todoID entityid.ID, after *domain.Comment, limit int) ([]*domain.Comment, error) {
+	var rows []*todoComment
+	query := cs.DB.ModelContext(ctx, &rows).Where("todo_id = ?", todoID.String())
+	if after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID.String())
+	}
+	err := query.Order("created_at ASC", "id ASC").Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	comments := make([]*domain.Comment, 0, len(rows))
+	for _, row := range rows {
+		comments = append(comments, row.toEntity())
+	}
+	return comments, nil
+}
+
+// Counts returns how many comments each of the todos has. Todos without
+// comments are left out.
+func (cs *CommentStore) Counts(ctx context.Context, todoIDs []entityid.ID) (map[entityid.ID]int, error) {
+	counts := make(map[entityid.ID]int)
+	if len(todoIDs) == 0 {
+		return counts, nil
+	}
+	ids := make([]string, 0, len(todoIDs))
+	for _, id := range todoIDs {
+		ids = append(ids, id.String())
+	}
+
+	var rows []struct {
+		TodoID string
+		Count  int
+	}
+	_, err := cs.DB.QueryContext(ctx, &rows, `
+		SELECT todo_id, count(*) AS count FROM todo_comments
+		WHERE todo_id IN (?)
+		GROUP BY todo_id`, pg.In(ids))
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[entityid.ID(row.TodoID)] = row.Count
+	}
+	return counts, nil
+}
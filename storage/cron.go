@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/DillonStreator/todos/cron"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+type cronRun struct {
+	tableName struct{} `pg:"cron_runs"`
+
+	ID          string     `pg:"id"`
+	Task        string     `pg:"task"`
+	Trigger     string     `pg:"trigger"`
+	ScheduledAt time.Time  `pg:"scheduled_at"`
+	StartedAt   time.Time  `pg:"started_at"`
+	FinishedAt  *time.Time `pg:"finished_at"`
+	DurationMs  int64      `pg:"duration_ms,use_zero"`
+	Error       string     `pg:"error"`
+}
+
+func newCronRun(r *cron.Run) *cronRun {
+	return &cronRun{
+		ID:          r.ID.String(),
+		Task:        r.Task,
+		Trigger:     string(r.Trigger),
+		ScheduledAt: r.ScheduledAt,
+		StartedAt:   r.StartedAt,
+		FinishedAt:  r.FinishedAt,
+		DurationMs:  r.DurationMs,
+		Error:       r.Error,
+	}
+}
+
+func (r *cronRun) toEntity() *cron.Run {
+	return &cron.Run{
+		ID:          entityid.ID(r.ID),
+		Task:        r.Task,
+		Trigger:     cron.Trigger(r.Trigger),
+		ScheduledAt: r.ScheduledAt,
+		StartedAt:   r.StartedAt,
+		FinishedAt:  r.FinishedAt,
+		DurationMs:  r.DurationMs,
+		Error:       r.Error,
+	}
+}
+
+// CronStore elects the replica that runs each scheduled task with Postgres
+// advisory locks and keeps the history of runs.
+type CronStore struct {
+	DB *pg.DB
+}
+
+var _ cron.Store = (*CronStore)(nil)
+
+// Exclusive takes a transaction level advisory lock on the task for as long
+// as fn runs. The lock is released when the transaction ends, including when
+// the replica holding it dies and its connection drops.
+func (cs *CronStore) Exclusive(ctx context.Context, task string, fn func(ctx context.Context) error) (bool, error) {
+	var locked bool
+	var fnErr error
+	err := cs.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.QueryOneContext(ctx, pg.Scan(&locked), `SELECT pg_try_advisory_xact_lock(hashtext(?))`, "cron:"+task)
+		if err != nil || !locked {
+			return err
+		}
+		// The task does its work on other connections; the transaction
+		// only holds the lock.
+		fnErr = fn(ctx)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return locked, fnErr
+}
+
+// StartRun inserts the run. The unique index on scheduled runs' task and
+// time skips a slot another replica already ran.
+func (cs *CronStore) StartRun(ctx context.Context, run *cron.Run) (bool, error) {
+	result, err := cs.DB.ModelContext(ctx, newCronRun(run)).
+		OnConflict("(task, scheduled_at) WHERE trigger = 'schedule' DO NOTHING").
+		Insert()
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (cs *CronStore) FinishRun(ctx context.Context, run *cron.Run) error {
+	_, err := cs.DB.ModelContext(ctx, newCronRun(run)).WherePK().Update()
+	return err
+}
+
+// ListRuns returns the task's most recent runs, newest first.
+func (cs *CronStore) ListRuns(ctx context.Context, task string, limit int) ([]*cron.Run, error) {
+	var rows []*cronRun
+	err := cs.DB.ModelContext(ctx, &rows).
+		Where("task = ?", task).
+		Order("started_at DESC", "id DESC").
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*cron.Run, 0, len(rows))
+	for _, row := range rows {
+		runs = append(runs, row.toEntity())
+	}
+	return runs, nil
+}
+
+// LastRuns returns the most recent run of every task that has run, keyed by
+// task name.
+func (cs *CronStore) LastRuns(ctx context.Context) (map[string]*cron.Run, error) {
+	var rows []*cronRun
+	_, err := cs.DB.QueryContext(ctx, &rows, `
+		SELECT DISTINCT ON (task) * FROM cron_runs
+		ORDER BY task, started_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make(map[string]*cron.Run, len(rows))
+	for _, row := range rows {
+		runs[row.Task] = row.toEntity()
+	}
+	return runs, nil
+}
+
+// PurgeCronRuns deletes runs that started before the given time. It returns
+// how many were removed.
+func PurgeCronRuns(ctx context.Context, db *pg.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM cron_runs WHERE started_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/events"
+	"github.com/go-pg/pg/v10"
+)
+
+const eventsChannel = "todo_events"
+
+// maxNotifyPayload stays under Postgres's 8000 byte NOTIFY payload limit.
+const maxNotifyPayload = 7900
+
+// PostgresBroker publishes events with NOTIFY and delivers the events every
+// replica publishes to its local subscribers with LISTEN, so clients receive
+// changes made through any replica. Run must be running for events to arrive.
+type PostgresBroker struct {
+	DB    *pg.DB
+	Local *events.MemoryBroker
+}
+
+var _ events.Broker = (*PostgresBroker)(nil)
+
+func (pb *PostgresBroker) Publish(ctx context.Context, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		// Clients fetch the todo themselves when an event has no data.
+		event.Data = nil
+		payload, err = json.Marshal(event)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = pb.DB.ExecContext(ctx, `SELECT pg_notify(?, ?)`, eventsChannel, string(payload))
+	return err
+}
+
+func (pb *PostgresBroker) Subscribe(userID entityid.ID, lastEventID string) *events.Subscription {
+	return pb.Local.Subscribe(userID, lastEventID)
+}
+
+// Run listens for events until ctx is cancelled.
+func (pb *PostgresBroker) Run(ctx context.Context) {
+	listener := pb.DB.Listen(ctx, eventsChannel)
+	defer listener.Close()
+
+	notifications := listener.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-notifications:
+			var event events.Event
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				log.Print(err)
+				continue
+			}
+			pb.Local.Publish(ctx, event)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+type todoHistory struct {
+	tableName struct{} `pg:"todo_history"`
+
+	ID        string               `pg:"id"`
+	UserID    string               `pg:"user_id"`
+	TodoID    string               `pg:"todo_id"`
+	ActorID   string               `pg:"actor_id"`
+	Action    string               `pg:"action"`
+	Version   int                  `pg:"version,use_zero"`
+	Changes   []domain.FieldChange `pg:"changes,type:jsonb"`
+	CreatedAt time.Time            `pg:"created_at"`
+}
+
+func (h *todoHistory) toEntity() *domain.HistoryEntry {
+	return &domain.HistoryEntry{
+		ID:        entityid.ID(h.ID),
+		TodoID:    entityid.ID(h.TodoID),
+		ActorID:   entityid.ID(h.ActorID),
+		Action:    domain.HistoryAction(h.Action),
+		Version:   h.Version,
+		Changes:   h.Changes,
+		CreatedAt: h.CreatedAt,
+	}
+}
+
+// HistoryStore reads the todo history table. History lives outside the user
+// aggregate so saving a user never rewrites it; entries recorded on the user
+// are appended by the write_todo_history trigger as the user is saved.
+type HistoryStore struct {
+	DB *pg.DB
+}
+
+func newPendingHistory(entries []*domain.HistoryEntry) (json.RawMessage, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(entries)
+}
+
+// List returns the todo's history, oldest first.
+func (hs *HistoryStore) List(ctx context.Context, todoID entityid.ID) ([]*domain.HistoryEntry, error) {
+	var rows []*todoHistory
+	err := hs.DB.ModelContext(ctx, &rows).
+		Where("todo_id = ?", todoID.String()).
+		Order("version ASC").
+		Select()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*domain.HistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, row.toEntity())
+	}
+	return entries, nil
+}
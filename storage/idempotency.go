@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+// ErrIdempotencyClaimLost is returned when extending, completing or releasing
+// a claim on a key that ran out and was claimed again by another request.
+var ErrIdempotencyClaimLost = errors.New("idempotency key was claimed again by another request")
+
+type idempotencyKey struct {
+	tableName struct{} `pg:"idempotency_keys"`
+
+	ID             string            `pg:"id,pk"`
+	Fingerprint    string            `pg:"fingerprint"`
+	ResponseStatus int               `pg:"response_status,use_zero"`
+	ResponseHeader map[string]string `pg:"response_header,type:jsonb"`
+	ResponseBody   []byte            `pg:"response_body"`
+	CreatedAt      time.Time         `pg:"created_at"`
+	ClaimedUntil   *time.Time        `pg:"claimed_until"`
+	Claim          string            `pg:"claim"`
+	CompletedAt    *time.Time        `pg:"completed_at"`
+}
+
+// IdempotentRequest is a request made with an idempotency key. Until the first
+// request with the key completes it has no response.
+type IdempotentRequest struct {
+	Fingerprint    string
+	Completed      bool
+	ResponseStatus int
+	ResponseHeader map[string]string
+	ResponseBody   []byte
+}
+
+// IdempotencyStore remembers the responses to requests made with an
+// idempotency key so retries can be answered without repeating them.
+type IdempotencyStore struct {
+	DB *pg.DB
+	// TTL is how long a key and its response are kept.
+	TTL time.Duration
+	// PendingTimeout is how long a claim on a key lasts unless extended. The
+	// request holding it extends it while it runs, so it only runs out when
+	// the request never completes, for example because the server stopped.
+	PendingTimeout time.Duration
+}
+
+// Claim records that a request with the key and fingerprint is in progress and
+// returns the claim the request passes to Extend, Complete and Release. If the
+// key is already claimed it returns the earlier request and an empty claim
+// instead.
+func (is *IdempotencyStore) Claim(ctx context.Context, key, fingerprint string, now time.Time) (*IdempotentRequest, string, error) {
+	// Keys claimed before claims had an expiry expire PendingTimeout after
+	// they were created.
+	_, err := is.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE id = ? AND (created_at < ? OR (completed_at IS NULL AND COALESCE(claimed_until, created_at + ?::interval) < ?))
+	`, key, now.Add(-is.TTL), is.PendingTimeout.String(), now)
+	if err != nil {
+		return nil, "", err
+	}
+
+	claim := entityid.Generator.Generate().String()
+	claimedUntil := now.Add(is.PendingTimeout)
+	result, err := is.DB.ModelContext(ctx, &idempotencyKey{
+		ID:           key,
+		Fingerprint:  fingerprint,
+		CreatedAt:    now,
+		ClaimedUntil: &claimedUntil,
+		Claim:        claim,
+	}).OnConflict("DO NOTHING").Insert()
+	if err != nil {
+		return nil, "", err
+	}
+	if result.RowsAffected() == 1 {
+		return nil, claim, nil
+	}
+
+	row := &idempotencyKey{ID: key}
+	err = is.DB.ModelContext(ctx, row).WherePK().Select()
+	if err == pg.ErrNoRows {
+		// The earlier request was released between the insert and the select.
+		return is.Claim(ctx, key, fingerprint, now)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &IdempotentRequest{
+		Fingerprint:    row.Fingerprint,
+		Completed:      row.CompletedAt != nil,
+		ResponseStatus: row.ResponseStatus,
+		ResponseHeader: row.ResponseHeader,
+		ResponseBody:   row.ResponseBody,
+	}, "", nil
+}
+
+// Extend keeps the claim on a key by a request that is still in progress
+// from running out.
+func (is *IdempotencyStore) Extend(ctx context.Context, key, claim string, now time.Time) error {
+	result, err := is.DB.ExecContext(ctx, `
+		UPDATE idempotency_keys SET claimed_until = ?
+		WHERE id = ? AND claim = ? AND completed_at IS NULL
+	`, now.Add(is.PendingTimeout), key, claim)
+	return claimResult(result, err)
+}
+
+// Complete stores the response to the request that claimed the key. The claim
+// fences off a request whose claim ran out from overwriting the key claimed
+// again by a retry.
+func (is *IdempotencyStore) Complete(ctx context.Context, key, claim string, status int, header map[string]string, body []byte, now time.Time) error {
+	result, err := is.DB.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET response_status = ?, response_header = ?, response_body = ?, completed_at = ?
+		WHERE id = ? AND claim = ? AND completed_at IS NULL
+	`, status, header, body, now, key, claim)
+	return claimResult(result, err)
+}
+
+// Release forgets a claimed key whose request failed, so it can be retried.
+func (is *IdempotencyStore) Release(ctx context.Context, key, claim string) error {
+	result, err := is.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE id = ? AND claim = ? AND completed_at IS NULL
+	`, key, claim)
+	return claimResult(result, err)
+}
+
+// claimResult returns ErrIdempotencyClaimLost when a statement fenced by a
+// claim matched no key.
+func claimResult(result pg.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes keys created before the given time. It returns
+// how many keys were removed.
+func PurgeIdempotencyKeys(ctx context.Context, db *pg.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
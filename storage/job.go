@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/jobs"
+	"github.com/go-pg/pg/v10"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobQueued is returned when retrying a job while another job of the same
+// type and unique key is pending or running.
+var ErrJobQueued = errors.New("a job with the same unique key is queued")
+
+// ErrJobLeaseLost is returned when recording a job that another worker
+// claimed again after the lease ran out.
+var ErrJobLeaseLost = errors.New("job was claimed again by another worker")
+
+type job struct {
+	tableName struct{} `pg:"jobs"`
+
+	ID          string          `pg:"id"`
+	Type        string          `pg:"type"`
+	Payload     json.RawMessage `pg:"payload,type:jsonb"`
+	UniqueKey   string          `pg:"unique_key"`
+	Status      string          `pg:"status"`
+	Attempts    int             `pg:"attempts,use_zero"`
+	MaxAttempts int             `pg:"max_attempts,use_zero"`
+	RunAt       time.Time       `pg:"run_at"`
+	LockedUntil *time.Time      `pg:"locked_until"`
+	LastError   string          `pg:"last_error"`
+	CreatedAt   time.Time       `pg:"created_at"`
+	StartedAt   *time.Time      `pg:"started_at"`
+	FinishedAt  *time.Time      `pg:"finished_at"`
+}
+
+func newJob(j *jobs.Job) *job {
+	return &job{
+		ID:          j.ID.String(),
+		Type:        j.Type,
+		Payload:     j.Payload,
+		UniqueKey:   j.UniqueKey,
+		Status:      string(j.Status),
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		RunAt:       j.RunAt,
+		LastError:   j.LastError,
+		CreatedAt:   j.CreatedAt,
+		StartedAt:   j.StartedAt,
+		FinishedAt:  j.FinishedAt,
+	}
+}
+
+func (j *job) toEntity() *jobs.Job {
+	return &jobs.Job{
+		ID:          entityid.ID(j.ID),
+		Type:        j.Type,
+		Payload:     j.Payload,
+		UniqueKey:   j.UniqueKey,
+		Status:      jobs.Status(j.Status),
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		RunAt:       j.RunAt,
+		LastError:   j.LastError,
+		CreatedAt:   j.CreatedAt,
+		StartedAt:   j.StartedAt,
+		FinishedAt:  j.FinishedAt,
+	}
+}
+
+// JobStore keeps the job queue.
+type JobStore struct {
+	DB *pg.DB
+	// Lease is how long a claimed job is hidden from other workers. A worker
+	// that dies mid-job leaves it to be claimed again once the lease runs
+	// out.
+	Lease time.Duration
+}
+
+var _ jobs.Store = (*JobStore)(nil)
+
+// Enqueue inserts the job unless its unique key is taken. The check is race
+// free thanks to the unique index on the keys of pending and running jobs.
+func (js *JobStore) Enqueue(ctx context.Context, j *jobs.Job) (bool, error) {
+	if j.MaxAttempts == 0 {
+		j.MaxAttempts = jobs.DefaultMaxAttempts
+	}
+	result, err := js.DB.ModelContext(ctx, newJob(j)).
+		OnConflict("(type, unique_key) WHERE unique_key IS NOT NULL AND status IN ('pending', 'running') DO NOTHING").
+		Insert()
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (js *JobStore) Claim(ctx context.Context, now time.Time, types []string, limit int) ([]*jobs.Job, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	var rows []*job
+	_, err := js.DB.QueryContext(ctx, &rows, `
+		UPDATE jobs SET status = ?, attempts = attempts + 1, started_at = ?, locked_until = ?
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE type IN (?) AND (
+				(status = ? AND run_at <= ?) OR
+				(status = ? AND locked_until <= ?)
+			)
+			ORDER BY run_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`, jobs.StatusRunning, now, now.Add(js.Lease),
+		pg.In(types), jobs.StatusPending, now, jobs.StatusRunning, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order jobs were selected in.
+	sort.Slice(rows, func(i, j int) bool { return rows[i].RunAt.Before(rows[j].RunAt) })
+	claimed := make([]*jobs.Job, 0, len(rows))
+	for _, row := range rows {
+		claimed = append(claimed, row.toEntity())
+	}
+	return claimed, nil
+}
+
+// Record saves the outcome of running a job and releases its lease. Claiming
+// counts an attempt, so the attempt the job ran as fences off a worker whose
+// lease ran out from overwriting the outcome of the one that claimed the job
+// after it.
+func (js *JobStore) Record(ctx context.Context, j *jobs.Job) error {
+	result, err := js.DB.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = ?, run_at = ?, last_error = ?, finished_at = ?, locked_until = NULL
+		WHERE id = ? AND status = ? AND attempts = ?`,
+		j.Status, j.RunAt, j.LastError, j.FinishedAt, j.ID.String(), jobs.StatusRunning, j.Attempts)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+func (js *JobStore) Find(ctx context.Context, id entityid.ID) (*jobs.Job, error) {
+	row := &job{}
+	err := js.DB.ModelContext(ctx, row).Where("id = ?", id.String()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+// JobFilter narrows the jobs listed. Empty fields match every job.
+type JobFilter struct {
+	Type   string
+	Status jobs.Status
+}
+
+// List returns jobs matching the filter, newest first, starting after the
+// given job if any.
+func (js *JobStore) List(ctx context.Context, filter JobFilter, after *jobs.Job, limit int) ([]*jobs.Job, error) {
+	var rows []*job
+	query := js.DB.ModelContext(ctx, &rows)
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID.String())
+	}
+	err := query.Order("created_at DESC", "id DESC").Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*jobs.Job, 0, len(rows))
+	for _, row := range rows {
+		list = append(list, row.toEntity())
+	}
+	return list, nil
+}
+
+// JobCount is how many jobs of a type are in a status.
+type JobCount struct {
+	Type   string      `json:"type"`
+	Status jobs.Status `json:"status"`
+	Count  int         `json:"count"`
+}
+
+func (js *JobStore) Counts(ctx context.Context) ([]JobCount, error) {
+	var counts []JobCount
+	_, err := js.DB.QueryContext(ctx, &counts, `
+		SELECT type, status, count(*) AS count FROM jobs
+		GROUP BY type, status
+		ORDER BY type, status`)
+	return counts, err
+}
+
+// Retry makes a dead or pending job run again now with a fresh set of
+// attempts. It returns ErrJobNotFound if there is no such job in either
+// state; running jobs finish first, and succeeded ones are done. It returns
+// ErrJobQueued if a job with the same unique key was queued since the job
+// died.
+func (js *JobStore) Retry(ctx context.Context, id entityid.ID, now time.Time) (*jobs.Job, error) {
+	row := &job{}
+	_, err := js.DB.QueryOneContext(ctx, row, `
+		UPDATE jobs SET status = ?, attempts = 0, run_at = ?, finished_at = NULL
+		WHERE id = ? AND status IN (?, ?)
+		RETURNING *`,
+		jobs.StatusPending, now, id.String(), jobs.StatusDead, jobs.StatusPending)
+	if err == pg.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation && pgErr.Field('n') == "jobs_type_unique_key_idx" {
+		return nil, ErrJobQueued
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+// PurgeJobs deletes succeeded and dead jobs that finished before the given
+// time. It returns how many were removed.
+func PurgeJobs(ctx context.Context, db *pg.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM jobs
+		WHERE status IN (?, ?) AND finished_at < ?`, jobs.StatusSucceeded, jobs.StatusDead, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
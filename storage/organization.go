@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+var ErrOrgNotFound = errors.New("organization not found")
+var ErrOrgMemberNotFound = errors.New("organization member not found")
+
+type organization struct {
+	tableName struct{} `pg:"organizations"`
+
+	ID        string    `pg:"id"`
+	Name      string    `pg:"name"`
+	Personal  bool      `pg:"personal,use_zero"`
+	CreatedAt time.Time `pg:"created_at"`
+}
+
+func newOrganization(o *domain.Organization) *organization {
+	return &organization{
+		ID:        o.ID.String(),
+		Name:      o.Name,
+		Personal:  o.Personal,
+		CreatedAt: o.CreatedAt,
+	}
+}
+
+func (o *organization) toEntity() *domain.Organization {
+	return &domain.Organization{
+		ID:        entityid.ID(o.ID),
+		Name:      o.Name,
+		Personal:  o.Personal,
+		CreatedAt: o.CreatedAt,
+	}
+}
+
+type orgMember struct {
+	tableName struct{} `pg:"org_members"`
+
+	ID          string     `pg:"id"`
+	OrgID       string     `pg:"org_id"`
+	UserID      string     `pg:"user_id"`
+	Email       string     `pg:"email"`
+	Role        string     `pg:"role"`
+	Status      string     `pg:"status"`
+	InvitedBy   string     `pg:"invited_by"`
+	CreatedAt   time.Time  `pg:"created_at"`
+	RespondedAt *time.Time `pg:"responded_at"`
+}
+
+func newOrgMember(m *domain.OrgMember) *orgMember {
+	return &orgMember{
+		ID:          m.ID.String(),
+		OrgID:       m.OrgID.String(),
+		UserID:      m.UserID.String(),
+		Email:       m.Email,
+		Role:        string(m.Role),
+		Status:      string(m.Status),
+		InvitedBy:   m.InvitedBy.String(),
+		CreatedAt:   m.CreatedAt,
+		RespondedAt: m.RespondedAt,
+	}
+}
+
+func (m *orgMember) toEntity() *domain.OrgMember {
+	return &domain.OrgMember{
+		ID:          entityid.ID(m.ID),
+		OrgID:       entityid.ID(m.OrgID),
+		UserID:      entityid.ID(m.UserID),
+		Email:       m.Email,
+		Role:        domain.OrgRole(m.Role),
+		Status:      domain.InvitationStatus(m.Status),
+		InvitedBy:   entityid.ID(m.InvitedBy),
+		CreatedAt:   m.CreatedAt,
+		RespondedAt: m.RespondedAt,
+	}
+}
+
+// OrgStore keeps organizations and their members. Like project memberships,
+// organization memberships are read on every request so removing a member
+// takes effect immediately.
+type OrgStore struct {
+	DB *pg.DB
+}
+
+// Create creates the organization with the given member as its owner.
+func (os *OrgStore) Create(ctx context.Context, org *domain.Organization, owner *domain.OrgMember) error {
+	return os.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ModelContext(ctx, newOrganization(org)).Insert()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ModelContext(ctx, newOrgMember(owner)).Insert()
+		return err
+	})
+}
+
+// EnsurePersonal creates the user's personal organization, which shares their
+// ID, if it doesn't exist yet.
+func (os *OrgStore) EnsurePersonal(ctx context.Context, user *domain.User) error {
+	now := time.Now()
+	return os.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ModelContext(ctx, newOrganization(&domain.Organization{
+			ID:        user.ID,
+			Name:      "Personal",
+			Personal:  true,
+			CreatedAt: now,
+		})).OnConflict("DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ModelContext(ctx, newOrgMember(&domain.OrgMember{
+			ID:          user.ID,
+			OrgID:       user.ID,
+			UserID:      user.ID,
+			Email:       user.Email,
+			Role:        domain.OrgRoleOwner,
+			Status:      domain.InvitationAccepted,
+			InvitedBy:   user.ID,
+			CreatedAt:   now,
+			RespondedAt: &now,
+		})).OnConflict("DO NOTHING").Insert()
+		return err
+	})
+}
+
+func (os *OrgStore) Update(ctx context.Context, org *domain.Organization) error {
+	_, err := os.DB.ModelContext(ctx, newOrganization(org)).WherePK().Update()
+	return err
+}
+
+func (os *OrgStore) Find(ctx context.Context, id entityid.ID) (*domain.Organization, error) {
+	row := &organization{}
+	err := os.DB.ModelContext(ctx, row).Where("id = ?", id.String()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrOrgNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+// List returns the organizations with the given IDs.
+func (os *OrgStore) List(ctx context.Context, ids []entityid.ID) ([]*domain.Organization, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id.String())
+	}
+
+	var rows []*organization
+	err := os.DB.ModelContext(ctx, &rows).Where("id IN (?)", pg.In(values)).Order("created_at ASC").Select()
+	if err != nil {
+		return nil, err
+	}
+	orgs := make([]*domain.Organization, 0, len(rows))
+	for _, row := range rows {
+		orgs = append(orgs, row.toEntity())
+	}
+	return orgs, nil
+}
+
+func (os *OrgStore) CreateMember(ctx context.Context, member *domain.OrgMember) error {
+	_, err := os.DB.ModelContext(ctx, newOrgMember(member)).Insert()
+	return err
+}
+
+func (os *OrgStore) UpdateMember(ctx context.Context, member *domain.OrgMember) error {
+	_, err := os.DB.ModelContext(ctx, newOrgMember(member)).WherePK().Update()
+	return err
+}
+
+func (os *OrgStore) DeleteMember(ctx context.Context, id entityid.ID) error {
+	_, err := os.DB.ExecContext(ctx, `DELETE FROM org_members WHERE id = ?`, id.String())
+	return err
+}
+
+func (os *OrgStore) findMember(ctx context.Context, where string, params ...interface{}) (*domain.OrgMember, error) {
+	row := &orgMember{}
+	err := os.DB.ModelContext(ctx, row).Where(where, params...).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrOrgMemberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+func (os *OrgStore) listMembers(ctx context.Context, where string, params ...interface{}) ([]*domain.OrgMember, error) {
+	var rows []*orgMember
+	err := os.DB.ModelContext(ctx, &rows).Where(where, params...).Order("created_at ASC").Select()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*domain.OrgMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, row.toEntity())
+	}
+	return members, nil
+}
+
+func (os *OrgStore) FindMember(ctx context.Context, id entityid.ID) (*domain.OrgMember, error) {
+	return os.findMember(ctx, "id = ?", id.String())
+}
+
+// FindMemberByEmail finds the invitation to the organization for an email
+// address, whatever its status.
+func (os *OrgStore) FindMemberByEmail(ctx context.Context, orgID entityid.ID, email string) (*domain.OrgMember, error) {
+	return os.findMember(ctx, "org_id = ? AND lower(email) = lower(?)", orgID.String(), email)
+}
+
+// Membership finds the user's accepted membership of the organization.
+func (os *OrgStore) Membership(ctx context.Context, orgID, userID entityid.ID) (*domain.OrgMember, error) {
+	return os.findMember(ctx, "org_id = ? AND user_id = ? AND status = ?", orgID.String(), userID.String(), domain.InvitationAccepted)
+}
+
+// ListMembers lists the organization's members and outstanding invitations.
+func (os *OrgStore) ListMembers(ctx context.Context, orgID entityid.ID) ([]*domain.OrgMember, error) {
+	return os.listMembers(ctx, "org_id = ? AND status != ?", orgID.String(), domain.InvitationDeclined)
+}
+
+// ListPending lists the organization invitations waiting on an email address.
+func (os *OrgStore) ListPending(ctx context.Context, email string) ([]*domain.OrgMember, error) {
+	return os.listMembers(ctx, "lower(email) = lower(?) AND status = ?", email, domain.InvitationPending)
+}
+
+// ListAccepted lists the user's memberships of organizations.
+func (os *OrgStore) ListAccepted(ctx context.Context, userID entityid.ID) ([]*domain.OrgMember, error) {
+	return os.listMembers(ctx, "user_id = ? AND status = ?", userID.String(), domain.InvitationAccepted)
+}
+
+// CountOwners returns how many owners the organization has.
+func (os *OrgStore) CountOwners(ctx context.Context, orgID entityid.ID) (int, error) {
+	return os.DB.ModelContext(ctx, (*orgMember)(nil)).
+		Where("org_id = ? AND role = ? AND status = ?", orgID.String(), domain.OrgRoleOwner, domain.InvitationAccepted).
+		Count()
+}
+
+// InOrg runs fn in a transaction that row level security limits to the
+// organization's projects, todos and change log; outside of it a connection
+// sees none of those rows, except on an AllOrgs connection.
+//
+// Requests read and write the user aggregate in InOrg for the organization
+// their token selects, see FindUserInOrg and SaveUser, as do reads that don't
+// go through the aggregate, like SyncStore's. Comments, attachments and
+// history are reached through the todos of that aggregate, and webhooks and
+// jobs belong to a user rather than an organization.
+func InOrg(ctx context.Context, db *pg.DB, orgID entityid.ID, fn func(tx *pg.Tx) error) error {
+	return db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ExecContext(ctx, `SELECT set_config('app.org_id', ?, true), set_config('app.all_orgs', 'off', true)`, orgID.String())
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// BypassesRowLevelSecurity reports whether the connecting role is a superuser
+// or has BYPASSRLS, which row level security doesn't apply to even with FORCE
+// ROW LEVEL SECURITY.
+func BypassesRowLevelSecurity(ctx context.Context, db *pg.DB) (bool, error) {
+	var bypasses bool
+	_, err := db.QueryOneContext(ctx, pg.Scan(&bypasses), `
+		SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`)
+	return bypasses, err
+}
+
+// AllOrgs returns options for a connection pool that row level security
+// doesn't limit to one organization. It is for creating the schema and for
+// work done outside of requests, which loads the user aggregate with the
+// user's data in every organization.
+func AllOrgs(opt *pg.Options) *pg.Options {
+	allOrgs := *opt
+	allOrgs.OnConnect = func(ctx context.Context, cn *pg.Conn) error {
+		_, err := cn.ExecContext(ctx, `SET app.all_orgs = 'on'`)
+		return err
+	}
+	return &allOrgs
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+// testDBOptions connects to the database in TEST_DATABASE_URL, skipping the
+// test when it isn't set. The connecting role must not be a superuser or have
+// BYPASSRLS, or row level security won't apply to it.
+func testDBOptions(t *testing.T) *pg.Options {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	options, err := pg.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
+// orgFixture is a user who belongs to organizations a and b, with a project
+// and a todo in each, and a member of both projects.
+type orgFixture struct {
+	userID, memberID   entityid.ID
+	orgA, orgB         entityid.ID
+	projectA, projectB entityid.ID
+	todoA, todoB       entityid.ID
+}
+
+func newOrgFixture(t *testing.T, db *pg.DB) *orgFixture {
+	ctx := context.Background()
+	id := func() entityid.ID { return entityid.Generator.Generate() }
+	f := &orgFixture{
+		userID: id(), memberID: id(),
+		orgA: id(), orgB: id(),
+		projectA: id(), projectB: id(),
+		todoA: id(), todoB: id(),
+	}
+
+	now := time.Now()
+	_, err := db.ModelContext(ctx, &user{ID: f.userID.String(), Email: f.userID.String() + "@example.com", CreatedAt: now, LastSeenAt: now}).Insert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct{ orgID, projectID, todoID entityid.ID }{
+		{f.orgA, f.projectA, f.todoA},
+		{f.orgB, f.projectB, f.todoB},
+	} {
+		_, err := db.ModelContext(ctx, &project{ID: p.projectID.String(), UserID: f.userID.String(), OrgID: p.orgID.String(), CreatedAt: now}).Insert()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.ModelContext(ctx, &todo{ID: p.todoID.String(), UserID: f.userID.String(), OrgID: p.orgID.String(), ProjectID: p.projectID.String(), CreatedAt: now}).Insert()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.ModelContext(ctx, newProjectMember(&domain.ProjectMember{
+			ID:        id(),
+			ProjectID: p.projectID,
+			OwnerID:   f.userID,
+			UserID:    f.memberID,
+			Role:      domain.RoleEditor,
+			Status:    domain.InvitationAccepted,
+			CreatedAt: now,
+		})).Insert()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DELETE FROM project_members WHERE owner_id = ?`, f.userID.String())
+		db.ExecContext(ctx, `DELETE FROM todos WHERE user_id = ?`, f.userID.String())
+		db.ExecContext(ctx, `DELETE FROM todo_changes WHERE user_id = ?`, f.userID.String())
+		db.ExecContext(ctx, `DELETE FROM projects WHERE user_id = ?`, f.userID.String())
+		db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, f.userID.String())
+	})
+	return f
+}
+
+func TestRowLevelSecurity(t *testing.T) {
+	options := testDBOptions(t)
+	ctx := context.Background()
+
+	allOrgs := pg.Connect(AllOrgs(options))
+	defer allOrgs.Close()
+	if err := CreateSchema(allOrgs); err != nil {
+		t.Fatal(err)
+	}
+	db := pg.Connect(options)
+	defer db.Close()
+	f := newOrgFixture(t, allOrgs)
+
+	todoIDs := func(q pg.DBI) []string {
+		var ids []string
+		_, err := q.QueryContext(ctx, &ids, `SELECT id FROM todos WHERE user_id = ? ORDER BY id`, f.userID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	t.Run("hides every row outside of an organization", func(t *testing.T) {
+		if ids := todoIDs(db); len(ids) != 0 {
+			t.Errorf("todos = %v, expected none", ids)
+		}
+	})
+	t.Run("only shows the organization's rows", func(t *testing.T) {
+		var ids []string
+		err := InOrg(ctx, db, f.orgA, func(tx *pg.Tx) error {
+			ids = todoIDs(tx)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != f.todoA.String() {
+			t.Errorf("todos = %v, expected only %s", ids, f.todoA)
+		}
+	})
+	t.Run("narrows an AllOrgs connection", func(t *testing.T) {
+		var ids []string
+		err := InOrg(ctx, allOrgs, f.orgB, func(tx *pg.Tx) error {
+			ids = todoIDs(tx)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != f.todoB.String() {
+			t.Errorf("todos = %v, expected only %s", ids, f.todoB)
+		}
+	})
+	t.Run("rejects writes into another organization", func(t *testing.T) {
+		err := InOrg(ctx, db, f.orgA, func(tx *pg.Tx) error {
+			_, err := tx.ModelContext(ctx, &todo{ID: entityid.Generator.Generate().String(), UserID: f.userID.String(), OrgID: f.orgB.String(), CreatedAt: time.Now()}).Insert()
+			return err
+		})
+		if err == nil {
+			t.Error("inserted a todo into another organization, expected an error")
+		}
+
+		var updated int
+		err = InOrg(ctx, db, f.orgA, func(tx *pg.Tx) error {
+			result, err := tx.ExecContext(ctx, `UPDATE todos SET title = 'taken' WHERE id = ?`, f.todoB.String())
+			if err == nil {
+				updated = result.RowsAffected()
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated != 0 {
+			t.Errorf("updated %d todos in another organization, expected 0", updated)
+		}
+	})
+	t.Run("SyncStore only returns the organization's changes", func(t *testing.T) {
+		syncStore := &SyncStore{DB: db}
+		changes, _, err := syncStore.Changes(ctx, f.userID, f.orgA, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 1 || changes[0].TodoID != f.todoA {
+			t.Errorf("Changes() = %v, expected only %s", changes, f.todoA)
+		}
+	})
+	t.Run("ShareStore only returns memberships in the organization", func(t *testing.T) {
+		shareStore := &ShareStore{DB: allOrgs}
+		members, err := shareStore.ListAccepted(ctx, f.memberID, f.orgA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != 1 || members[0].ProjectID != f.projectA {
+			t.Errorf("ListAccepted() = %v, expected only the membership of %s", members, f.projectA)
+		}
+		_, err = shareStore.OrgMembership(ctx, f.projectB, f.memberID, f.orgA)
+		if err != ErrMemberNotFound {
+			t.Errorf("OrgMembership() error = %v, expected %v", err, ErrMemberNotFound)
+		}
+	})
+	t.Run("FindUserInOrg only loads the organization's todos", func(t *testing.T) {
+		u, err := FindUserInOrg(ctx, db, f.userID, f.orgA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if todos := u.AllTodos(); len(todos) != 1 || todos[0].ID != f.todoA {
+			t.Errorf("AllTodos() = %v, expected only %s", todos, f.todoA)
+		}
+		if projects := u.AllProjects(); len(projects) != 1 || projects[0].ID != f.projectA {
+			t.Errorf("AllProjects() = %v, expected only %s", projects, f.projectA)
+		}
+	})
+	t.Run("SaveUser in an organization leaves the others' todos", func(t *testing.T) {
+		u, err := FindUserInOrg(ctx, db, f.userID, f.orgA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.Todos.FindByID(f.todoA).Title = "saved"
+		if err := SaveUser(ctx, nil, db, u); err != nil {
+			t.Fatal(err)
+		}
+
+		var titles []string
+		_, err = allOrgs.QueryContext(ctx, &titles, `SELECT title FROM todos WHERE user_id = ? ORDER BY id = ? DESC`, f.userID.String(), f.todoA.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(titles) != 2 || titles[0] != "saved" {
+			t.Errorf("titles = %v, expected todo %s saved and %s kept", titles, f.todoA, f.todoB)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/outbox"
+	"github.com/go-pg/pg/v10"
+)
+
+// domainEvent is an event in the outbox. Rows are only inserted by the
+// write_domain_events trigger, as the user aggregate that raised them is
+// saved.
+type domainEvent struct {
+	tableName struct{} `pg:"domain_events"`
+
+	Seq           int64           `pg:"seq,pk,type:bigserial"`
+	ID            string          `pg:"id"`
+	Type          string          `pg:"type"`
+	UserID        string          `pg:"user_id"`
+	OrgID         string          `pg:"org_id"`
+	SubjectID     string          `pg:"subject_id"`
+	Payload       json.RawMessage `pg:"payload,type:jsonb"`
+	OccurredAt    time.Time       `pg:"occurred_at"`
+	Attempts      int             `pg:"attempts,use_zero"`
+	NextAttemptAt *time.Time      `pg:"next_attempt_at"`
+	LastError     string          `pg:"last_error"`
+	DispatchedAt  *time.Time      `pg:"dispatched_at"`
+	FailedAt      *time.Time      `pg:"failed_at"`
+}
+
+func (e *domainEvent) toEntity() outbox.Event {
+	return outbox.Event{
+		Seq:           e.Seq,
+		ID:            entityid.ID(e.ID),
+		Type:          e.Type,
+		UserID:        entityid.ID(e.UserID),
+		OrgID:         entityid.ID(e.OrgID),
+		SubjectID:     entityid.ID(e.SubjectID),
+		Payload:       e.Payload,
+		OccurredAt:    e.OccurredAt,
+		Attempts:      e.Attempts,
+		NextAttemptAt: e.NextAttemptAt,
+		LastError:     e.LastError,
+		DispatchedAt:  e.DispatchedAt,
+		FailedAt:      e.FailedAt,
+	}
+}
+
+// pendingEvent is how a raised event travels to the trigger in the users
+// table's pending_events column.
+type pendingEvent struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OrgID      string          `json:"orgId"`
+	SubjectID  string          `json:"subjectId"`
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurredAt"`
+}
+
+func newPendingEvents(events []domain.Event) (json.RawMessage, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	pending := make([]pendingEvent, 0, len(events))
+	for _, e := range events {
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, pendingEvent{
+			ID:         e.ID.String(),
+			Type:       e.Type,
+			OrgID:      e.OrgID.String(),
+			SubjectID:  e.SubjectID.String(),
+			Data:       data,
+			OccurredAt: e.OccurredAt,
+		})
+	}
+	return json.Marshal(pending)
+}
+
+// OutboxStore reads the outbox of domain events for the relay.
+type OutboxStore struct {
+	DB *pg.DB
+	// Lease is how long a claimed event is hidden from other relays while
+	// its subscribers run.
+	Lease time.Duration
+}
+
+var _ outbox.Source = (*OutboxStore)(nil)
+
+func (obs *OutboxStore) Pending(ctx context.Context, now time.Time, limit int) ([]outbox.Event, error) {
+	var rows []*domainEvent
+	_, err := obs.DB.QueryContext(ctx, &rows, `
+		UPDATE domain_events SET next_attempt_at = ?
+		WHERE seq IN (
+			SELECT seq FROM domain_events
+			WHERE dispatched_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+			ORDER BY seq
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`, now.Add(obs.Lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order events were selected in.
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Seq < rows[j].Seq })
+	events := make([]outbox.Event, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, row.toEntity())
+	}
+	return events, nil
+}
+
+// Record saves the outcome of relaying an event.
+func (obs *OutboxStore) Record(ctx context.Context, event outbox.Event) error {
+	_, err := obs.DB.ExecContext(ctx, `
+		UPDATE domain_events
+		SET attempts = ?, next_attempt_at = ?, last_error = ?, dispatched_at = ?, failed_at = ?
+		WHERE seq = ?`,
+		event.Attempts, event.NextAttemptAt, event.LastError, event.DispatchedAt, event.FailedAt, event.Seq)
+	return err
+}
+
+// PurgeDomainEvents deletes dispatched and failed events that occurred before
+// the given time. It returns how many were removed.
+func PurgeDomainEvents(ctx context.Context, db *pg.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM domain_events
+		WHERE (dispatched_at IS NOT NULL OR failed_at IS NOT NULL) AND occurred_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
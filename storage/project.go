@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+type project struct {
+	ID         string     `pg:"id"`
+	UserID     string     `pg:"user_id"`
+	OrgID      string     `pg:"org_id"`
+	Name       string     `pg:"name"`
+	Inbox      bool       `pg:"inbox,use_zero"`
+	ArchivedAt *time.Time `pg:"archived_at"`
+	CreatedAt  time.Time  `pg:"created_at"`
+}
+
+func newProject(userID string, p *domain.Project) *project {
+	// Projects of users that were never scoped to an organization belong to
+	// their personal one.
+	orgID := p.OrgID.String()
+	if orgID == "" {
+		orgID = userID
+	}
+	return &project{
+		ID:         p.ID.String(),
+		UserID:     userID,
+		OrgID:      orgID,
+		Name:       p.Name,
+		Inbox:      p.Inbox,
+		ArchivedAt: p.ArchivedAt,
+		CreatedAt:  p.CreatedAt,
+	}
+}
+
+func (p *project) toEntity() *domain.Project {
+	return &domain.Project{
+		ID:         entityid.ID(p.ID),
+		OrgID:      entityid.ID(p.OrgID),
+		Name:       p.Name,
+		Inbox:      p.Inbox,
+		ArchivedAt: p.ArchivedAt,
+		CreatedAt:  p.CreatedAt,
+	}
+}
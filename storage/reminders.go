@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/reminders"
+	"github.com/go-pg/pg/v10"
+)
+
+// ReminderSource finds reminder candidates directly in Postgres so the
+// scheduler doesn't have to load every user aggregate. It only finds them;
+// delivered reminders are marked through the user aggregate.
+type ReminderSource struct {
+	DB *pg.DB
+}
+
+type reminderCandidate struct {
+	todo
+	Email    string `pg:"email"`
+	Timezone string `pg:"timezone"`
+}
+
+// FindCandidates narrows the todos down to those whose reminders could have
+// passed by now and haven't all been delivered. All-day todos are reminded
+// from the start of the due date in the owner's time zone, which can come up
+// to a day before due_at.
+func (rs *ReminderSource) FindCandidates(ctx context.Context, now time.Time) ([]reminders.Candidate, error) {
+	var rows []*reminderCandidate
+	_, err := rs.DB.QueryContext(ctx, &rows, `
+		SELECT t.*, u.email, u.timezone
+		FROM todos t
+		JOIN users u ON u.id = t.user_id
+		CROSS JOIN LATERAL (
+			SELECT
+				t.due_at - make_interval(mins => max(o)::int) - interval '1 day' AS earliest,
+				t.due_at - make_interval(mins => min(o)::int) AS latest
+			FROM unnest(t.reminder_offsets) AS offsets(o)
+		) r
+		WHERE t.due_at IS NOT NULL
+			AND t.completed IS NOT TRUE
+			AND t.deleted_at IS NULL
+			AND t.archived_at IS NULL
+			AND cardinality(t.reminder_offsets) > 0
+			AND r.earliest <= ?0
+			AND r.latest >= t.created_at
+			AND (t.reminded_at IS NULL OR t.reminded_at < r.latest)
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]reminders.Candidate, 0, len(rows))
+	for _, row := range rows {
+		candidates = append(candidates, reminders.Candidate{
+			User: &domain.User{
+				ID:       entityid.ID(row.UserID),
+				Email:    row.Email,
+				Timezone: row.Timezone,
+			},
+			Todo: row.todo.toEntity(),
+		})
+	}
+
+	return candidates, nil
+}
@@ -5,10 +5,251 @@ import (
 	"github.com/go-pg/pg/v10/orm"
 )
 
+// serializationFailure is the SQLSTATE of the errors raised by triggers that
+// reject a write made from a stale read.
+const serializationFailure = "40001"
+
+// uniqueViolation is the SQLSTATE of inserts and updates that conflict with a
+// unique index.
+const uniqueViolation = "23505"
+
+// migrations bring tables created by earlier versions up to date. CreateTable
+// only creates missing tables, so every column added to an existing model must
+// also be added here.
+var migrations = []string{
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone text`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_at timestamptz`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS all_day boolean NOT NULL DEFAULT false`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS reminder_offsets bigint[]`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS reminded_at timestamptz`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS recurrence text`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS recurrence_start timestamptz`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS priority text`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS tags_user_id_name_idx ON tags (user_id, lower(name))`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS project_id text`,
+	// Move todos created before projects existed into their owner's inbox.
+	`INSERT INTO projects (id, user_id, name, inbox, created_at)
+		SELECT md5(random()::text || u.id)::uuid::text, u.id, 'Inbox', true, now()
+		FROM users u
+		WHERE NOT EXISTS (SELECT 1 FROM projects p WHERE p.user_id = u.id AND p.inbox)`,
+	`UPDATE todos t SET project_id = p.id
+		FROM projects p
+		WHERE p.user_id = t.user_id AND p.inbox AND t.project_id IS NULL`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS parent_id text`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS position text COLLATE "C"`,
+	`ALTER TABLE todos DROP COLUMN IF EXISTS sort_order`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS deleted_at timestamptz`,
+	`CREATE INDEX IF NOT EXISTS todos_deleted_at_idx ON todos (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS completed_at timestamptz`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS archived_at timestamptz`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS auto_archive_after_days bigint NOT NULL DEFAULT 0`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS todo_history_todo_id_version_idx ON todo_history (todo_id, version)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 0`,
+	// Continue counting from the history recorded before todos had versions.
+	`UPDATE todos t SET version = h.version
+		FROM (SELECT todo_id, MAX(version) AS version FROM todo_history GROUP BY todo_id) h
+		WHERE h.todo_id = t.id AND t.version < h.version`,
+	// Log every change to a todo for delta sync, see SyncStore.
+	`CREATE INDEX IF NOT EXISTS todo_changes_user_id_seq_idx ON todo_changes (user_id, seq)`,
+	`CREATE OR REPLACE FUNCTION record_todo_change() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			INSERT INTO todo_changes (user_id, org_id, todo_id, deleted, changed_at) VALUES (OLD.user_id, OLD.org_id, OLD.id, true, now());
+			RETURN OLD;
+		END IF;
+		INSERT INTO todo_changes (user_id, org_id, todo_id, deleted, changed_at) VALUES (NEW.user_id, NEW.org_id, NEW.id, false, now());
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS todos_record_insert_delete ON todos`,
+	`CREATE TRIGGER todos_record_insert_delete AFTER INSERT OR DELETE ON todos
+		FOR EACH ROW EXECUTE PROCEDURE record_todo_change()`,
+	// Saving the user aggregate rewrites every todo, so only rows that
+	// actually changed are logged.
+	`DROP TRIGGER IF EXISTS todos_record_update ON todos`,
+	`CREATE TRIGGER todos_record_update AFTER UPDATE ON todos
+		FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE PROCEDURE record_todo_change()`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS project_members_project_id_email_idx ON project_members (project_id, lower(email))`,
+	`CREATE INDEX IF NOT EXISTS project_members_user_id_idx ON project_members (user_id)`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS assignee_id text`,
+	`CREATE INDEX IF NOT EXISTS todos_assignee_id_idx ON todos (assignee_id) WHERE assignee_id IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS todo_comments_todo_id_created_at_idx ON todo_comments (todo_id, created_at, id)`,
+	// Comments go with their todo however it's deleted: emptying the trash,
+	// purging it, or saving a user without the todo.
+	`CREATE OR REPLACE FUNCTION delete_todo_comments() RETURNS trigger AS $$
+	BEGIN
+		DELETE FROM todo_comments WHERE todo_id = OLD.id;
+		RETURN OLD;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS todos_delete_comments ON todos`,
+	`CREATE TRIGGER todos_delete_comments AFTER DELETE ON todos
+		FOR EACH ROW EXECUTE PROCEDURE delete_todo_comments()`,
+	`CREATE INDEX IF NOT EXISTS todo_attachments_todo_id_idx ON todo_attachments (todo_id)`,
+	`CREATE INDEX IF NOT EXISTS todo_attachments_user_id_idx ON todo_attachments (user_id)`,
+	// Blobs can't be deleted from inside the database, so deleting a todo
+	// only marks its attachments for cleanup.
+	`CREATE OR REPLACE FUNCTION orphan_todo_attachments() RETURNS trigger AS $$
+	BEGIN
+		UPDATE todo_attachments SET orphaned_at = now() WHERE todo_id = OLD.id AND orphaned_at IS NULL;
+		RETURN OLD;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS todos_orphan_attachments ON todos`,
+	`CREATE TRIGGER todos_orphan_attachments AFTER DELETE ON todos
+		FOR EACH ROW EXECUTE PROCEDURE orphan_todo_attachments()`,
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS org_id text`,
+	`ALTER TABLE todos ADD COLUMN IF NOT EXISTS org_id text`,
+	`ALTER TABLE todo_changes ADD COLUMN IF NOT EXISTS org_id text`,
+	// Everything created before organizations existed belongs to its owner's
+	// personal organization, which shares the owner's ID.
+	`INSERT INTO organizations (id, name, personal, created_at)
+		SELECT id, 'Personal', true, created_at FROM users
+		ON CONFLICT DO NOTHING`,
+	`INSERT INTO org_members (id, org_id, user_id, email, role, status, invited_by, created_at, responded_at)
+		SELECT id, id, id, email, 'owner', 'accepted', id, created_at, created_at FROM users
+		ON CONFLICT DO NOTHING`,
+	`UPDATE projects SET org_id = user_id WHERE org_id IS NULL`,
+	`UPDATE todos t SET org_id = p.org_id
+		FROM projects p
+		WHERE p.id = t.project_id AND t.org_id IS NULL`,
+	`UPDATE todos SET org_id = user_id WHERE org_id IS NULL`,
+	`UPDATE todo_changes SET org_id = user_id WHERE org_id IS NULL`,
+	`CREATE INDEX IF NOT EXISTS projects_org_id_idx ON projects (org_id)`,
+	`CREATE INDEX IF NOT EXISTS todos_org_id_idx ON todos (org_id)`,
+	`CREATE INDEX IF NOT EXISTS todo_changes_org_id_seq_idx ON todo_changes (org_id, seq)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS org_members_org_id_email_idx ON org_members (org_id, lower(email))`,
+	`CREATE INDEX IF NOT EXISTS org_members_user_id_idx ON org_members (user_id)`,
+	// Row level security keeps queries run through InOrg to one
+	// organization. A connection that hasn't set app.org_id sees no rows
+	// and can't write any, unless it is one of the AllOrgs connections.
+	// Superusers and roles with BYPASSRLS aren't limited at all. It backs up
+	// the scoping done in the application; see InOrg.
+	`ALTER TABLE projects ENABLE ROW LEVEL SECURITY`,
+	`ALTER TABLE projects FORCE ROW LEVEL SECURITY`,
+	`DROP POLICY IF EXISTS projects_org_isolation ON projects`,
+	`CREATE POLICY projects_org_isolation ON projects
+		USING (org_id = NULLIF(current_setting('app.org_id', true), '') OR current_setting('app.all_orgs', true) = 'on')
+		WITH CHECK (org_id = NULLIF(current_setting('app.org_id', true), '') OR current_setting('app.all_orgs', true) = 'on')`,
+	`ALTER TABLE todos ENABLE ROW LEVEL SECURITY`,
+	`ALTER TABLE todos FORCE ROW LEVEL SECURITY`,
+	`DROP POLICY IF EXISTS todos_org_isolation ON todos`,
+	`CREATE POLICY todos_org_isolation ON todos
+		USING (org_id = NULLIF(current_setting('app.org_id', true), '') OR current_setting('app.all_orgs', true) = 'on')
+		WITH CHECK (org_id = NULLIF(current_setting('app.org_id', true), '') OR current_setting('app.all_orgs', true) = 'on')`,
+	`ALTER TABLE todo_changes ENABLE ROW LEVEL SECURITY`,
+	`ALTER TABLE todo_changes FORCE ROW LEVEL SECURITY`,
+	`DROP POLICY IF EXISTS todo_changes_org_isolation ON todo_changes`,
+	`CREATE POLICY todo_changes_org_isolation ON todo_changes
+		USING (org_id = NULLIF(current_setting('app.org_id', true), '') OR current_setting('app.all_orgs', true) = 'on')
+		WITH CHECK (org_id = NULLIF(current_setting('app.org_id', true), '') OR current_setting('app.all_orgs', true) = 'on')`,
+	`CREATE INDEX IF NOT EXISTS webhooks_user_id_org_id_idx ON webhooks (user_id, org_id)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_created_at_idx ON webhook_deliveries (webhook_id, created_at, id)`,
+	// Domain events raised on a user are written to the outbox by the same
+	// statement that saves the user, so they commit or roll back with it.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_events jsonb`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS domain_events_id_idx ON domain_events (id)`,
+	`CREATE INDEX IF NOT EXISTS domain_events_pending_idx ON domain_events (next_attempt_at)
+		WHERE dispatched_at IS NULL AND failed_at IS NULL`,
+	`CREATE OR REPLACE FUNCTION write_domain_events() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.pending_events IS NOT NULL THEN
+			INSERT INTO domain_events (id, type, user_id, org_id, subject_id, payload, occurred_at, attempts, next_attempt_at)
+			SELECT e->>'id', e->>'type', NEW.id, e->>'orgId', e->>'subjectId', e->'data', (e->>'occurredAt')::timestamptz, 0, now()
+			FROM jsonb_array_elements(NEW.pending_events) WITH ORDINALITY AS events(e, n)
+			ORDER BY n
+			ON CONFLICT (id) DO NOTHING;
+			NEW.pending_events := NULL;
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS users_write_domain_events ON users`,
+	`CREATE TRIGGER users_write_domain_events BEFORE INSERT OR UPDATE ON users
+		FOR EACH ROW EXECUTE PROCEDURE write_domain_events()`,
+	`CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (type, run_at) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS jobs_leased_idx ON jobs (type, locked_until) WHERE status = 'running'`,
+	`CREATE INDEX IF NOT EXISTS jobs_created_at_idx ON jobs (created_at, id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS jobs_type_unique_key_idx ON jobs (type, unique_key)
+		WHERE unique_key IS NOT NULL AND status IN ('pending', 'running')`,
+	`CREATE INDEX IF NOT EXISTS cron_runs_task_started_at_idx ON cron_runs (task, started_at, id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS cron_runs_task_scheduled_at_idx ON cron_runs (task, scheduled_at)
+		WHERE trigger = 'schedule'`,
+	// Saving the user aggregate rewrites all of its rows, so a save made from
+	// a copy loaded before another save would undo that save. Every save
+	// moves the user to its next version and must start from the current one.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 0`,
+	`CREATE OR REPLACE FUNCTION check_user_version() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.version <> OLD.version + 1 THEN
+			RAISE EXCEPTION 'user % was saved at version %, expected %', OLD.id, OLD.version, NEW.version - 1
+				USING ERRCODE = 'serialization_failure';
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS users_check_version ON users`,
+	`CREATE TRIGGER users_check_version BEFORE UPDATE ON users
+		FOR EACH ROW EXECUTE PROCEDURE check_user_version()`,
+	// History entries recorded on a user are appended as the user is saved,
+	// like domain events, so every saved version of a todo has its entry.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_history jsonb`,
+	`CREATE OR REPLACE FUNCTION write_todo_history() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.pending_history IS NOT NULL THEN
+			INSERT INTO todo_history (id, user_id, todo_id, actor_id, action, version, changes, created_at)
+			SELECT e->>'id', NEW.id, e->>'todoId', e->>'actorId', e->>'action', (e->>'version')::bigint, e->'changes', (e->>'createdAt')::timestamptz
+			FROM jsonb_array_elements(NEW.pending_history) AS entries(e);
+			NEW.pending_history := NULL;
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS users_write_todo_history ON users`,
+	`CREATE TRIGGER users_write_todo_history BEFORE INSERT OR UPDATE ON users
+		FOR EACH ROW EXECUTE PROCEDURE write_todo_history()`,
+	// Sync positions are transaction IDs, see SyncStore.
+	`ALTER TABLE todo_changes ADD COLUMN IF NOT EXISTS xid xid8 NOT NULL DEFAULT pg_current_xact_id()`,
+	`CREATE INDEX IF NOT EXISTS todo_changes_user_id_xid_idx ON todo_changes (user_id, xid)`,
+	`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claimed_until timestamptz`,
+	// Keys claimed before claims were fenced have no claim and run out.
+	`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claim text NOT NULL DEFAULT ''`,
+	// Deliveries queued more than once for the same event before they were
+	// unique are kept as redeliveries of the first.
+	`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS redelivery_of text`,
+	`UPDATE webhook_deliveries d SET redelivery_of = first.id
+		FROM (
+			SELECT DISTINCT ON (webhook_id, event_id) id, webhook_id, event_id
+			FROM webhook_deliveries
+			WHERE redelivery_of IS NULL
+			ORDER BY webhook_id, event_id, created_at, id
+		) first
+		WHERE d.webhook_id = first.webhook_id AND d.event_id = first.event_id
+			AND d.id != first.id AND d.redelivery_of IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_event_id_idx ON webhook_deliveries (webhook_id, event_id) WHERE redelivery_of IS NULL`,
+}
+
 func CreateSchema(db *pg.DB) error {
 	models := []interface{}{
 		(*user)(nil),
 		(*todo)(nil),
+		(*tag)(nil),
+		(*todoTag)(nil),
+		(*project)(nil),
+		(*todoHistory)(nil),
+		(*idempotencyKey)(nil),
+		(*todoChange)(nil),
+		(*projectMember)(nil),
+		(*todoComment)(nil),
+		(*todoAttachment)(nil),
+		(*organization)(nil),
+		(*orgMember)(nil),
+		(*webhook)(nil),
+		(*webhookDelivery)(nil),
+		(*domainEvent)(nil),
+		(*job)(nil),
+		(*cronRun)(nil),
 	}
 
 	for _, model := range models {
@@ -20,5 +261,12 @@ func CreateSchema(db *pg.DB) error {
 		}
 	}
 
+	for _, migration := range migrations {
+		_, err := db.Exec(migration)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
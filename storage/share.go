@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+var ErrMemberNotFound = errors.New("project member not found")
+
+type projectMember struct {
+	tableName struct{} `pg:"project_members"`
+
+	ID          string     `pg:"id"`
+	ProjectID   string     `pg:"project_id"`
+	OwnerID     string     `pg:"owner_id"`
+	UserID      string     `pg:"user_id"`
+	Email       string     `pg:"email"`
+	Role        string     `pg:"role"`
+	Status      string     `pg:"status"`
+	InvitedBy   string     `pg:"invited_by"`
+	CreatedAt   time.Time  `pg:"created_at"`
+	RespondedAt *time.Time `pg:"responded_at"`
+}
+
+func newProjectMember(m *domain.ProjectMember) *projectMember {
+	return &projectMember{
+		ID:          m.ID.String(),
+		ProjectID:   m.ProjectID.String(),
+		OwnerID:     m.OwnerID.String(),
+		UserID:      m.UserID.String(),
+		Email:       m.Email,
+		Role:        string(m.Role),
+		Status:      string(m.Status),
+		InvitedBy:   m.InvitedBy.String(),
+		CreatedAt:   m.CreatedAt,
+		RespondedAt: m.RespondedAt,
+	}
+}
+
+func (m *projectMember) toEntity() *domain.ProjectMember {
+	return &domain.ProjectMember{
+		ID:          entityid.ID(m.ID),
+		ProjectID:   entityid.ID(m.ProjectID),
+		OwnerID:     entityid.ID(m.OwnerID),
+		UserID:      entityid.ID(m.UserID),
+		Email:       m.Email,
+		Role:        domain.Role(m.Role),
+		Status:      domain.InvitationStatus(m.Status),
+		InvitedBy:   entityid.ID(m.InvitedBy),
+		CreatedAt:   m.CreatedAt,
+		RespondedAt: m.RespondedAt,
+	}
+}
+
+// ShareStore keeps the members of shared projects. Memberships live outside
+// the owner's aggregate and are read on every request, so revoking access
+// takes effect immediately. Access to a project in its owner's personal
+// organization is reached from the member's personal organization, so the
+// store reads projects and todos across organizations and needs an AllOrgs
+// connection; the queries that take an organization apply its rules
+// themselves.
+type ShareStore struct {
+	DB *pg.DB
+}
+
+func (ss *ShareStore) Create(ctx context.Context, member *domain.ProjectMember) error {
+	_, err := ss.DB.ModelContext(ctx, newProjectMember(member)).Insert()
+	return err
+}
+
+func (ss *ShareStore) Update(ctx context.Context, member *domain.ProjectMember) error {
+	_, err := ss.DB.ModelContext(ctx, newProjectMember(member)).WherePK().Update()
+	return err
+}
+
+func (ss *ShareStore) Delete(ctx context.Context, id entityid.ID) error {
+	_, err := ss.DB.ExecContext(ctx, `DELETE FROM project_members WHERE id = ?`, id.String())
+	return err
+}
+
+// DeleteProject removes every member of the project.
+func (ss *ShareStore) DeleteProject(ctx context.Context, projectID entityid.ID) error {
+	_, err := ss.DB.ExecContext(ctx, `DELETE FROM project_members WHERE project_id = ?`, projectID.String())
+	return err
+}
+
+func (ss *ShareStore) find(ctx context.Context, where string, params ...interface{}) (*domain.ProjectMember, error) {
+	row := &projectMember{}
+	err := ss.DB.ModelContext(ctx, row).Where(where, params...).Limit(1).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+func (ss *ShareStore) list(ctx context.Context, where string, params ...interface{}) ([]*domain.ProjectMember, error) {
+	var rows []*projectMember
+	err := ss.DB.ModelContext(ctx, &rows).Where(where, params...).Order("created_at ASC").Select()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*domain.ProjectMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, row.toEntity())
+	}
+	return members, nil
+}
+
+func (ss *ShareStore) Find(ctx context.Context, id entityid.ID) (*domain.ProjectMember, error) {
+	return ss.find(ctx, "id = ?", id.String())
+}
+
+// FindByEmail finds the invitation to the project for an email address,
+// whatever its status.
+func (ss *ShareStore) FindByEmail(ctx context.Context, projectID entityid.ID, email string) (*domain.ProjectMember, error) {
+	return ss.find(ctx, "project_id = ? AND lower(email) = lower(?)", projectID.String(), email)
+}
+
+// Membership finds the user's accepted membership of the project.
+func (ss *ShareStore) Membership(ctx context.Context, projectID, userID entityid.ID) (*domain.ProjectMember, error) {
+	return ss.find(ctx, "project_id = ? AND user_id = ? AND status = ?", projectID.String(), userID.String(), domain.InvitationAccepted)
+}
+
+// inOrg limits memberships to projects in the organization the member is
+// signed in to. Projects in their owner's personal organization are reached
+// from the member's own personal organization.
+const inOrg = `project_id IN (SELECT id FROM projects WHERE org_id = ? OR (org_id = user_id AND ? = ?))`
+
+// OrgMembership finds the user's accepted membership of the project when the
+// project belongs to the organization they are signed in to.
+func (ss *ShareStore) OrgMembership(ctx context.Context, projectID, userID, orgID entityid.ID) (*domain.ProjectMember, error) {
+	return ss.find(ctx, "project_id = ? AND user_id = ? AND status = ? AND "+inOrg,
+		projectID.String(), userID.String(), domain.InvitationAccepted, orgID.String(), orgID.String(), userID.String())
+}
+
+// ListProject lists the project's members and outstanding invitations.
+func (ss *ShareStore) ListProject(ctx context.Context, projectID entityid.ID) ([]*domain.ProjectMember, error) {
+	return ss.list(ctx, "project_id = ? AND status != ?", projectID.String(), domain.InvitationDeclined)
+}
+
+// ListPending lists the invitations waiting on an email address.
+func (ss *ShareStore) ListPending(ctx context.Context, email string) ([]*domain.ProjectMember, error) {
+	return ss.list(ctx, "lower(email) = lower(?) AND status = ?", email, domain.InvitationPending)
+}
+
+// ListAccepted lists the projects in the organization that the user is a
+// member of.
+func (ss *ShareStore) ListAccepted(ctx context.Context, userID, orgID entityid.ID) ([]*domain.ProjectMember, error) {
+	return ss.list(ctx, "user_id = ? AND status = ? AND "+inOrg,
+		userID.String(), domain.InvitationAccepted, orgID.String(), orgID.String(), userID.String())
+}
+
+// MemberUserIDs returns the users who have accepted an invitation to the
+// project.
+func (ss *ShareStore) MemberUserIDs(ctx context.Context, projectID entityid.ID) ([]entityid.ID, error) {
+	members, err := ss.list(ctx, "project_id = ? AND status = ?", projectID.String(), domain.InvitationAccepted)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]entityid.ID, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	return userIDs, nil
+}
+
+// TodoProject returns the project a todo belongs to, or an empty ID if there
+// is no such todo.
+func (ss *ShareStore) TodoProject(ctx context.Context, todoID entityid.ID) (entityid.ID, error) {
+	var projectID string
+	_, err := ss.DB.QueryContext(ctx, pg.Scan(&projectID), `SELECT project_id FROM todos WHERE id = ?`, todoID.String())
+	return entityid.ID(projectID), err
+}
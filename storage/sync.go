@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+// todoChange is a row of the change log that triggers on the todos table
+// append to whenever a todo is inserted, changed or deleted, whichever code
+// path wrote it. See migrations.
+type todoChange struct {
+	tableName struct{} `pg:"todo_changes"`
+
+	Seq       int64     `pg:"seq,pk,type:bigserial"`
+	UserID    string    `pg:"user_id"`
+	OrgID     string    `pg:"org_id"`
+	TodoID    string    `pg:"todo_id"`
+	Deleted   bool      `pg:"deleted,use_zero"`
+	ChangedAt time.Time `pg:"changed_at"`
+}
+
+// TodoChange is the latest change to a todo in the change log.
+type TodoChange struct {
+	TodoID  entityid.ID
+	Deleted bool
+}
+
+// SyncStore reads the todo change log for delta sync. A change gets its
+// sequence number when it is logged but becomes visible when its transaction
+// commits, so a reader that stopped at the highest sequence number it saw
+// could skip a change committed afterwards with a lower one. Positions in the
+// log are instead the oldest transaction still in progress when it was read:
+// every change logged by an older transaction was visible by then, and
+// changes from that transaction on are read again next time.
+type SyncStore struct {
+	DB *pg.DB
+}
+
+const currentSyncPosition = `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`
+
+// Changes returns the latest change to each of the user's todos in the
+// organization logged by transactions from the position since on, along with
+// the position to read from next time.
+func (ss *SyncStore) Changes(ctx context.Context, userID, orgID entityid.ID, since int64) ([]TodoChange, int64, error) {
+	var rows []*todoChange
+	var next int64
+	err := InOrg(ctx, ss.DB, orgID, func(tx *pg.Tx) error {
+		// Taken before reading, so whatever the read misses is logged by a
+		// transaction at or after the position.
+		_, err := tx.QueryOneContext(ctx, pg.Scan(&next), currentSyncPosition)
+		if err != nil {
+			return err
+		}
+		_, err = tx.QueryContext(ctx, &rows, `
+			SELECT DISTINCT ON (todo_id) seq, todo_id, deleted
+			FROM todo_changes
+			WHERE user_id = ? AND org_id = ? AND xid >= ?::text::xid8
+			ORDER BY todo_id, seq DESC
+		`, userID.String(), orgID.String(), since)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	changes := make([]TodoChange, 0, len(rows))
+	for _, row := range rows {
+		changes = append(changes, TodoChange{TodoID: entityid.ID(row.TodoID), Deleted: row.Deleted})
+	}
+	return changes, next, nil
+}
+
+// Position returns the current position in the change log, for starting a
+// sync from the current state.
+func (ss *SyncStore) Position(ctx context.Context) (int64, error) {
+	var position int64
+	_, err := ss.DB.QueryOneContext(ctx, pg.Scan(&position), currentSyncPosition)
+	return position, err
+}
+
+// PurgeTodoChanges deletes change log entries older than the given time. It
+// returns how many entries were removed.
+func PurgeTodoChanges(ctx context.Context, db *pg.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM todo_changes WHERE changed_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+type tag struct {
+	ID        string    `pg:"id"`
+	UserID    string    `pg:"user_id"`
+	Name      string    `pg:"name"`
+	Color     string    `pg:"color"`
+	CreatedAt time.Time `pg:"created_at"`
+}
+
+// todoTag joins todos and tags. It is keyed on the pair so attaching the same
+// tag twice is a no-op.
+type todoTag struct {
+	ID     string `pg:"id"`
+	UserID string `pg:"user_id"`
+	TodoID string `pg:"todo_id"`
+	TagID  string `pg:"tag_id"`
+}
+
+func newTag(userID string, t *domain.Tag) *tag {
+	return &tag{
+		ID:        t.ID.String(),
+		UserID:    userID,
+		Name:      t.Name,
+		Color:     t.Color,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+func (t *tag) toEntity() *domain.Tag {
+	return &domain.Tag{
+		ID:        entityid.ID(t.ID),
+		Name:      t.Name,
+		Color:     t.Color,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+func newTodoTags(userID string, t *domain.Todo) []*todoTag {
+	var todoTags []*todoTag
+	for _, tg := range t.Tags {
+		todoTags = append(todoTags, &todoTag{
+			ID:     t.ID.String() + ":" + tg.ID.String(),
+			UserID: userID,
+			TodoID: t.ID.String(),
+			TagID:  tg.ID.String(),
+		})
+	}
+	return todoTags
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+// PurgeTrashUsers returns the users with todos that have been in the trash
+// since before the given time. The todos are removed through the user
+// aggregate, see domain.User.PurgeTrash.
+func PurgeTrashUsers(ctx context.Context, db *pg.DB, before time.Time) ([]entityid.ID, error) {
+	var ids []string
+	_, err := db.QueryContext(ctx, &ids, `SELECT DISTINCT user_id FROM todos WHERE deleted_at < ?`, before)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]entityid.ID, len(ids))
+	for i, id := range ids {
+		userIDs[i] = entityid.ID(id)
+	}
+	return userIDs, nil
+}
@@ -1,32 +1,261 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
 	"time"
 
 	"github.com/DillonStreator/todos/domain"
 	"github.com/DillonStreator/todos/entityid"
 	"github.com/eleanorhealth/milo"
+	"github.com/go-pg/pg/v10"
 )
 
+// ErrUserConflict is returned by SaveUser when the user was saved by someone
+// else since it was loaded.
+var ErrUserConflict = errors.New("user was changed by another request")
+
+// SaveUser saves the user aggregate and moves it to its next version. It
+// fails with ErrUserConflict, saving nothing, unless the user is still at the
+// version it was loaded at. A user loaded by FindUserInOrg is saved on db in
+// its organization, where row level security keeps the writes; others are
+// saved by store with the data of every organization.
+func SaveUser(ctx context.Context, store *milo.Store, db *pg.DB, entity *domain.User) error {
+	var err error
+	if entity.Limited() {
+		err = saveUserInOrg(ctx, db, entity)
+	} else {
+		err = store.Save(ctx, entity)
+	}
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) && pgErr.Field('C') == serializationFailure {
+		return ErrUserConflict
+	}
+	if err != nil {
+		return err
+	}
+	entity.Version++
+	return nil
+}
+
+// FindUserInOrg loads the user with the projects and todos of one
+// organization, reading them in InOrg so row level security keeps the other
+// organizations' out, and limits the user to it; see domain.User.LimitToOrg.
+// It returns milo.ErrNotFound when there is no such user.
+func FindUserInOrg(ctx context.Context, db *pg.DB, userID, orgID entityid.ID) (*domain.User, error) {
+	row := &user{}
+	err := InOrg(ctx, db, orgID, func(tx *pg.Tx) error {
+		return tx.ModelContext(ctx, row).
+			Relation("Todos").
+			Relation("Tags").
+			Relation("TodoTags").
+			Relation("Projects").
+			Where("?TableAlias.id = ?", userID.String()).
+			Select()
+	})
+	if err == pg.ErrNoRows {
+		return nil, milo.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := row.ToEntity()
+	if err != nil {
+		return nil, err
+	}
+	u := entity.(*domain.User)
+	u.LimitToOrg(orgID)
+	return u, nil
+}
+
+// saveUserInOrg saves a user loaded by FindUserInOrg. It runs in InOrg, so
+// only the organization's projects and todos are replaced and the others are
+// left as they are. Tags belong to the user in every organization, so a
+// deleted tag is taken off the todos of the others too.
+func saveUserInOrg(ctx context.Context, db *pg.DB, entity *domain.User) error {
+	row := &user{}
+	err := row.FromEntity(entity)
+	if err != nil {
+		return err
+	}
+
+	// Empty rather than nil, so NOT (id = ANY(?)) matches every row.
+	tagIDs := make([]string, 0, len(row.Tags))
+	projectIDs := make([]string, 0, len(row.Projects))
+	todoIDs := make([]string, 0, len(row.Todos))
+	for _, t := range row.Tags {
+		tagIDs = append(tagIDs, t.ID)
+	}
+	for _, p := range row.Projects {
+		projectIDs = append(projectIDs, p.ID)
+	}
+	for _, t := range row.Todos {
+		todoIDs = append(todoIDs, t.ID)
+	}
+
+	return InOrg(ctx, db, entity.OrgID, func(tx *pg.Tx) error {
+		// The users triggers check the version and write the pending events
+		// and history.
+		_, err := tx.ModelContext(ctx, row).
+			Column("email", "password", "timezone", "auto_archive_after_days", "version", "last_seen_at", "pending_events", "pending_history").
+			WherePK().
+			Update()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM todo_tags WHERE user_id = ? AND NOT (tag_id = ANY(?))`, row.ID, pg.Array(tagIDs))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM tags WHERE user_id = ? AND NOT (id = ANY(?))`, row.ID, pg.Array(tagIDs))
+		if err != nil {
+			return err
+		}
+		// Row level security limits the rest to the organization's rows.
+		_, err = tx.ExecContext(ctx, `DELETE FROM todo_tags WHERE todo_id IN (SELECT id FROM todos WHERE user_id = ?)`, row.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM todos WHERE user_id = ? AND NOT (id = ANY(?))`, row.ID, pg.Array(todoIDs))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM projects WHERE user_id = ? AND NOT (id = ANY(?))`, row.ID, pg.Array(projectIDs))
+		if err != nil {
+			return err
+		}
+
+		for _, rows := range []interface{}{&row.Tags, &row.Projects, &row.Todos, &row.TodoTags} {
+			if reflect.ValueOf(rows).Elem().Len() == 0 {
+				continue
+			}
+			_, err = tx.ModelContext(ctx, rows).OnConflict("(id) DO UPDATE").Insert()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindUserIDByEmail returns the ID of the user with the email address,
+// ignoring case since addresses are kept as they were typed at signup, or an
+// empty ID when there is none. An exact match wins over others differing only
+// in case.
+func FindUserIDByEmail(ctx context.Context, db *pg.DB, email string) (entityid.ID, error) {
+	var ids []string
+	_, err := db.QueryContext(ctx, &ids, `
+		SELECT id FROM users
+		WHERE lower(email) = lower(?0)
+		ORDER BY email = ?0 DESC, created_at ASC
+		LIMIT 1`, email)
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return entityid.ID(ids[0]), nil
+}
+
 type user struct {
-	ID         string    `pg:"id"`
-	Email      string    `pg:"email"`
-	Password   string    `pg:"password"`
-	CreatedAt  time.Time `pg:"created_at"`
-	LastSeenAt time.Time `pg:"last_seen_at"`
-	Todos      []*todo   `pg:"rel:has-many"`
+	ID                   string          `pg:"id"`
+	Email                string          `pg:"email"`
+	Password             string          `pg:"password"`
+	Timezone             string          `pg:"timezone"`
+	AutoArchiveAfterDays int             `pg:"auto_archive_after_days,use_zero"`
+	Version              int             `pg:"version,use_zero"`
+	CreatedAt            time.Time       `pg:"created_at"`
+	LastSeenAt           time.Time       `pg:"last_seen_at"`
+	PendingEvents        json.RawMessage `pg:"pending_events,type:jsonb"`
+	PendingHistory       json.RawMessage `pg:"pending_history,type:jsonb"`
+	Todos                []*todo         `pg:"rel:has-many"`
+	Tags                 []*tag          `pg:"rel:has-many"`
+	TodoTags             []*todoTag      `pg:"rel:has-many"`
+	Projects             []*project      `pg:"rel:has-many"`
 }
 
 var _ milo.Model = (*user)(nil)
 
 type todo struct {
-	ID          string    `pg:"id"`
-	UserID      string    `pg:"user_id"`
-	Title       string    `pg:"title"`
-	Description string    `pg:"description"`
-	Completed   bool      `pg:"completed"`
-	CreatedAt   time.Time `pg:"created_at"`
-	UpdatedAt   time.Time `pg:"updated_at"`
+	ID              string     `pg:"id"`
+	UserID          string     `pg:"user_id"`
+	OrgID           string     `pg:"org_id"`
+	Version         int        `pg:"version,use_zero"`
+	ProjectID       string     `pg:"project_id"`
+	ParentID        string     `pg:"parent_id"`
+	AssigneeID      string     `pg:"assignee_id"`
+	Position        string     `pg:"position"`
+	CompletedAt     *time.Time `pg:"completed_at"`
+	ArchivedAt      *time.Time `pg:"archived_at"`
+	DeletedAt       *time.Time `pg:"deleted_at"`
+	Title           string     `pg:"title"`
+	Description     string     `pg:"description"`
+	Completed       bool       `pg:"completed"`
+	CreatedAt       time.Time  `pg:"created_at"`
+	UpdatedAt       time.Time  `pg:"updated_at"`
+	DueAt           *time.Time `pg:"due_at"`
+	AllDay          bool       `pg:"all_day,use_zero"`
+	ReminderOffsets []int      `pg:"reminder_offsets,array"`
+	RemindedAt      *time.Time `pg:"reminded_at"`
+	Recurrence      string     `pg:"recurrence"`
+	RecurrenceStart *time.Time `pg:"recurrence_start"`
+	Priority        string     `pg:"priority"`
+}
+
+func newTodo(userID, orgID string, t *domain.Todo) *todo {
+	return &todo{
+		ID:              t.ID.String(),
+		UserID:          userID,
+		OrgID:           orgID,
+		Version:         t.Version,
+		ProjectID:       t.ProjectID.String(),
+		ParentID:        t.ParentID.String(),
+		AssigneeID:      t.AssigneeID.String(),
+		Position:        t.Position,
+		CompletedAt:     t.CompletedAt,
+		ArchivedAt:      t.ArchivedAt,
+		DeletedAt:       t.DeletedAt,
+		Title:           t.Title,
+		Description:     t.Description,
+		Completed:       t.Completed,
+		CreatedAt:       t.CreatedAt,
+		UpdatedAt:       t.UpdatedAt,
+		DueAt:           t.DueAt,
+		AllDay:          t.AllDay,
+		ReminderOffsets: t.ReminderOffsets,
+		RemindedAt:      t.RemindedAt,
+		Recurrence:      t.Recurrence,
+		RecurrenceStart: t.RecurrenceStart,
+		Priority:        string(t.Priority),
+	}
+}
+
+func (t *todo) toEntity() *domain.Todo {
+	return &domain.Todo{
+		ID:              entityid.ID(t.ID),
+		Version:         t.Version,
+		ProjectID:       entityid.ID(t.ProjectID),
+		ParentID:        entityid.ID(t.ParentID),
+		AssigneeID:      entityid.ID(t.AssigneeID),
+		Position:        t.Position,
+		CompletedAt:     t.CompletedAt,
+		ArchivedAt:      t.ArchivedAt,
+		DeletedAt:       t.DeletedAt,
+		Title:           t.Title,
+		Description:     t.Description,
+		Completed:       t.Completed,
+		CreatedAt:       t.CreatedAt,
+		UpdatedAt:       t.UpdatedAt,
+		DueAt:           t.DueAt,
+		AllDay:          t.AllDay,
+		ReminderOffsets: t.ReminderOffsets,
+		RemindedAt:      t.RemindedAt,
+		Recurrence:      t.Recurrence,
+		RecurrenceStart: t.RecurrenceStart,
+		Priority:        domain.Priority(t.Priority),
+	}
 }
 
 func (u *user) FromEntity(e interface{}) error {
@@ -35,21 +264,49 @@ func (u *user) FromEntity(e interface{}) error {
 	u.ID = entity.ID.String()
 	u.Email = entity.Email
 	u.Password = entity.Password
+	u.Timezone = entity.Timezone
+	u.AutoArchiveAfterDays = entity.AutoArchiveAfterDays
+	// The users_check_version trigger only accepts the version following
+	// the one the user was loaded at.
+	u.Version = entity.Version + 1
 
 	u.CreatedAt = entity.CreatedAt
 	u.LastSeenAt = entity.LastSeenAt
 
-	for _, t := range entity.Todos {
-		u.Todos = append(u.Todos, &todo{
-			ID:          t.ID.String(),
-			UserID:      u.ID,
-			Title:       t.Title,
-			Description: t.Description,
-			Completed:   t.Completed,
-			CreatedAt:   t.CreatedAt,
-			UpdatedAt:   t.UpdatedAt,
-		})
+	// A user scoped to one organization still owns the projects and todos of
+	// the others, so every one of them is saved.
+	orgIDs := make(map[entityid.ID]string)
+	for _, p := range entity.AllProjects() {
+		project := newProject(u.ID, p)
+		u.Projects = append(u.Projects, project)
+		orgIDs[p.ID] = project.OrgID
+	}
+	for _, t := range entity.AllTodos() {
+		orgID, ok := orgIDs[t.ProjectID]
+		if !ok {
+			orgID = u.ID
+		}
+		u.Todos = append(u.Todos, newTodo(u.ID, orgID, t))
+		u.TodoTags = append(u.TodoTags, newTodoTags(u.ID, t)...)
 	}
+	for _, t := range entity.Tags {
+		u.Tags = append(u.Tags, newTag(u.ID, t))
+	}
+
+	// The write_domain_events trigger moves the raised events to the outbox
+	// and clears the column as the row is saved.
+	pending, err := newPendingEvents(entity.Events())
+	if err != nil {
+		return err
+	}
+	u.PendingEvents = pending
+
+	// So does the write_todo_history trigger with the history entries.
+	history, err := newPendingHistory(entity.PendingHistory())
+	if err != nil {
+		return err
+	}
+	u.PendingHistory = history
 
 	return nil
 }
@@ -60,19 +317,31 @@ func (u *user) ToEntity() (interface{}, error) {
 	entity.ID = entityid.ID(u.ID)
 	entity.Email = u.Email
 	entity.Password = u.Password
+	entity.Timezone = u.Timezone
+	entity.AutoArchiveAfterDays = u.AutoArchiveAfterDays
+	entity.Version = u.Version
 
 	entity.CreatedAt = u.CreatedAt
 	entity.LastSeenAt = u.LastSeenAt
 
+	for _, t := range u.Tags {
+		entity.Tags = append(entity.Tags, t.toEntity())
+	}
+	for _, p := range u.Projects {
+		entity.Projects = append(entity.Projects, p.toEntity())
+	}
+
+	tagsByTodoID := make(map[string]domain.Tags)
+	for _, tt := range u.TodoTags {
+		if tg := entity.Tags.FindByID(entityid.ID(tt.TagID)); tg.ID != "" {
+			tagsByTodoID[tt.TodoID] = append(tagsByTodoID[tt.TodoID], tg)
+		}
+	}
+
 	for _, t := range u.Todos {
-		entity.Todos = append(entity.Todos, &domain.Todo{
-			ID:          entityid.ID(t.ID),
-			Title:       t.Title,
-			Description: t.Description,
-			Completed:   t.Completed,
-			CreatedAt:   t.CreatedAt,
-			UpdatedAt:   t.UpdatedAt,
-		})
+		todo := t.toEntity()
+		todo.Tags = tagsByTodoID[t.ID]
+		entity.Todos = append(entity.Todos, todo)
 	}
 
 	return entity, nil
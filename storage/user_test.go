@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-pg/pg/v10"
+)
+
+func Test_user_FromEntity_orgs(t *testing.T) {
+	entity := &domain.User{
+		ID: "user",
+		Projects: domain.Projects{
+			{ID: "a-inbox", OrgID: "a", Inbox: true},
+			{ID: "b-inbox", OrgID: "b", Inbox: true},
+			{ID: "legacy"},
+		},
+		Todos: domain.Todos{
+			{ID: "a-1", ProjectID: "a-inbox"},
+			{ID: "b-1", ProjectID: "b-inbox"},
+			{ID: "legacy-1", ProjectID: "legacy"},
+		},
+	}
+	entity.ScopeToOrg("a")
+
+	model := &user{}
+	if err := model.FromEntity(entity); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"a-1": "a", "b-1": "b", "legacy-1": "user"}
+	if len(model.Todos) != len(expected) {
+		t.Fatalf("FromEntity() saved %d todos, expected %d", len(model.Todos), len(expected))
+	}
+	for _, todo := range model.Todos {
+		if todo.OrgID != expected[todo.ID] {
+			t.Errorf("todo %q OrgID = %q, expected %q", todo.ID, todo.OrgID, expected[todo.ID])
+		}
+	}
+	if len(model.Projects) != 3 {
+		t.Errorf("FromEntity() saved %d projects, expected %d", len(model.Projects), 3)
+	}
+}
+
+func Test_user_FromEntity_version(t *testing.T) {
+	model := &user{}
+	if err := model.FromEntity(&domain.User{ID: "user", Version: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if model.Version != 5 {
+		t.Errorf("FromEntity() Version = %d, expected %d", model.Version, 5)
+	}
+}
+
+// The write_todo_history trigger reads these keys from the pending_history
+// column.
+func Test_user_FromEntity_history(t *testing.T) {
+	entity := &domain.User{ID: "user"}
+	entity.RecordHistory(&domain.HistoryEntry{
+		ID:        "entry",
+		TodoID:    "todo",
+		ActorID:   "user",
+		Action:    domain.HistoryCreated,
+		Version:   1,
+		Changes:   []domain.FieldChange{{Field: "title", After: json.RawMessage(`"Buy milk"`)}},
+		CreatedAt: time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC),
+	})
+
+	model := &user{}
+	if err := model.FromEntity(entity); err != nil {
+		t.Fatal(err)
+	}
+
+	var pending []map[string]json.RawMessage
+	if err := json.Unmarshal(model.PendingHistory, &pending); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("FromEntity() wrote %d history entries, expected 1", len(pending))
+	}
+	for _, key := range []string{"id", "todoId", "actorId", "action", "version", "changes", "createdAt"} {
+		if _, ok := pending[0][key]; !ok {
+			t.Errorf("pending history entry is missing %q", key)
+		}
+	}
+
+	entity.ClearHistory()
+	model = &user{}
+	if err := model.FromEntity(entity); err != nil {
+		t.Fatal(err)
+	}
+	if model.PendingHistory != nil {
+		t.Errorf("FromEntity() PendingHistory = %s, expected nil once cleared", model.PendingHistory)
+	}
+}
+
+func TestFindUserIDByEmail(t *testing.T) {
+	options := testDBOptions(t)
+	ctx := context.Background()
+
+	db := pg.Connect(AllOrgs(options))
+	defer db.Close()
+	if err := CreateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	userID := entityid.Generator.Generate()
+	email := "Bob." + userID.String() + "@Example.com"
+	now := time.Now()
+	_, err := db.ModelContext(ctx, &user{ID: userID.String(), Email: email, CreatedAt: now, LastSeenAt: now}).Insert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID.String())
+	})
+
+	// Mentions are parsed lowercased.
+	for _, lookup := range []string{email, strings.ToLower(email)} {
+		id, err := FindUserIDByEmail(ctx, db, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != userID {
+			t.Errorf("FindUserIDByEmail(%s) = %q, expected %q", lookup, id, userID)
+		}
+	}
+	id, err := FindUserIDByEmail(ctx, db, "nobody."+userID.String()+"@example.com")
+	if err != nil || id != "" {
+		t.Errorf("FindUserIDByEmail(nobody) = %q, %v, expected no user", id, err)
+	}
+}
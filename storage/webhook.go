@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/webhooks"
+	"github.com/go-pg/pg/v10"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+type webhook struct {
+	tableName struct{} `pg:"webhooks"`
+
+	ID                  string     `pg:"id"`
+	UserID              string     `pg:"user_id"`
+	OrgID               string     `pg:"org_id"`
+	URL                 string     `pg:"url"`
+	EventTypes          []string   `pg:"event_types,array"`
+	Secret              string     `pg:"secret"`
+	ConsecutiveFailures int        `pg:"consecutive_failures,use_zero"`
+	DisabledAt          *time.Time `pg:"disabled_at"`
+	CreatedAt           time.Time  `pg:"created_at"`
+}
+
+func newWebhook(w *domain.Webhook) *webhook {
+	return &webhook{
+		ID:                  w.ID.String(),
+		UserID:              w.UserID.String(),
+		OrgID:               w.OrgID.String(),
+		URL:                 w.URL,
+		EventTypes:          w.EventTypes,
+		Secret:              w.Secret,
+		ConsecutiveFailures: w.ConsecutiveFailures,
+		DisabledAt:          w.DisabledAt,
+		CreatedAt:           w.CreatedAt,
+	}
+}
+
+func (w *webhook) toEntity() *domain.Webhook {
+	return &domain.Webhook{
+		ID:                  entityid.ID(w.ID),
+		UserID:              entityid.ID(w.UserID),
+		OrgID:               entityid.ID(w.OrgID),
+		URL:                 w.URL,
+		EventTypes:          w.EventTypes,
+		Secret:              w.Secret,
+		ConsecutiveFailures: w.ConsecutiveFailures,
+		DisabledAt:          w.DisabledAt,
+		CreatedAt:           w.CreatedAt,
+	}
+}
+
+type webhookDelivery struct {
+	tableName struct{} `pg:"webhook_deliveries"`
+
+	ID             string          `pg:"id"`
+	WebhookID      string          `pg:"webhook_id"`
+	EventID        string          `pg:"event_id"`
+	EventType      string          `pg:"event_type"`
+	RedeliveryOf   string          `pg:"redelivery_of"`
+	Payload        json.RawMessage `pg:"payload,type:jsonb"`
+	Status         string          `pg:"status"`
+	Attempts       int             `pg:"attempts,use_zero"`
+	NextAttemptAt  *time.Time      `pg:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `pg:"last_attempt_at"`
+	ResponseStatus int             `pg:"response_status,use_zero"`
+	Error          string          `pg:"error"`
+	CreatedAt      time.Time       `pg:"created_at"`
+	DeliveredAt    *time.Time      `pg:"delivered_at"`
+}
+
+func newWebhookDelivery(d *domain.WebhookDelivery) *webhookDelivery {
+	return &webhookDelivery{
+		ID:             d.ID.String(),
+		WebhookID:      d.WebhookID.String(),
+		EventID:        d.EventID.String(),
+		EventType:      d.EventType,
+		RedeliveryOf:   d.RedeliveryOf.String(),
+		Payload:        d.Payload,
+		Status:         string(d.Status),
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastAttemptAt:  d.LastAttemptAt,
+		ResponseStatus: d.ResponseStatus,
+		Error:          d.Error,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+	}
+}
+
+func (d *webhookDelivery) toEntity() *domain.WebhookDelivery {
+	return &domain.WebhookDelivery{
+		ID:             entityid.ID(d.ID),
+		WebhookID:      entityid.ID(d.WebhookID),
+		EventID:        entityid.ID(d.EventID),
+		EventType:      d.EventType,
+		RedeliveryOf:   entityid.ID(d.RedeliveryOf),
+		Payload:        d.Payload,
+		Status:         domain.DeliveryStatus(d.Status),
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastAttemptAt:  d.LastAttemptAt,
+		ResponseStatus: d.ResponseStatus,
+		Error:          d.Error,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+	}
+}
+
+// WebhookStore keeps webhooks and the outbox of their deliveries. Deliveries
+// are written before anything is sent, so events survive restarts and failed
+// deliveries can be retried.
+type WebhookStore struct {
+	DB *pg.DB
+	// Lease is how long a claimed delivery is hidden from other dispatchers
+	// while it is being sent.
+	Lease time.Duration
+}
+
+var _ webhooks.Source = (*WebhookStore)(nil)
+
+func (ws *WebhookStore) Create(ctx context.Context, w *domain.Webhook) error {
+	_, err := ws.DB.ModelContext(ctx, newWebhook(w)).Insert()
+	return err
+}
+
+func (ws *WebhookStore) Update(ctx context.Context, w *domain.Webhook) error {
+	_, err := ws.DB.ModelContext(ctx, newWebhook(w)).WherePK().Update()
+	return err
+}
+
+// Delete deletes the webhook along with its deliveries.
+func (ws *WebhookStore) Delete(ctx context.Context, id entityid.ID) error {
+	return ws.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id.String())
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id.String())
+		return err
+	})
+}
+
+func (ws *WebhookStore) Find(ctx context.Context, id entityid.ID) (*domain.Webhook, error) {
+	row := &webhook{}
+	err := ws.DB.ModelContext(ctx, row).Where("id = ?", id.String()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+func (ws *WebhookStore) list(ctx context.Context, where string, params ...interface{}) ([]*domain.Webhook, error) {
+	var rows []*webhook
+	err := ws.DB.ModelContext(ctx, &rows).Where(where, params...).Order("created_at ASC").Select()
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]*domain.Webhook, 0, len(rows))
+	for _, row := range rows {
+		hooks = append(hooks, row.toEntity())
+	}
+	return hooks, nil
+}
+
+// List lists the user's webhooks in the organization.
+func (ws *WebhookStore) List(ctx context.Context, userID, orgID entityid.ID) ([]*domain.Webhook, error) {
+	return ws.list(ctx, "user_id = ? AND org_id = ?", userID.String(), orgID.String())
+}
+
+// Subscribed lists the user's enabled webhooks in the organization that want
+// events of the given type.
+func (ws *WebhookStore) Subscribed(ctx context.Context, userID, orgID entityid.ID, eventType string) ([]*domain.Webhook, error) {
+	return ws.list(ctx, "user_id = ? AND org_id = ? AND disabled_at IS NULL AND ? = ANY(event_types)",
+		userID.String(), orgID.String(), eventType)
+}
+
+// Enqueue adds deliveries to the outbox. A delivery of an event already
+// queued for its webhook is skipped, so events handled more than once are
+// only delivered once; redeliveries are always added.
+func (ws *WebhookStore) Enqueue(ctx context.Context, deliveries []*domain.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	rows := make([]*webhookDelivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		rows = append(rows, newWebhookDelivery(d))
+	}
+	_, err := ws.DB.ModelContext(ctx, &rows).
+		OnConflict("(webhook_id, event_id) WHERE redelivery_of IS NULL DO NOTHING").
+		Insert()
+	return err
+}
+
+func (ws *WebhookStore) FindDelivery(ctx context.Context, id entityid.ID) (*domain.WebhookDelivery, error) {
+	row := &webhookDelivery{}
+	err := ws.DB.ModelContext(ctx, row).Where("id = ?", id.String()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.toEntity(), nil
+}
+
+// ListDeliveries returns up to limit of the webhook's deliveries, newest
+// first, starting after the given delivery when after is set.
+func (ws *WebhookStore) ListDeliveries(ctx context.Context, webhookID entityid.ID, after *domain.WebhookDelivery, limit int) ([]*domain.WebhookDelivery, error) {
+	var rows []*webhookDelivery
+	query := ws.DB.ModelContext(ctx, &rows).Where("webhook_id = ?", webhookID.String())
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID.String())
+	}
+	err := query.Order("created_at DESC", "id DESC").Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*domain.WebhookDelivery, 0, len(rows))
+	for _, row := range rows {
+		deliveries = append(deliveries, row.toEntity())
+	}
+	return deliveries, nil
+}
+
+type dueDelivery struct {
+	webhookDelivery
+	WebhookURL    string `pg:"webhook_url"`
+	WebhookSecret string `pg:"webhook_secret"`
+	WebhookUserID string `pg:"webhook_user_id"`
+	WebhookOrgID  string `pg:"webhook_org_id"`
+	// Failures is the webhook's consecutive failure count.
+	Failures int `pg:"webhook_failures"`
+}
+
+// Due claims deliveries by pushing their next attempt back by Lease, skipping
+// rows another dispatcher has locked, so each delivery is sent by one
+// dispatcher at a time. A dispatcher that dies mid-send leaves the delivery
+// to be retried once the lease runs out.
+func (ws *WebhookStore) Due(ctx context.Context, now time.Time, limit int) ([]webhooks.Delivery, error) {
+	var rows []*dueDelivery
+	_, err := ws.DB.QueryContext(ctx, &rows, `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET next_attempt_at = ?
+			WHERE id IN (
+				SELECT d.id FROM webhook_deliveries d
+				JOIN webhooks w ON w.id = d.webhook_id
+				WHERE d.status = ? AND d.next_attempt_at <= ? AND w.disabled_at IS NULL
+				ORDER BY d.next_attempt_at
+				LIMIT ?
+				FOR UPDATE OF d SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT c.*, w.url AS webhook_url, w.secret AS webhook_secret,
+			w.user_id AS webhook_user_id, w.org_id AS webhook_org_id,
+			w.consecutive_failures AS webhook_failures
+		FROM claimed c
+		JOIN webhooks w ON w.id = c.webhook_id
+	`, now.Add(ws.Lease), domain.DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deliveries to the same webhook share it, so failures add up.
+	byID := make(map[string]*domain.Webhook)
+	deliveries := make([]webhooks.Delivery, 0, len(rows))
+	for _, row := range rows {
+		w, ok := byID[row.WebhookID]
+		if !ok {
+			w = &domain.Webhook{
+				ID:                  entityid.ID(row.WebhookID),
+				UserID:              entityid.ID(row.WebhookUserID),
+				OrgID:               entityid.ID(row.WebhookOrgID),
+				URL:                 row.WebhookURL,
+				Secret:              row.WebhookSecret,
+				ConsecutiveFailures: row.Failures,
+			}
+			byID[row.WebhookID] = w
+		}
+		deliveries = append(deliveries, webhooks.Delivery{Webhook: w, Delivery: row.webhookDelivery.toEntity()})
+	}
+	return deliveries, nil
+}
+
+// Record saves the outcome of a delivery attempt. The attempt count it was
+// claimed with fences off a dispatcher whose lease ran out from overwriting
+// the outcome of the one that claimed the delivery after it. Only the
+// webhook's failure count and disabled time are written, so edits made while
+// the delivery was in flight are kept.
+func (ws *WebhookStore) Record(ctx context.Context, d webhooks.Delivery) error {
+	return ws.DB.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		result, err := tx.ModelContext(ctx, newWebhookDelivery(d.Delivery)).
+			WherePK().
+			Where("status = ? AND attempts = ?", domain.DeliveryPending, d.Delivery.Attempts-1).
+			Update()
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return webhooks.ErrLeaseLost
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE webhooks SET consecutive_failures = ?, disabled_at = COALESCE(disabled_at, ?)
+			WHERE id = ?`, d.Webhook.ConsecutiveFailures, d.Webhook.DisabledAt, d.Webhook.ID.String())
+		return err
+	})
+}
+
+// PurgeWebhookDeliveries deletes finished deliveries created before the given
+// time. It returns how many were removed.
+func PurgeWebhookDeliveries(ctx context.Context, db *pg.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status != ? AND created_at < ?`, domain.DeliveryPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/events"
+	"github.com/go-chi/chi"
+)
+
+var eventBroker events.Broker = events.NewMemoryBroker(256, time.Hour)
+
+var eventsHeartbeatInterval = 15 * time.Second
+
+// todoEventType classifies a todo change for clients, which see completing a
+// todo as an update.
+func todoEventType(before, after *domain.TodoSnapshot) string {
+	return domain.TodoEventTypes(before, after)[0]
+}
+
+// publishTodoEvent tells the owner's listening clients about a change to one
+// of their todos, along with the clients of everyone the todo's project is
+// shared with. Deleted events only carry the todo's ID. Webhooks hear about
+// changes from the outbox instead, see subscribeWebhooks.
+func publishTodoEvent(owner *domain.User, eventType string, todo *domain.Todo) {
+	var data interface{} = todo
+	if eventType == events.TodoDeleted {
+		data = struct {
+			ID entityid.ID `json:"id"`
+		}{todo.ID}
+	}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// Members see projects in the owner's personal organization from their
+	// own personal organization.
+	orgID := owner.AllProjects().FindByID(todo.ProjectID).OrgID
+	orgIDs := map[entityid.ID]entityid.ID{owner.ID: orgID}
+	if shareStore != nil && todo.ProjectID != "" {
+		members, err := shareStore.MemberUserIDs(context.Background(), todo.ProjectID)
+		if err != nil {
+			log.Print(err)
+		}
+		for _, member := range members {
+			orgIDs[member] = orgID
+			if orgID == owner.ID {
+				orgIDs[member] = member
+			}
+		}
+	}
+
+	id := entityid.Generator.Generate().String()
+	for userID, orgID := range orgIDs {
+		err = eventBroker.Publish(context.Background(), events.Event{
+			ID:        id,
+			UserID:    userID,
+			OrgID:     orgID,
+			ProjectID: todo.ProjectID,
+			Type:      eventType,
+			Data:      bytes,
+		})
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func registerEventRoutes(todosRouter chi.Router) {
+	todosRouter.Get("/events", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Streaming is not supported"}},
+			})
+			return
+		}
+
+		// EventSource sends Last-Event-ID when it reconnects; the query
+		// parameter lets a fresh connection resume too.
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("lastEventId")
+		}
+		subscription := eventBroker.Subscribe(user.ID, lastEventID)
+		defer subscription.Close()
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("Connection", "keep-alive")
+		rw.WriteHeader(http.StatusOK)
+
+		if subscription.Reset {
+			// Events were missed, so the client has to reload its todos.
+			fmt.Fprint(rw, "event: reset\ndata: {}\n\n")
+		}
+		for _, event := range subscription.Replay {
+			if inEventOrg(user, event) {
+				writeEvent(rw, event)
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(rw, ": ping\n\n")
+			case event, ok := <-subscription.Events:
+				if !ok {
+					// The client fell behind; it reconnects and resumes from
+					// the last event it received.
+					return
+				}
+				if inEventOrg(user, event) {
+					writeEvent(rw, event)
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// inEventOrg reports whether the event is for the organization the user is
+// signed in to.
+func inEventOrg(user *domain.User, event events.Event) bool {
+	return event.OrgID == "" || event.OrgID == user.OrgID
+}
+
+func writeEvent(rw http.ResponseWriter, event events.Event) {
+	data := event.Data
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	fmt.Fprintf(rw, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
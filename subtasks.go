@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+// maxSubtaskDepth is how deeply subtasks may be nested below a top level todo.
+var maxSubtaskDepth = 3
+
+type todoTreeNode struct {
+	*domain.Todo
+	Subtasks []*todoTreeNode `json:"subtasks"`
+}
+
+// todoTree nests todos under their parents. Todos whose parent isn't in the
+// given list are treated as roots so filtered lists still render.
+func todoTree(todos domain.Todos) []*todoTreeNode {
+	nodes := make(map[entityid.ID]*todoTreeNode, len(todos))
+	for _, todo := range todos {
+		nodes[todo.ID] = &todoTreeNode{Todo: todo, Subtasks: make([]*todoTreeNode, 0)}
+	}
+
+	var roots = make([]*todoTreeNode, 0)
+	for _, todo := range todos {
+		if _, ok := nodes[todo.ParentID]; !ok {
+			roots = append(roots, nodes[todo.ID])
+		}
+	}
+	for _, root := range roots {
+		attachSubtasks(root, todos, nodes)
+	}
+	return roots
+}
+
+func attachSubtasks(node *todoTreeNode, todos domain.Todos, nodes map[entityid.ID]*todoTreeNode) {
+	for _, child := range todos.Children(node.ID) {
+		childNode := nodes[child.ID]
+		node.Subtasks = append(node.Subtasks, childNode)
+		attachSubtasks(childNode, todos, nodes)
+	}
+}
+
+func validateTodoParent(user *domain.User, todo *domain.Todo, parentID entityid.ID) []ErrorResponseError {
+	if parentID != "" && user.FindTodo(parentID).ID == "" {
+		return []ErrorResponseError{{Message: domain.ErrParentNotFound.Error(), Field: "parentId"}}
+	}
+	if err := user.Todos.ValidateParent(todo, parentID, maxSubtaskDepth); err != nil {
+		return []ErrorResponseError{{Message: err.Error(), Field: "parentId"}}
+	}
+	return nil
+}
+
+// marshalTodoList renders a list of todos with their comment counts, nesting
+// subtasks when the request asks for ?view=tree.
+func marshalTodoList(r *http.Request, user *domain.User, todos domain.Todos) ([]byte, error) {
+	user.Todos.ComputeProgress()
+	if commentStore != nil {
+		ids := make([]entityid.ID, 0, len(todos))
+		for _, todo := range todos {
+			ids = append(ids, todo.ID)
+		}
+		counts, err := commentStore.Counts(context.Background(), ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, todo := range todos {
+			count := counts[todo.ID]
+			todo.CommentCount = &count
+		}
+	}
+	todos.SortByPosition()
+	if r.URL.Query().Get("view") == "tree" {
+		return json.Marshal(todoTree(todos))
+	}
+	return json.Marshal(todos)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var syncStore *storage.SyncStore
+
+// syncTokenLifetime is how long a sync token stays usable. Older tokens may
+// refer to changes that have been purged from the change log.
+var syncTokenLifetime = 30 * 24 * time.Hour
+
+var errSyncTokenExpired = errors.New("sync token has expired")
+
+// todoSyncFields are the todo fields a client may change through sync.
+var todoSyncFields = map[string]bool{
+	"title":           true,
+	"description":     true,
+	"completed":       true,
+	"priority":        true,
+	"dueAt":           true,
+	"allDay":          true,
+	"reminderOffsets": true,
+	"recurrence":      true,
+	"projectId":       true,
+	"parentId":        true,
+	"assigneeId":      true,
+	"tags":            true,
+}
+
+// syncToken is the opaque token handed to clients. It records the position in
+// the change log the client has seen, see storage.SyncStore, and when it was
+// issued.
+type syncToken struct {
+	Position int64
+	IssuedAt time.Time
+}
+
+func (st syncToken) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("p%d.%d", st.Position, st.IssuedAt.Unix())))
+}
+
+func parseSyncToken(s string, now time.Time) (syncToken, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return syncToken{}, errors.New("invalid sync token")
+	}
+	var position, issuedAt int64
+	if _, err := fmt.Sscanf(string(decoded), "p%d.%d", &position, &issuedAt); err != nil {
+		// Tokens from before positions were transactions held a sequence
+		// number; their clients have to start over.
+		if _, err := fmt.Sscanf(string(decoded), "%d.%d", &position, &issuedAt); err == nil {
+			return syncToken{}, errSyncTokenExpired
+		}
+		return syncToken{}, errors.New("invalid sync token")
+	}
+	token := syncToken{Position: position, IssuedAt: time.Unix(issuedAt, 0)}
+	if now.Sub(token.IssuedAt) > syncTokenLifetime {
+		return syncToken{}, errSyncTokenExpired
+	}
+	return token, nil
+}
+
+type syncTombstone struct {
+	ID entityid.ID `json:"id"`
+}
+
+type syncResponse struct {
+	Token string `json:"token"`
+	// Todos are the todos created or changed since the request's token,
+	// including ones moved to the trash.
+	Todos domain.Todos `json:"todos"`
+	// Deleted are the todos permanently deleted since the request's token.
+	Deleted []syncTombstone `json:"deleted"`
+	Results []*syncResult   `json:"results,omitempty"`
+}
+
+type syncInput struct {
+	Token   string       `json:"token"`
+	Changes []syncChange `json:"changes"`
+}
+
+// syncChange is a change a client made while offline. A change without an ID
+// creates a todo; the client can match it up by ClientID.
+type syncChange struct {
+	ID       entityid.ID `json:"id"`
+	ClientID string      `json:"clientId"`
+	// BaseVersion is the version of the todo the client changed.
+	BaseVersion int                        `json:"baseVersion"`
+	ChangedAt   *time.Time                 `json:"changedAt"`
+	Deleted     bool                       `json:"deleted"`
+	Fields      map[string]json.RawMessage `json:"fields"`
+}
+
+type syncResult struct {
+	ID        entityid.ID            `json:"id"`
+	ClientID  string                 `json:"clientId,omitempty"`
+	Status    int                    `json:"status"`
+	Conflicts []domain.FieldConflict `json:"conflicts,omitempty"`
+	Errors    []ErrorResponseError   `json:"errors,omitempty"`
+}
+
+func registerSyncRoutes(todosRouter chi.Router) {
+	todosRouter.Get("/sync", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		respondSync(rw, r, user, r.URL.Query().Get("token"), nil)
+	})
+	todosRouter.Post("/sync", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var input syncInput
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if input.Token != "" {
+			if _, err := parseSyncToken(input.Token, time.Now()); err != nil {
+				respondSyncTokenError(rw, err)
+				return
+			}
+		}
+
+		histories, err := syncChangeHistories(user, input.Changes)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		var results []*syncResult
+		for _, change := range input.Changes {
+			results = append(results, applySyncChange(r, user, change, histories[change.ID]))
+		}
+
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		respondSync(rw, r, user, input.Token, results)
+	})
+}
+
+// syncChangeHistories loads the history of the todos that changes were made
+// to on an older version than the current one, to find conflicting fields.
+func syncChangeHistories(user *domain.User, changes []syncChange) (map[entityid.ID][]*domain.HistoryEntry, error) {
+	histories := make(map[entityid.ID][]*domain.HistoryEntry)
+	for _, change := range changes {
+		todo := user.FindTodo(change.ID)
+		if todo.ID == "" || change.BaseVersion >= todo.Version {
+			continue
+		}
+		entries, err := historyStore.List(context.Background(), todo.ID)
+		if err != nil {
+			return nil, err
+		}
+		histories[todo.ID] = entries
+	}
+	return histories, nil
+}
+
+// applySyncChange applies a client's change to the user's todos without
+// saving them. Fields changed both by the client and on the server since the
+// change's base version go to whichever change was made last.
+func applySyncChange(r *http.Request, user *domain.User, change syncChange, history []*domain.HistoryEntry) *syncResult {
+	result := &syncResult{ID: change.ID, ClientID: change.ClientID}
+	fail := func(status int, errors ...ErrorResponseError) *syncResult {
+		result.Status = status
+		result.Errors = errors
+		return result
+	}
+	changedAt := time.Now()
+	if change.ChangedAt != nil && change.ChangedAt.Before(changedAt) {
+		changedAt = *change.ChangedAt
+	}
+
+	var fields []string
+	for field := range change.Fields {
+		if !todoSyncFields[field] {
+			return fail(http.StatusBadRequest, ErrorResponseError{Message: "field can't be synced", Field: field})
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	if change.ID == "" {
+		todo := &domain.Todo{}
+		if err := decodeSyncFields(change.Fields, fields, todo); err != nil {
+			return fail(http.StatusBadRequest, ErrorResponseError{Message: err.Error(), Field: "fields"})
+		}
+		if errors := createTodo(user, todo); len(errors) > 0 {
+			return fail(http.StatusBadRequest, errors...)
+		}
+		result.ID = todo.ID
+		result.Status = http.StatusCreated
+		return result
+	}
+
+	todo := user.FindTodo(change.ID)
+	if todo.ID == "" {
+		return fail(http.StatusNotFound, ErrorResponseError{Message: "Todo not found", Field: "id"})
+	}
+
+	if change.Deleted {
+		// A delete loses to any change made on the server after it.
+		if change.BaseVersion < todo.Version && todo.UpdatedAt.After(changedAt) {
+			result.Status = http.StatusConflict
+			result.Conflicts = []domain.FieldConflict{{Field: "deletedAt", Winner: domain.SyncWinnerServer, ServerChangedAt: todo.UpdatedAt}}
+			return result
+		}
+		user.TrashTodo(todo.ID, time.Now())
+		result.Status = http.StatusNoContent
+		return result
+	}
+
+	apply, conflicts := domain.ResolveFieldConflicts(fields, change.BaseVersion, changedAt, history)
+	result.Conflicts = conflicts
+	updatedTodo := editableTodo(todo)
+	if err := decodeSyncFields(change.Fields, apply, updatedTodo); err != nil {
+		return fail(http.StatusBadRequest, ErrorResponseError{Message: err.Error(), Field: "fields"})
+	}
+	if status, errors := updateTodo(r, user, todo, updatedTodo); len(errors) > 0 {
+		return fail(status, errors...)
+	}
+	result.Status = http.StatusOK
+	return result
+}
+
+func decodeSyncFields(raw map[string]json.RawMessage, fields []string, todo *domain.Todo) error {
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		selected[field] = raw[field]
+	}
+	encoded, err := json.Marshal(selected)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, todo)
+}
+
+// respondSync responds with the todos changed since token, or all of them when
+// token is empty, and a new token to sync from next time.
+func respondSync(rw http.ResponseWriter, r *http.Request, user *domain.User, token string, results []*syncResult) {
+	now := time.Now()
+	response := syncResponse{
+		Todos:   make(domain.Todos, 0),
+		Deleted: make([]syncTombstone, 0),
+		Results: results,
+	}
+
+	var changes []storage.TodoChange
+	var position int64
+	var err error
+	if token == "" {
+		position, err = syncStore.Position(r.Context())
+	} else {
+		var since syncToken
+		since, err = parseSyncToken(token, now)
+		if err != nil {
+			respondSyncTokenError(rw, err)
+			return
+		}
+		changes, position, err = syncStore.Changes(r.Context(), user.ID, user.OrgID, since.Position)
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	// Read the todos after the change log, so nothing logged before position
+	// is missing from them. Anything changed in between is sent again next time.
+	current, err := storage.FindUserInOrg(r.Context(), db, user.ID, user.OrgID)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+	todos, deleted := syncedTodos(current, user.OrgID, changes, token == "")
+	response.Todos = append(response.Todos, todos...)
+	response.Deleted = append(response.Deleted, deleted...)
+	response.Todos.SortByPosition()
+	response.Token = syncToken{Position: position, IssuedAt: now}.String()
+
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(bytes)
+}
+
+// syncedTodos returns the todos to send for the changes, or every todo for a
+// full sync, from the user's current todos in the organization. Changed todos
+// the user no longer has there, including ones moved to another organization,
+// are sent as deleted.
+func syncedTodos(current *domain.User, orgID entityid.ID, changes []storage.TodoChange, full bool) (domain.Todos, []syncTombstone) {
+	current.ScopeToOrg(orgID)
+	current.Todos.ComputeProgress()
+
+	var todos domain.Todos
+	var deleted []syncTombstone
+	if full {
+		todos = append(todos, current.Todos...)
+	}
+	for _, change := range changes {
+		todo := current.Todos.FindByID(change.TodoID)
+		if todo.ID == "" {
+			deleted = append(deleted, syncTombstone{ID: change.TodoID})
+			continue
+		}
+		todos = append(todos, todo)
+	}
+	return todos, deleted
+}
+
+func respondSyncTokenError(rw http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if err == errSyncTokenExpired {
+		// The client has to start over with a full sync.
+		status = http.StatusGone
+	}
+	respondError(rw, status, ErrorResponse{
+		Errors: []ErrorResponseError{{Message: err.Error(), Field: "token"}},
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/storage"
+)
+
+func newTwoOrgUser() *domain.User {
+	return &domain.User{
+		ID: "user",
+		Projects: domain.Projects{
+			{ID: "a-inbox", OrgID: "a"},
+			{ID: "b-inbox", OrgID: "b"},
+		},
+		Todos: domain.Todos{
+			{ID: "a-1", ProjectID: "a-inbox"},
+			{ID: "b-1", ProjectID: "b-inbox"},
+		},
+	}
+}
+
+func Test_syncedTodos(t *testing.T) {
+	t.Run("a full sync only sends the organization's todos", func(t *testing.T) {
+		todos, deleted := syncedTodos(newTwoOrgUser(), "a", nil, true)
+		if len(todos) != 1 || todos[0].ID != "a-1" {
+			t.Errorf("syncedTodos() todos = %v, expected only a-1", todos)
+		}
+		if len(deleted) != 0 {
+			t.Errorf("syncedTodos() deleted = %v, expected none", deleted)
+		}
+	})
+	t.Run("another organization's todo is sent as deleted", func(t *testing.T) {
+		changes := []storage.TodoChange{{TodoID: "a-1"}, {TodoID: "b-1"}}
+		todos, deleted := syncedTodos(newTwoOrgUser(), "a", changes, false)
+		if len(todos) != 1 || todos[0].ID != "a-1" {
+			t.Errorf("syncedTodos() todos = %v, expected only a-1", todos)
+		}
+		if len(deleted) != 1 || deleted[0].ID != "b-1" {
+			t.Errorf("syncedTodos() deleted = %v, expected b-1", deleted)
+		}
+	})
+}
+
+func Test_parseSyncToken(t *testing.T) {
+	now := time.Unix(1630000000, 0)
+
+	token, err := parseSyncToken(syncToken{Position: 42, IssuedAt: now}.String(), now)
+	if err != nil || token.Position != 42 || !token.IssuedAt.Equal(now) {
+		t.Errorf("parseSyncToken() = %+v, %v, expected position 42 issued at %v", token, err, now)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"expires old tokens", syncToken{Position: 42, IssuedAt: now.Add(-syncTokenLifetime - time.Hour)}.String(), errSyncTokenExpired},
+		{"expires sequence number tokens", base64.RawURLEncoding.EncodeToString([]byte("42.1630000000")), errSyncTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseSyncToken(tt.token, now); err != tt.expected {
+				t.Errorf("parseSyncToken() error = %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+	t.Run("rejects garbage", func(t *testing.T) {
+		if _, err := parseSyncToken("not a token", now); err == nil || err == errSyncTokenExpired {
+			t.Errorf("parseSyncToken() error = %v, expected an invalid token error", err)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-chi/chi"
+)
+
+const defaultTagColor = "#9e9e9e"
+
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type tagInput struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// validateTag checks a tag after input has been applied. Names must be unique
+// per user, ignoring case.
+func validateTag(user *domain.User, tag *domain.Tag) []ErrorResponseError {
+	var errors []ErrorResponseError
+	if tag.Name == "" || len(tag.Name) > 50 {
+		errors = append(errors, ErrorResponseError{Message: "name must be between 1 and 50 characters", Field: "name"})
+	} else if existing := user.Tags.FindByName(tag.Name); existing.ID != "" && existing.ID != tag.ID {
+		errors = append(errors, ErrorResponseError{Message: "a tag with this name already exists", Field: "name"})
+	}
+	if !tagColorPattern.MatchString(tag.Color) {
+		errors = append(errors, ErrorResponseError{Message: "color must be a hex color like #ff0000", Field: "color"})
+	}
+	return errors
+}
+
+func tagsRouter(tagsRouter chi.Router) {
+	tagsRouter.Use(authenticate)
+	tagsRouter.Use(idempotent)
+
+	tagsRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+		var tags = make(domain.Tags, 0)
+		tags = append(tags, user.Tags...)
+		bytes, err := json.Marshal(tags)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	tagsRouter.Post("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var input = tagInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		tag := &domain.Tag{
+			ID:        entityid.Generator.Generate(),
+			Color:     defaultTagColor,
+			CreatedAt: time.Now(),
+		}
+		if input.Name != nil {
+			tag.Name = strings.TrimSpace(*input.Name)
+		}
+		if input.Color != nil {
+			tag.Color = *input.Color
+		}
+		if errors := validateTag(user, tag); len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		user.Tags = append(user.Tags, tag)
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		bytes, err := json.Marshal(tag)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+	tagsRouter.Put("/{tagID}", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		tag := user.Tags.FindByID(entityid.ID(chi.URLParam(r, "tagID")))
+		if tag.ID == "" {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Tag not found"}},
+			})
+			return
+		}
+
+		var input = tagInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		// Todos reference the tag itself, so renaming it here updates every
+		// todo it is attached to.
+		updatedTag := *tag
+		if input.Name != nil {
+			updatedTag.Name = strings.TrimSpace(*input.Name)
+		}
+		if input.Color != nil {
+			updatedTag.Color = *input.Color
+		}
+		if errors := validateTag(user, &updatedTag); len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+		*tag = updatedTag
+
+		err = saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		bytes, err := json.Marshal(tag)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	tagsRouter.Delete("/{tagID}", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		if !user.DeleteTag(entityid.ID(chi.URLParam(r, "tagID"))) {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Tag not found"}},
+			})
+			return
+		}
+
+		err := saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// resolveTags swaps tags decoded from a todo request body for the user's own
+// tags, matched by ID.
+func resolveTags(user *domain.User, tags domain.Tags) (domain.Tags, []ErrorResponseError) {
+	var resolved domain.Tags
+	for _, t := range tags {
+		tag := user.Tags.FindByID(t.ID)
+		if tag.ID == "" {
+			return nil, []ErrorResponseError{{Message: "unknown tag " + t.ID.String(), Field: "tags"}}
+		}
+		if resolved.FindIndexByID(tag.ID) == -1 {
+			resolved = append(resolved, tag)
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func Test_validateTag(t *testing.T) {
+	user := &domain.User{Tags: domain.Tags{{ID: "work", Name: "Work", Color: defaultTagColor}}}
+
+	tests := []struct {
+		name   string
+		tag    *domain.Tag
+		fields []string
+	}{
+		{"accepts a new tag", &domain.Tag{ID: "home", Name: "Home", Color: "#ff0000"}, nil},
+		{"accepts renaming a tag to its own name", &domain.Tag{ID: "work", Name: "WORK", Color: "#ff0000"}, nil},
+		{"rejects an empty name", &domain.Tag{ID: "home", Color: "#ff0000"}, []string{"name"}},
+		{"rejects a name in use, ignoring case", &domain.Tag{ID: "home", Name: "work", Color: "#ff0000"}, []string{"name"}},
+		{"rejects colors that aren't hex", &domain.Tag{ID: "home", Name: "Home", Color: "red"}, []string{"color"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errors := validateTag(user, tt.tag)
+			if len(errors) != len(tt.fields) {
+				t.Fatalf("validateTag() = %v, expected errors for %v", errors, tt.fields)
+			}
+			for i, err := range errors {
+				if err.Field != tt.fields[i] {
+					t.Errorf("validateTag() error %d field = %s, expected %s", i, err.Field, tt.fields[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_todoListFilters_tagsAndPriorities(t *testing.T) {
+	work, home := &domain.Tag{ID: "work", Name: "Work"}, &domain.Tag{ID: "home", Name: "Home"}
+	user := &domain.User{
+		ID:   "u",
+		Tags: domain.Tags{work, home},
+		Todos: domain.Todos{
+			{ID: "a", Priority: domain.PriorityHigh, Tags: domain.Tags{work}},
+			{ID: "b", Priority: domain.PriorityLow, Tags: domain.Tags{work, home}},
+			{ID: "c", Tags: domain.Tags{home}},
+		},
+	}
+
+	tests := []struct {
+		query    string
+		expected []entityid.ID
+		errors   int
+	}{
+		{"tag=work", []entityid.ID{"a", "b"}, 0},
+		{"tag=WORK", []entityid.ID{"a", "b"}, 0},
+		{"tag=work&tag=home", []entityid.ID{"b"}, 0},
+		{"tag=unknown", nil, 0},
+		{"priority=high", []entityid.ID{"a"}, 0},
+		{"priority=high,none", []entityid.ID{"a", "c"}, 0},
+		{"tag=work&priority=low", []entityid.ID{"b"}, 0},
+		{"priority=urgent", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/todos?"+tt.query, nil)
+			r = requestSetUser(r, user)
+
+			filters, errors := todoListFilters(r, user)
+			if len(errors) != tt.errors {
+				t.Fatalf("todoListFilters() errors = %v, expected %d", errors, tt.errors)
+			}
+			if tt.errors > 0 {
+				return
+			}
+			var actual []entityid.ID
+			for _, todo := range user.Todos {
+				kept := true
+				for _, keep := range filters {
+					kept = kept && keep(todo)
+				}
+				if kept {
+					actual = append(actual, todo.ID)
+				}
+			}
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("filtered todos = %v, expected %v", actual, tt.expected)
+			}
+			for i := range actual {
+				if actual[i] != tt.expected[i] {
+					t.Errorf("filtered todos = %v, expected %v", actual, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
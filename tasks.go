@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DillonStreator/todos/cron"
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/jobs"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/go-chi/chi"
+)
+
+var cronScheduler *cron.Scheduler
+var cronStore *storage.CronStore
+
+// runTaskJob runs a scheduled task on demand, so a manual run happens on a
+// worker whichever replica it was triggered on.
+const runTaskJob = "cron.run"
+
+type runTaskPayload struct {
+	Task string `json:"task"`
+}
+
+type taskResponse struct {
+	Name      string    `json:"name"`
+	Schedule  string    `json:"schedule"`
+	NextRunAt time.Time `json:"nextRunAt"`
+	LastRun   *cron.Run `json:"lastRun"`
+}
+
+// getEnvSchedule reads a task's schedule from key. Deployments configured
+// before the scheduler existed set an interval in legacyKey instead, which is
+// still honoured.
+func getEnvSchedule(key, legacyKey, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	if interval, ok := os.LookupEnv(legacyKey); ok {
+		return "@every " + interval
+	}
+	return defaultValue
+}
+
+// registerCronTasks adds the recurring maintenance tasks to the scheduler.
+// The hourly ones are spread over the hour so they don't all start at once.
+func registerCronTasks(scheduler *cron.Scheduler) {
+	trashRetention := getEnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+	webhookDeliveryRetention := getEnvDuration("WEBHOOK_DELIVERY_RETENTION", 30*24*time.Hour)
+	domainEventRetention := getEnvDuration("OUTBOX_RETENTION", 7*24*time.Hour)
+	jobRetention := getEnvDuration("JOB_RETENTION", 14*24*time.Hour)
+	cronRunRetention := getEnvDuration("CRON_RUN_RETENTION", 30*24*time.Hour)
+
+	tasks := []struct {
+		name     string
+		schedule string
+		run      func(ctx context.Context) error
+	}{
+		{"purge-trash", getEnvSchedule("TRASH_PURGE_SCHEDULE", "TRASH_PURGE_INTERVAL", "10 * * * *"), func(ctx context.Context) error {
+			return purgeTrash(ctx, time.Now().Add(-trashRetention))
+		}},
+		{"auto-archive", getEnvSchedule("AUTO_ARCHIVE_SCHEDULE", "AUTO_ARCHIVE_INTERVAL", "20 * * * *"), func(ctx context.Context) error {
+			return autoArchive(ctx, time.Now())
+		}},
+		{"purge-sync-changes", getEnvSchedule("SYNC_CHANGES_PURGE_SCHEDULE", "SYNC_CHANGES_PURGE_INTERVAL", "30 * * * *"), func(ctx context.Context) error {
+			_, err := storage.PurgeTodoChanges(ctx, allOrgsDB, time.Now().Add(-syncTokenLifetime))
+			return err
+		}},
+		{"purge-idempotency-keys", getEnvSchedule("IDEMPOTENCY_PURGE_SCHEDULE", "IDEMPOTENCY_PURGE_INTERVAL", "40 * * * *"), func(ctx context.Context) error {
+			_, err := storage.PurgeIdempotencyKeys(ctx, db, time.Now().Add(-idempotencyKeyTTL))
+			return err
+		}},
+		{"cleanup-attachments", getEnvSchedule("ATTACHMENT_CLEANUP_SCHEDULE", "ATTACHMENT_CLEANUP_INTERVAL", "50 * * * *"), cleanupAttachments},
+		{"purge-webhook-deliveries", getEnvSchedule("WEBHOOK_DELIVERY_PURGE_SCHEDULE", "WEBHOOK_DELIVERY_PURGE_INTERVAL", "15 * * * *"), func(ctx context.Context) error {
+			_, err := storage.PurgeWebhookDeliveries(ctx, db, time.Now().Add(-webhookDeliveryRetention))
+			return err
+		}},
+		{"purge-domain-events", getEnvSchedule("OUTBOX_PURGE_SCHEDULE", "OUTBOX_PURGE_INTERVAL", "25 * * * *"), func(ctx context.Context) error {
+			_, err := storage.PurgeDomainEvents(ctx, db, time.Now().Add(-domainEventRetention))
+			return err
+		}},
+		{"purge-jobs", getEnvSchedule("JOB_PURGE_SCHEDULE", "JOB_PURGE_INTERVAL", "35 * * * *"), func(ctx context.Context) error {
+			_, err := storage.PurgeJobs(ctx, db, time.Now().Add(-jobRetention))
+			return err
+		}},
+		{"purge-cron-runs", getEnv("CRON_RUN_PURGE_SCHEDULE", "45 3 * * *"), func(ctx context.Context) error {
+			_, err := storage.PurgeCronRuns(ctx, db, time.Now().Add(-cronRunRetention))
+			return err
+		}},
+	}
+	for _, task := range tasks {
+		if err := scheduler.Add(task.name, task.schedule, task.run); err != nil {
+			log.Fatalf("invalid schedule for %s: %s", task.name, err)
+		}
+	}
+}
+
+// purgeTrash removes the todos each user has had in the trash since before
+// the given time through their aggregate, so the removal is recorded in
+// history and raises events like emptying the trash by hand does.
+func purgeTrash(ctx context.Context, before time.Time) error {
+	userIDs, err := storage.PurgeTrashUsers(ctx, allOrgsDB, before)
+	if err != nil {
+		return err
+	}
+
+	purged := 0
+	for _, userID := range userIDs {
+		var count int
+		err := changeUser(userID, userID, func(user *domain.User) bool {
+			count = user.PurgeTrash(before)
+			return count > 0
+		})
+		if err != nil {
+			log.Printf("purging the trash of %s: %s", userID, err)
+			continue
+		}
+		purged += count
+	}
+	if purged > 0 {
+		log.Printf("purged %d todos from the trash", purged)
+	}
+	return nil
+}
+
+// autoArchive archives the todos of each user due for it through their
+// aggregate, so the archiving is recorded in history and raises events like
+// archiving by hand does.
+func autoArchive(ctx context.Context, now time.Time) error {
+	userIDs, err := storage.AutoArchiveUsers(ctx, allOrgsDB, now)
+	if err != nil {
+		return err
+	}
+
+	archived := 0
+	for _, userID := range userIDs {
+		var todos domain.Todos
+		err := changeUser(userID, userID, func(user *domain.User) bool {
+			todos = user.AutoArchive(now)
+			return len(todos) > 0
+		})
+		if err != nil {
+			log.Printf("auto-archiving todos of %s: %s", userID, err)
+			continue
+		}
+		archived += len(todos)
+	}
+	if archived > 0 {
+		log.Printf("auto-archived %d completed todos", archived)
+	}
+	return nil
+}
+
+// runTask runs a task triggered by hand.
+func runTask(ctx context.Context, job *jobs.Job) error {
+	var payload runTaskPayload
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+	run, err := cronScheduler.RunNow(ctx, payload.Task)
+	if err == cron.ErrUnknownTask {
+		// The task was removed since it was triggered; retrying won't help.
+		log.Printf("cron: triggered unknown task %s", payload.Task)
+		return nil
+	}
+	if err != nil {
+		// Including ErrTaskRunning: the job is retried after the run that's
+		// in progress.
+		return err
+	}
+	if run != nil && run.Error != "" {
+		log.Printf("cron: %s: %s", run.Task, run.Error)
+	}
+	return nil
+}
+
+func registerTaskRoutes(adminRouter chi.Router) {
+	adminRouter.Get("/tasks", func(rw http.ResponseWriter, r *http.Request) {
+		lastRuns, err := cronStore.LastRuns(context.Background())
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		now := time.Now()
+		tasks := cronScheduler.Tasks()
+		response := make([]taskResponse, 0, len(tasks))
+		for _, task := range tasks {
+			response = append(response, taskResponse{
+				Name:      task.Name,
+				Schedule:  task.Spec,
+				NextRunAt: cronScheduler.NextRun(task, now),
+				LastRun:   lastRuns[task.Name],
+			})
+		}
+
+		bytes, err := json.Marshal(response)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	adminRouter.Get("/tasks/{taskName}/runs", func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := findTask(rw, r)
+		if !ok {
+			return
+		}
+
+		limit := 50
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			var err error
+			limit, err = strconv.Atoi(limitParam)
+			if err != nil || limit < 1 || limit > 200 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "limit must be between 1 and 200", Field: "limit"}},
+				})
+				return
+			}
+		}
+
+		runs, err := cronStore.ListRuns(context.Background(), task.Name, limit)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(runs)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	// Triggering queues the run for a worker and returns the job, which can
+	// be followed under /admin/jobs.
+	adminRouter.Post("/tasks/{taskName}/run", func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := findTask(rw, r)
+		if !ok {
+			return
+		}
+
+		job, err := jobs.New(runTaskJob, runTaskPayload{Task: task.Name}, time.Now())
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		job.UniqueKey = task.Name
+		queued, err := jobStore.Enqueue(context.Background(), job)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		if !queued {
+			respondError(rw, http.StatusConflict, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "A run of this task is already queued"}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(job)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusAccepted)
+		rw.Write(bytes)
+	})
+}
+
+// findTask looks up the task in the URL, responding with 404 if there is none.
+func findTask(rw http.ResponseWriter, r *http.Request) (*cron.Task, bool) {
+	task := cronScheduler.Task(chi.URLParam(r, "taskName"))
+	if task == nil {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Task not found"}},
+		})
+		return nil, false
+	}
+	return task, true
+}
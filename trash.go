@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/go-chi/chi"
+)
+
+func registerTrashRoutes(todosRouter chi.Router) {
+	todosRouter.Get("/trash", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var todos = make(domain.Todos, 0)
+		todos = append(todos, user.Todos.Trashed()...)
+		bytes, err := marshalTodoList(r, user, todos)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	todosRouter.Delete("/trash", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		for _, todo := range user.Todos.Trashed() {
+			user.RemoveTodo(todo.ID)
+		}
+		err := saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	todosRouter.Delete("/trash/{todoID}", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		if todo := user.Todos.FindByID(todoID); !todo.Deleted() {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found in trash"}},
+			})
+			return
+		}
+
+		user.RemoveTodo(todoID)
+		err := saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	todosRouter.Post("/{todoID}/restore", func(rw http.ResponseWriter, r *http.Request) {
+		todoID := entityid.ID(chi.URLParam(r, "todoID"))
+		user, ok := todoOwner(rw, r, todoID, domain.PermissionEdit)
+		if !ok {
+			return
+		}
+		if !user.RestoreTodo(todoID, time.Now()) {
+			respondError(rw, http.StatusNotFound, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "Todo not found in trash"}},
+			})
+			return
+		}
+		todo := user.FindTodo(todoID)
+		todo.UpdatedAt = time.Now()
+
+		err := saveUser(r, user)
+		if err != nil {
+			respondSaveError(rw, r, err)
+			return
+		}
+
+		user.Todos.ComputeProgress()
+		bytes, err := json.Marshal(todo)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/outbox"
+	"github.com/DillonStreator/todos/storage"
+	"github.com/DillonStreator/todos/webhooks"
+	"github.com/go-chi/chi"
+)
+
+var webhookStore *storage.WebhookStore
+
+// webhookTimeout limits how long a delivery may take.
+var webhookTimeout = 10 * time.Second
+
+// webhookBatchSize is how many deliveries a dispatcher claims at a time.
+var webhookBatchSize = 10
+
+// webhookEventTypes are the events webhooks can subscribe to.
+var webhookEventTypes = []string{domain.EventTodoCreated, domain.EventTodoUpdated, domain.EventTodoCompleted, domain.EventTodoDeleted}
+
+type webhookInput struct {
+	URL        *string   `json:"url"`
+	EventTypes *[]string `json:"eventTypes"`
+	Enabled    *bool     `json:"enabled"`
+}
+
+type webhookResponse struct {
+	*domain.Webhook
+	// Secret is only returned when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+type webhookDeliveriesResponse struct {
+	Deliveries []*domain.WebhookDelivery `json:"deliveries"`
+	// NextCursor is passed back as ?cursor to get the next page. It is empty
+	// on the last page.
+	NextCursor entityid.ID `json:"nextCursor"`
+}
+
+// webhookPayload is the body POSTed to webhooks.
+type webhookPayload struct {
+	ID        entityid.ID     `json:"id"`
+	Type      string          `json:"type"`
+	OrgID     entityid.ID     `json:"orgId"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// webhookResolver resolves webhook hosts to check they are public.
+var webhookResolver webhooks.Resolver = net.DefaultResolver
+
+func validateWebhook(webhook *domain.Webhook) []ErrorResponseError {
+	var errors []ErrorResponseError
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || len(webhook.URL) > 2000 {
+		errors = append(errors, ErrorResponseError{Message: "must be an http or https URL", Field: "url"})
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := webhooks.CheckHost(ctx, webhookResolver, u.Hostname())
+		cancel()
+		if err == webhooks.ErrNonPublicAddress {
+			errors = append(errors, ErrorResponseError{Message: "must not point to a private or internal address", Field: "url"})
+		} else if err != nil {
+			errors = append(errors, ErrorResponseError{Message: "host could not be resolved", Field: "url"})
+		}
+	}
+	if len(webhook.EventTypes) == 0 {
+		errors = append(errors, ErrorResponseError{Message: "must subscribe to at least one event type", Field: "eventTypes"})
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !containsString(webhookEventTypes, eventType) {
+			errors = append(errors, ErrorResponseError{Message: "event types must be among " + strings.Join(webhookEventTypes, ", "), Field: "eventTypes"})
+			break
+		}
+	}
+	return errors
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// enqueueWebhookDeliveries adds a delivery of the domain event to the webhook
+// outbox for each of the user's webhooks in the organization that subscribes
+// to it. The dispatcher sends them in the background.
+func enqueueWebhookDeliveries(ctx context.Context, event outbox.Event) error {
+	hooks, err := webhookStore.Subscribed(ctx, event.UserID, event.OrgID, event.Type)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		ID:        event.ID,
+		Type:      event.Type,
+		OrgID:     event.OrgID,
+		CreatedAt: event.OccurredAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	deliveries := make([]*domain.WebhookDelivery, 0, len(hooks))
+	for _, hook := range hooks {
+		deliveries = append(deliveries, &domain.WebhookDelivery{
+			ID:            entityid.Generator.Generate(),
+			WebhookID:     hook.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       payload,
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		})
+	}
+	return webhookStore.Enqueue(ctx, deliveries)
+}
+
+// subscribeWebhooks has the relay enqueue webhook deliveries for the domain
+// events webhooks can subscribe to.
+func subscribeWebhooks(relay *outbox.Relay) {
+	for _, eventType := range webhookEventTypes {
+		relay.Subscribe(eventType, enqueueWebhookDeliveries)
+	}
+}
+
+func webhooksRouter(webhooksRouter chi.Router) {
+	webhooksRouter.Use(authenticate)
+	webhooksRouter.Use(idempotent)
+
+	webhooksRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		hooks, err := webhookStore.List(context.Background(), user.ID, user.OrgID)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(hooks)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(bytes)
+	})
+	webhooksRouter.Post("/", func(rw http.ResponseWriter, r *http.Request) {
+		user := requestGetUser(r)
+
+		var input = webhookInput{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&input)
+		if err != nil || input.Enabled != nil {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "invalid input"}},
+			})
+			return
+		}
+
+		secret, err := newWebhookSecret()
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+		webhook := &domain.Webhook{
+			ID:        entityid.Generator.Generate(),
+			UserID:    user.ID,
+			OrgID:     user.OrgID,
+			Secret:    secret,
+			CreatedAt: time.Now(),
+		}
+		if input.URL != nil {
+			webhook.URL = strings.TrimSpace(*input.URL)
+		}
+		if input.EventTypes != nil {
+			webhook.EventTypes = *input.EventTypes
+		}
+		if errors := validateWebhook(webhook); len(errors) > 0 {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+			return
+		}
+
+		err = webhookStore.Create(context.Background(), webhook)
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		bytes, err := json.Marshal(webhookResponse{Webhook: webhook, Secret: webhook.Secret})
+		if err != nil {
+			respondError(rw, http.StatusInternalServerError, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: err.Error()}},
+			})
+			return
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(bytes)
+	})
+
+	webhooksRouter.Route("/{webhookID}", func(webhookRouter chi.Router) {
+		webhookRouter.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			webhook, ok := findWebhook(rw, r)
+			if !ok {
+				return
+			}
+
+			bytes, err := json.Marshal(webhook)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		webhookRouter.Put("/", func(rw http.ResponseWriter, r *http.Request) {
+			webhook, ok := findWebhook(rw, r)
+			if !ok {
+				return
+			}
+
+			var input = webhookInput{}
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&input)
+			if err != nil {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "invalid input"}},
+				})
+				return
+			}
+
+			if input.URL != nil {
+				webhook.URL = strings.TrimSpace(*input.URL)
+			}
+			if input.EventTypes != nil {
+				webhook.EventTypes = *input.EventTypes
+			}
+			// Re-enabling a webhook gives it a fresh start.
+			if input.Enabled != nil && *input.Enabled {
+				webhook.DisabledAt = nil
+				webhook.ConsecutiveFailures = 0
+			} else if input.Enabled != nil && webhook.DisabledAt == nil {
+				now := time.Now()
+				webhook.DisabledAt = &now
+			}
+			if errors := validateWebhook(webhook); len(errors) > 0 {
+				respondError(rw, http.StatusBadRequest, ErrorResponse{Errors: errors})
+				return
+			}
+
+			err = webhookStore.Update(context.Background(), webhook)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			bytes, err := json.Marshal(webhook)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		webhookRouter.Delete("/", func(rw http.ResponseWriter, r *http.Request) {
+			webhook, ok := findWebhook(rw, r)
+			if !ok {
+				return
+			}
+
+			err := webhookStore.Delete(context.Background(), webhook.ID)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusNoContent)
+		})
+		webhookRouter.Get("/deliveries", func(rw http.ResponseWriter, r *http.Request) {
+			webhook, ok := findWebhook(rw, r)
+			if !ok {
+				return
+			}
+
+			limit := 20
+			if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+				var err error
+				limit, err = strconv.Atoi(limitParam)
+				if err != nil || limit < 1 || limit > 100 {
+					respondError(rw, http.StatusBadRequest, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "limit must be between 1 and 100", Field: "limit"}},
+					})
+					return
+				}
+			}
+			var after *domain.WebhookDelivery
+			if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+				var err error
+				after, err = webhookStore.FindDelivery(context.Background(), entityid.ID(cursor))
+				if err == storage.ErrDeliveryNotFound || (err == nil && after.WebhookID != webhook.ID) {
+					respondError(rw, http.StatusBadRequest, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: "invalid cursor", Field: "cursor"}},
+					})
+					return
+				}
+				if err != nil {
+					respondError(rw, http.StatusInternalServerError, ErrorResponse{
+						Errors: []ErrorResponseError{{Message: err.Error()}},
+					})
+					return
+				}
+			}
+
+			// Fetching one extra delivery tells whether there is another page.
+			deliveries, err := webhookStore.ListDeliveries(context.Background(), webhook.ID, after, limit+1)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+			response := webhookDeliveriesResponse{Deliveries: deliveries}
+			if len(deliveries) > limit {
+				response.Deliveries = deliveries[:limit]
+				response.NextCursor = deliveries[limit-1].ID
+			}
+
+			bytes, err := json.Marshal(response)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		webhookRouter.Get("/deliveries/{deliveryID}", func(rw http.ResponseWriter, r *http.Request) {
+			_, delivery, ok := findWebhookDelivery(rw, r)
+			if !ok {
+				return
+			}
+
+			bytes, err := json.Marshal(delivery)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(bytes)
+		})
+		// Redelivering queues a new delivery of the same event, leaving the
+		// log of the original intact.
+		webhookRouter.Post("/deliveries/{deliveryID}/redeliver", func(rw http.ResponseWriter, r *http.Request) {
+			webhook, delivery, ok := findWebhookDelivery(rw, r)
+			if !ok {
+				return
+			}
+			if webhook.DisabledAt != nil {
+				respondError(rw, http.StatusConflict, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: "Webhook is disabled"}},
+				})
+				return
+			}
+
+			now := time.Now()
+			redelivery := &domain.WebhookDelivery{
+				ID:            entityid.Generator.Generate(),
+				WebhookID:     webhook.ID,
+				EventID:       delivery.EventID,
+				EventType:     delivery.EventType,
+				RedeliveryOf:  delivery.ID,
+				Payload:       delivery.Payload,
+				Status:        domain.DeliveryPending,
+				NextAttemptAt: &now,
+				CreatedAt:     now,
+			}
+			err := webhookStore.Enqueue(context.Background(), []*domain.WebhookDelivery{redelivery})
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			bytes, err := json.Marshal(redelivery)
+			if err != nil {
+				respondError(rw, http.StatusInternalServerError, ErrorResponse{
+					Errors: []ErrorResponseError{{Message: err.Error()}},
+				})
+				return
+			}
+
+			rw.WriteHeader(http.StatusAccepted)
+			rw.Write(bytes)
+		})
+	})
+}
+
+// findWebhook finds the signed in user's webhook in the organization they are
+// signed in to.
+func findWebhook(rw http.ResponseWriter, r *http.Request) (*domain.Webhook, bool) {
+	user := requestGetUser(r)
+	webhook, err := webhookStore.Find(context.Background(), entityid.ID(chi.URLParam(r, "webhookID")))
+	if err == storage.ErrWebhookNotFound || (err == nil && (webhook.UserID != user.ID || webhook.OrgID != user.OrgID)) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Webhook not found"}},
+		})
+		return nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, false
+	}
+	return webhook, true
+}
+
+func findWebhookDelivery(rw http.ResponseWriter, r *http.Request) (*domain.Webhook, *domain.WebhookDelivery, bool) {
+	webhook, ok := findWebhook(rw, r)
+	if !ok {
+		return nil, nil, false
+	}
+	delivery, err := webhookStore.FindDelivery(context.Background(), entityid.ID(chi.URLParam(r, "deliveryID")))
+	if err == storage.ErrDeliveryNotFound || (err == nil && delivery.WebhookID != webhook.ID) {
+		respondError(rw, http.StatusNotFound, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: "Delivery not found"}},
+		})
+		return nil, nil, false
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, ErrorResponse{
+			Errors: []ErrorResponseError{{Message: err.Error()}},
+		})
+		return nil, nil, false
+	}
+	return webhook, delivery, true
+}
@@ -0,0 +1,122 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+var ErrNonPublicAddress = errors.New("webhooks: address is not public")
+
+// nonPublicNetworks are the ranges webhooks may not be delivered to on top of
+// loopback, link-local, multicast and unspecified addresses, which net.IP
+// reports itself. Link-local covers the cloud metadata services at
+// 169.254.169.254 and fd00:ec2::254 falls in unique local addresses.
+var nonPublicNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // "this" network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"240.0.0.0/4",    // reserved, including broadcast
+		"64:ff9b::/96",   // NAT64 of IPv4 addresses
+		"fc00::/7",       // unique local
+		"fec0::/10",      // deprecated site-local
+		"2001:db8::/32",  // documentation
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// PublicAddress reports whether webhooks may be delivered to ip: it mustn't
+// reach the service's own host, its private network or its cloud provider's
+// metadata service. IPv4-mapped IPv6 addresses are checked as IPv4.
+func PublicAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolver looks up the addresses of a host.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// CheckHost returns ErrNonPublicAddress unless every address host resolves to
+// is public. Checking at registration rejects obviously internal URLs early;
+// the client returned by NewClient checks again when it connects, since DNS
+// can change in between.
+func CheckHost(ctx context.Context, resolver Resolver, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicAddress(ip) {
+			return ErrNonPublicAddress
+		}
+		return nil
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !PublicAddress(addr.IP) {
+			return ErrNonPublicAddress
+		}
+	}
+	return nil
+}
+
+// NewClient returns a client for delivering webhooks. It refuses to connect
+// to addresses that aren't public, checked on the address actually dialed so
+// a host resolving differently at delivery time can't get around it, and it
+// doesn't follow redirects, which could point anywhere.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicAddress(ip) {
+				return ErrNonPublicAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed in place of the webhook, escaping the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
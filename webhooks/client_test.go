@@ -0,0 +1,99 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"100.100.100.200", false},
+		{"169.254.169.254", false},
+		{"fd00:ec2::254", false},
+		{"::ffff:127.0.0.1", false},
+		{"0.0.0.0", false},
+		{"fe80::1", false},
+	}
+	for _, tt := range tests {
+		if actual := PublicAddress(net.ParseIP(tt.ip)); actual != tt.expected {
+			t.Errorf("PublicAddress(%s) = %v, expected %v", tt.ip, actual, tt.expected)
+		}
+	}
+}
+
+type memoryResolver map[string][]string
+
+func (mr memoryResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := mr[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestCheckHost(t *testing.T) {
+	resolver := memoryResolver{
+		"example.com":    {"93.184.216.34"},
+		"rebound.test":   {"93.184.216.34", "10.0.0.1"},
+		"localhost.test": {"127.0.0.1"},
+	}
+	tests := []struct {
+		host     string
+		expected error
+	}{
+		{"example.com", nil},
+		{"93.184.216.34", nil},
+		{"rebound.test", ErrNonPublicAddress},
+		{"localhost.test", ErrNonPublicAddress},
+		{"169.254.169.254", ErrNonPublicAddress},
+	}
+	for _, tt := range tests {
+		if err := CheckHost(context.Background(), resolver, tt.host); err != tt.expected {
+			t.Errorf("CheckHost(%s) = %v, expected %v", tt.host, err, tt.expected)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	client := NewClient(time.Second)
+
+	t.Run("refuses to connect to loopback", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			t.Error("request reached a loopback server")
+		}))
+		defer server.Close()
+
+		res, err := client.Post(server.URL, "application/json", nil)
+		if err == nil {
+			res.Body.Close()
+		}
+		if !errors.Is(err, ErrNonPublicAddress) {
+			t.Errorf("Post() error = %v, expected %v", err, ErrNonPublicAddress)
+		}
+	})
+	t.Run("doesn't follow redirects", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, "https://example.com/hooks", nil)
+		if err := client.CheckRedirect(req, []*http.Request{req}); err != http.ErrUseLastResponse {
+			t.Errorf("CheckRedirect() = %v, expected %v", err, http.ErrUseLastResponse)
+		}
+	})
+}
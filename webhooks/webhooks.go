@@ -0,0 +1,201 @@
+// Package webhooks sends todo events to the endpoints users registered for
+// them, retrying failed deliveries from a durable outbox.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Sign returns the signature of a delivery sent at timestamp: the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook's
+// secret. Receivers recompute it and reject stale timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns how long to wait before retrying a delivery that has failed
+// attempts times: a minute, doubling with every attempt up to six hours.
+func Backoff(attempts int) time.Duration {
+	const max = 6 * time.Hour
+	backoff := time.Minute
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	return backoff
+}
+
+// ErrLeaseLost is returned by Source.Record when the delivery was claimed
+// again by another dispatcher after the lease ran out.
+var ErrLeaseLost = errors.New("webhooks: delivery was claimed again by another dispatcher")
+
+// Delivery is a delivery along with the webhook it is for.
+type Delivery struct {
+	Webhook  *domain.Webhook
+	Delivery *domain.WebhookDelivery
+}
+
+// Source claims the deliveries that are due and records how they went.
+type Source interface {
+	// Due claims up to limit pending deliveries to enabled webhooks whose
+	// next attempt is due, so that other dispatchers skip them for a while.
+	Due(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+	// Record saves the delivery and its webhook after an attempt, or returns
+	// ErrLeaseLost if another dispatcher claimed it since.
+	Record(ctx context.Context, delivery Delivery) error
+}
+
+type Dispatcher struct {
+	Source Source
+	// Client sends the deliveries. Outside of tests it should come from
+	// NewClient, so webhooks can't reach internal addresses.
+	Client *http.Client
+	// Interval is how often due deliveries are looked for.
+	Interval time.Duration
+	// BatchSize is how many deliveries are claimed at a time.
+	BatchSize int
+	// MaxAttempts is how many times a delivery is tried before it fails.
+	MaxAttempts int
+	// DisableAfter is how many failed attempts in a row disable a webhook.
+	DisableAfter int
+	Now          func() time.Time
+}
+
+// Run sends due deliveries every Interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Tick(ctx); err != nil {
+			log.Printf("webhooks: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+// Tick attempts every delivery that is due, a batch at a time.
+func (d *Dispatcher) Tick(ctx context.Context) error {
+	for {
+		deliveries, err := d.Source.Due(ctx, d.now(), d.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			d.Attempt(ctx, delivery)
+			err := d.Source.Record(ctx, delivery)
+			if err == ErrLeaseLost {
+				log.Printf("webhooks: recording delivery %s: %s", delivery.Delivery.ID, err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(deliveries) < d.BatchSize {
+			return nil
+		}
+	}
+}
+
+// Attempt sends the delivery once and updates it, and its webhook, with the
+// outcome. Any 2xx response counts as delivered.
+func (d *Dispatcher) Attempt(ctx context.Context, delivery Delivery) {
+	webhook, attempt := delivery.Webhook, delivery.Delivery
+	now := d.now()
+	attempt.Attempts++
+	attempt.LastAttemptAt = &now
+
+	status, err := d.send(ctx, webhook, attempt, now)
+	attempt.ResponseStatus = status
+	attempt.Error = ""
+	if err == nil && status >= 200 && status < 300 {
+		attempt.Status = domain.DeliverySucceeded
+		attempt.NextAttemptAt = nil
+		attempt.DeliveredAt = &now
+		webhook.ConsecutiveFailures = 0
+		return
+	}
+
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		attempt.Error = fmt.Sprintf("endpoint responded %d", status)
+	}
+	if attempt.Attempts >= d.MaxAttempts {
+		attempt.Status = domain.DeliveryFailed
+		attempt.NextAttemptAt = nil
+	} else {
+		next := now.Add(Backoff(attempt.Attempts))
+		attempt.NextAttemptAt = &next
+	}
+	webhook.ConsecutiveFailures++
+	if webhook.ConsecutiveFailures >= d.DisableAfter && webhook.DisabledAt == nil {
+		webhook.DisabledAt = &now
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, webhook *domain.Webhook, delivery *domain.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todos-webhooks")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Drain a little of the body so the connection can be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+	return res.StatusCode, nil
+}
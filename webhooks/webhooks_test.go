@@ -0,0 +1,186 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1600000000.{"id":"event"}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=dc17e226a3bd0c4506b382bb3314694cdb19a9bab00bbb5fe87a10cb33f062ca"
+	if got := Sign("secret", 1600000000, []byte(`{"id":"event"}`)); got != expected {
+		t.Errorf("Sign() = %v, expected %v", got, expected)
+	}
+	if Sign("other", 1600000000, []byte(`{"id":"event"}`)) == expected {
+		t.Error("Sign() with another secret matched")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{5, 16 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := Backoff(test.attempts); got != test.expected {
+			t.Errorf("Backoff(%d) = %v, expected %v", test.attempts, got, test.expected)
+		}
+	}
+}
+
+type memorySource struct {
+	deliveries []Delivery
+	recorded   int
+	// lost are the deliveries another dispatcher claimed in the meantime.
+	lost map[string]bool
+}
+
+func (ms *memorySource) Due(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	var due []Delivery
+	for _, d := range ms.deliveries {
+		if d.Delivery.Status != domain.DeliveryPending || d.Webhook.DisabledAt != nil {
+			continue
+		}
+		if d.Delivery.NextAttemptAt != nil && d.Delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		due = append(due, d)
+	}
+	return due, nil
+}
+
+func (ms *memorySource) Record(ctx context.Context, delivery Delivery) error {
+	if ms.lost[delivery.Delivery.ID.String()] {
+		return ErrLeaseLost
+	}
+	ms.recorded++
+	return nil
+}
+
+func TestDispatcher_Tick(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	status := http.StatusOK
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhook := &domain.Webhook{ID: "webhook", URL: server.URL, Secret: "secret"}
+	delivery := &domain.WebhookDelivery{
+		ID:        "delivery",
+		EventType: "todo.created",
+		Payload:   []byte(`{"id":"event"}`),
+		Status:    domain.DeliveryPending,
+	}
+	source := &memorySource{deliveries: []Delivery{{Webhook: webhook, Delivery: delivery}}}
+	dispatcher := &Dispatcher{
+		Source:       source,
+		Client:       server.Client(),
+		BatchSize:    10,
+		MaxAttempts:  3,
+		DisableAfter: 3,
+		Now:          func() time.Time { return now },
+	}
+
+	t.Run("signs deliveries", func(t *testing.T) {
+		status = http.StatusOK
+		if err := dispatcher.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != domain.DeliverySucceeded {
+			t.Fatalf("Status = %v, expected %v", delivery.Status, domain.DeliverySucceeded)
+		}
+		timestamp, _ := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+		if timestamp != now.Unix() {
+			t.Errorf("%s = %d, expected %d", TimestampHeader, timestamp, now.Unix())
+		}
+		if got, expected := received.Header.Get(SignatureHeader), Sign("secret", timestamp, body); got != expected {
+			t.Errorf("%s = %v, expected %v", SignatureHeader, got, expected)
+		}
+		if got := received.Header.Get(EventHeader); got != "todo.created" {
+			t.Errorf("%s = %v, expected %v", EventHeader, got, "todo.created")
+		}
+	})
+	t.Run("retries failed deliveries with backoff", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		delivery.Status = domain.DeliveryPending
+		delivery.Attempts = 0
+
+		if err := dispatcher.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != domain.DeliveryPending || delivery.Attempts != 1 {
+			t.Fatalf("Status, Attempts = %v, %d, expected %v, 1", delivery.Status, delivery.Attempts, domain.DeliveryPending)
+		}
+		if expected := now.Add(time.Minute); !delivery.NextAttemptAt.Equal(expected) {
+			t.Errorf("NextAttemptAt = %v, expected %v", delivery.NextAttemptAt, expected)
+		}
+		if delivery.ResponseStatus != http.StatusInternalServerError {
+			t.Errorf("ResponseStatus = %d, expected %d", delivery.ResponseStatus, http.StatusInternalServerError)
+		}
+
+		recorded := source.recorded
+		if err := dispatcher.Tick(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if source.recorded != recorded {
+			t.Error("Tick() attempted a delivery before its backoff passed")
+		}
+	})
+	t.Run("fails deliveries after MaxAttempts and disables the webhook", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			now = now.Add(time.Hour)
+			if err := dispatcher.Tick(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if delivery.Status != domain.DeliveryFailed {
+			t.Errorf("Status = %v, expected %v", delivery.Status, domain.DeliveryFailed)
+		}
+		if webhook.ConsecutiveFailures != 3 || webhook.DisabledAt == nil {
+			t.Errorf("ConsecutiveFailures, DisabledAt = %d, %v, expected 3, set", webhook.ConsecutiveFailures, webhook.DisabledAt)
+		}
+	})
+}
+
+func TestDispatcher_Tick_leaseLost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	webhook := &domain.Webhook{ID: "webhook", URL: server.URL}
+	source := &memorySource{lost: map[string]bool{"lost": true}}
+	for _, id := range []string{"lost", "kept"} {
+		source.deliveries = append(source.deliveries, Delivery{
+			Webhook:  webhook,
+			Delivery: &domain.WebhookDelivery{ID: entityid.ID(id), Status: domain.DeliveryPending},
+		})
+	}
+	dispatcher := &Dispatcher{Source: source, Client: server.Client(), BatchSize: 10, MaxAttempts: 3, DisableAfter: 3}
+
+	if err := dispatcher.Tick(context.Background()); err != nil {
+		t.Fatalf("Tick() error = %v, expected a lost lease to be skipped", err)
+	}
+	if source.recorded != 1 {
+		t.Errorf("recorded %d deliveries, expected 1", source.recorded)
+	}
+}
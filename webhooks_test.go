@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/DillonStreator/todos/domain"
+)
+
+type memoryResolver map[string]string
+
+func (mr memoryResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := mr[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func Test_validateWebhook(t *testing.T) {
+	webhookResolver = memoryResolver{"example.com": "93.184.216.34", "localhost": "127.0.0.1", "intranet.example.com": "10.0.0.8"}
+	defer func() { webhookResolver = net.DefaultResolver }()
+
+	tests := []struct {
+		url        string
+		eventTypes []string
+		expected   int
+	}{
+		{"https://example.com/hooks", []string{"todo.created", "todo.completed"}, 0},
+		{"http://localhost:8080/hooks", []string{"todo.deleted"}, 1},
+		{"http://127.0.0.1/hooks", []string{"todo.deleted"}, 1},
+		{"http://169.254.169.254/latest/meta-data", []string{"todo.deleted"}, 1},
+		{"http://[::1]/hooks", []string{"todo.deleted"}, 1},
+		{"https://intranet.example.com/hooks", []string{"todo.deleted"}, 1},
+		{"https://unknown.example.com/hooks", []string{"todo.deleted"}, 1},
+		{"ftp://example.com/hooks", []string{"todo.created"}, 1},
+		{"https:///hooks", []string{"todo.created"}, 1},
+		{"https://example.com/hooks", nil, 1},
+		{"https://example.com/hooks", []string{"todo.created", "project.created"}, 1},
+		{"example.com", []string{"todo.exploded"}, 2},
+	}
+
+	for _, test := range tests {
+		errors := validateWebhook(&domain.Webhook{URL: test.url, EventTypes: test.eventTypes})
+		if len(errors) != test.expected {
+			t.Errorf("validateWebhook(%q, %v) = %v, expected %d errors", test.url, test.eventTypes, errors, test.expected)
+		}
+	}
+}
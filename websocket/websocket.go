@@ -0,0 +1,318 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) on top of net/http, enough for exchanging text messages.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake  = errors.New("websocket: bad handshake")
+	ErrMessageTooBig = errors.New("websocket: message too big")
+	ErrProtocol      = errors.New("websocket: protocol error")
+	ErrClosed        = errors.New("websocket: connection closed")
+)
+
+// CloseError is returned by ReadMessage when the peer closes the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: closed with code " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+// Conn is a server side WebSocket connection. Reads must come from a single
+// goroutine; writes are safe from several.
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	subprotocol string
+
+	// MaxMessageSize limits the size of messages read. Larger messages close
+	// the connection.
+	MaxMessageSize int64
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client's key.
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Subprotocols returns the subprotocols the client offered.
+func Subprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// subprotocol is echoed back to the client when not empty and must be one it
+// offered. On failure Upgrade responds with an HTTP error itself.
+func Upgrade(rw http.ResponseWriter, r *http.Request, subprotocol string) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "Expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "WebSockets are not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n"
+	if subprotocol != "" {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	response += "\r\n"
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           conn,
+		reader:         buffered.Reader,
+		subprotocol:    subprotocol,
+		MaxMessageSize: 1 << 20,
+	}, nil
+}
+
+// NewConn wraps an established connection, for tests.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn, reader: bufio.NewReader(conn), MaxMessageSize: 1 << 20}
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// ReadMessage returns the next data message, reassembling fragments. Pings are
+// answered and pongs skipped along the way. onPong, if set, is called for
+// every pong received.
+func (c *Conn) ReadMessage(onPong func()) (int, []byte, error) {
+	var messageType int
+	var message []byte
+	for {
+		final, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, payload, time.Now().Add(10*time.Second)); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if onPong != nil {
+				onPong()
+			}
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.Close(CloseNormal, "")
+			return 0, nil, closeErr
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(ErrProtocol)
+			}
+			messageType = opcode
+		default:
+			return 0, nil, c.fail(ErrProtocol)
+		}
+
+		if int64(len(message)+len(payload)) > c.MaxMessageSize {
+			c.Close(CloseMessageTooBig, "")
+			return 0, nil, ErrMessageTooBig
+		}
+		message = append(message, payload...)
+		if final {
+			return messageType, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	final := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	if header[0]&0x70 != 0 || !masked {
+		// Clients must mask every frame and no extensions are negotiated.
+		return false, 0, nil, c.fail(ErrProtocol)
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]))
+	}
+	if opcode >= CloseMessage && (length > 125 || !final) {
+		return false, 0, nil, c.fail(ErrProtocol)
+	}
+	if length < 0 || length > c.MaxMessageSize {
+		c.Close(CloseMessageTooBig, "")
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return final, opcode, payload, nil
+}
+
+func (c *Conn) fail(err error) error {
+	c.Close(CloseProtocolError, "")
+	return err
+}
+
+// WriteMessage sends a single unfragmented message, giving up at deadline.
+func (c *Conn) WriteMessage(messageType int, data []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFrame(messageType, data, deadline)
+}
+
+func (c *Conn) writeFrame(opcode int, data []byte, deadline time.Time) error {
+	frame := []byte{0x80 | byte(opcode)}
+	switch length := len(data); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, data...)
+
+	c.conn.SetWriteDeadline(deadline)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with the code and reason and closes the
+// connection. Closing an already closed connection does nothing.
+func (c *Conn) Close(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	c.writeFrame(CloseMessage, payload, time.Now().Add(time.Second))
+	return c.conn.Close()
+}
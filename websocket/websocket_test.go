@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	actual := AcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	expected := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if actual != expected {
+		t.Errorf("AcceptKey() = %v, expected %v", actual, expected)
+	}
+}
+
+// clientFrame builds a masked frame as a client would send it.
+func clientFrame(final bool, opcode int, payload []byte) []byte {
+	first := byte(opcode)
+	if final {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestConn(t *testing.T) {
+	t.Run("reads fragmented messages around a ping", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := NewConn(server)
+
+		go func() {
+			client.Write(clientFrame(false, TextMessage, []byte("hello ")))
+			client.Write(clientFrame(true, PingMessage, []byte("ping")))
+			client.Write(clientFrame(true, continuationFrame, []byte("world")))
+		}()
+		pong := make(chan []byte)
+		go func() {
+			buf := make([]byte, 6)
+			n, _ := client.Read(buf)
+			pong <- buf[:n]
+		}()
+
+		messageType, message, err := conn.ReadMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != TextMessage || string(message) != "hello world" {
+			t.Errorf("ReadMessage() = %v, %q, expected %v, %q", messageType, message, TextMessage, "hello world")
+		}
+		if frame := <-pong; frame[0] != 0x80|PongMessage || string(frame[2:]) != "ping" {
+			t.Errorf("pong frame = %v", frame)
+		}
+	})
+	t.Run("writes unmasked frames", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := NewConn(server)
+
+		payload := make([]byte, 300)
+		go conn.WriteMessage(TextMessage, payload, time.Now().Add(time.Second))
+
+		header := make([]byte, 4)
+		if _, err := client.Read(header); err != nil {
+			t.Fatal(err)
+		}
+		if header[0] != 0x80|TextMessage || header[1] != 126 || binary.BigEndian.Uint16(header[2:]) != 300 {
+			t.Errorf("frame header = %v", header)
+		}
+	})
+	t.Run("rejects unmasked client frames", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := NewConn(server)
+
+		go func() {
+			client.Write([]byte{0x80 | TextMessage, 1, 'a'})
+			client.Read(make([]byte, 16))
+		}()
+		if _, _, err := conn.ReadMessage(nil); err != ErrProtocol {
+			t.Errorf("ReadMessage() error = %v, expected %v", err, ErrProtocol)
+		}
+	})
+	t.Run("reports the peer's close", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := NewConn(server)
+
+		go func() {
+			client.Write(clientFrame(true, CloseMessage, []byte{0x03, 0xe8, 'b', 'y', 'e'}))
+			client.Read(make([]byte, 16))
+		}()
+		_, _, err := conn.ReadMessage(nil)
+		closeErr, ok := err.(*CloseError)
+		if !ok || closeErr.Code != CloseNormal || closeErr.Reason != "bye" {
+			t.Errorf("ReadMessage() error = %v, expected close 1000 bye", err)
+		}
+	})
+}
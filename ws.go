@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DillonStreator/todos/domain"
+	"github.com/DillonStreator/todos/entityid"
+	"github.com/DillonStreator/todos/events"
+	"github.com/DillonStreator/todos/jwt"
+	"github.com/DillonStreator/todos/websocket"
+	"github.com/go-chi/chi"
+)
+
+// wsSubprotocol must be offered by clients. The JWT can be offered alongside
+// it as a "bearer.<token>" subprotocol, since browsers can't set headers on a
+// WebSocket handshake; otherwise the first message must be an auth message.
+const wsSubprotocol = "todos.v1"
+
+const wsBearerPrefix = "bearer."
+
+var (
+	// wsSendBuffer is how many messages a client can fall behind before it is
+	// disconnected. It reconnects and resumes from the last event it handled.
+	wsSendBuffer    = 64
+	wsWriteTimeout  = 10 * time.Second
+	wsPingInterval  = 30 * time.Second
+	wsReadTimeout   = 75 * time.Second
+	wsAuthTimeout   = 10 * time.Second
+	wsMutationPaths = []string{"/todos", "/projects", "/tags"}
+)
+
+// wsMutationHeaders are the request headers a mutation may set.
+var wsMutationHeaders = map[string]bool{
+	"Content-Type":    true,
+	"If-Match":        true,
+	"Idempotency-Key": true,
+}
+
+// wsIncoming is a message from a client:
+//
+//	auth        {token} signs in, or refreshes an expiring token.
+//	subscribe   {projectIds, lastEventId} starts receiving the projects'
+//	            events and announces the client as viewing them.
+//	unsubscribe {projectIds}
+//	mutate      {method, path, headers, body} makes an API request as the
+//	            signed in user, for example PATCH /todos/{todoID}.
+//
+// ID is echoed on the reply.
+type wsIncoming struct {
+	Type        string            `json:"type"`
+	ID          string            `json:"id"`
+	Token       string            `json:"token"`
+	ProjectIDs  []entityid.ID     `json:"projectIds"`
+	LastEventID string            `json:"lastEventId"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Headers     map[string]string `json:"headers"`
+	Body        json.RawMessage   `json:"body"`
+}
+
+// wsOutgoing is a message to a client: ready, event, reset, presence, result
+// or error.
+type wsOutgoing struct {
+	Type      string               `json:"type"`
+	ID        string               `json:"id,omitempty"`
+	EventID   string               `json:"eventId,omitempty"`
+	Event     string               `json:"event,omitempty"`
+	ProjectID entityid.ID          `json:"projectId,omitempty"`
+	Viewers   []wsViewer           `json:"viewers,omitempty"`
+	Status    int                  `json:"status,omitempty"`
+	Data      json.RawMessage      `json:"data,omitempty"`
+	Errors    []ErrorResponseError `json:"errors,omitempty"`
+}
+
+type wsViewer struct {
+	UserID entityid.ID `json:"userId"`
+	Email  string      `json:"email"`
+}
+
+// presence tracks which clients connected to this process are viewing each
+// project.
+type presence struct {
+	mu      sync.Mutex
+	viewers map[entityid.ID]map[*wsClient]struct{}
+}
+
+var wsPresence = &presence{viewers: make(map[entityid.ID]map[*wsClient]struct{})}
+
+func (p *presence) join(projectID entityid.ID, client *wsClient) {
+	p.mu.Lock()
+	if p.viewers[projectID] == nil {
+		p.viewers[projectID] = make(map[*wsClient]struct{})
+	}
+	p.viewers[projectID][client] = struct{}{}
+	p.mu.Unlock()
+	p.announce(projectID)
+}
+
+func (p *presence) leave(projectID entityid.ID, client *wsClient) {
+	p.mu.Lock()
+	if _, ok := p.viewers[projectID][client]; !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.viewers[projectID], client)
+	if len(p.viewers[projectID]) == 0 {
+		delete(p.viewers, projectID)
+	}
+	p.mu.Unlock()
+	p.announce(projectID)
+}
+
+// revoke removes a user who lost access to a project from its viewers.
+func (p *presence) revoke(projectID, userID entityid.ID) {
+	p.mu.Lock()
+	var clients []*wsClient
+	for client := range p.viewers[projectID] {
+		if client.user.ID == userID {
+			clients = append(clients, client)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, client := range clients {
+		client.unsubscribe(wsIncoming{ProjectIDs: []entityid.ID{projectID}})
+	}
+}
+
+// announce sends the project's viewers to every client viewing it. A user
+// with several tabs open is listed once.
+func (p *presence) announce(projectID entityid.ID) {
+	p.mu.Lock()
+	seen := make(map[entityid.ID]bool)
+	viewers := make([]wsViewer, 0)
+	var clients []*wsClient
+	for client := range p.viewers[projectID] {
+		clients = append(clients, client)
+		if !seen[client.user.ID] {
+			seen[client.user.ID] = true
+			viewers = append(viewers, wsViewer{UserID: client.user.ID, Email: client.user.Email})
+		}
+	}
+	p.mu.Unlock()
+
+	sort.Slice(viewers, func(i, j int) bool { return viewers[i].Email < viewers[j].Email })
+	for _, client := range clients {
+		client.enqueue(wsOutgoing{Type: "presence", ProjectID: projectID, Viewers: viewers})
+	}
+}
+
+type wsClient struct {
+	conn       *websocket.Conn
+	api        http.Handler
+	remoteAddr string
+	send       chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	mu           sync.Mutex
+	user         *domain.User
+	token        string
+	projects     map[entityid.ID]bool
+	subscription *events.Subscription
+}
+
+// enqueue queues a message for the client, disconnecting it if it has fallen
+// too far behind.
+func (c *wsClient) enqueue(message wsOutgoing) {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	select {
+	case c.send <- bytes:
+	case <-c.done:
+	default:
+		c.close(websocket.CloseTryAgainLater, "client is too slow")
+	}
+}
+
+// reject tells the client why it is being disconnected and disconnects it.
+func (c *wsClient) reject(message wsOutgoing) {
+	if bytes, err := json.Marshal(message); err == nil {
+		c.conn.WriteMessage(websocket.TextMessage, bytes, time.Now().Add(wsWriteTimeout))
+	}
+	c.close(websocket.ClosePolicyViolation, "not authorized")
+}
+
+func (c *wsClient) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close(code, reason)
+	})
+}
+
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			err = c.conn.WriteMessage(websocket.TextMessage, message, time.Now().Add(wsWriteTimeout))
+		case <-ping.C:
+			err = c.conn.WriteMessage(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		}
+		if err != nil {
+			c.close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+// eventLoop forwards the events of the projects the client subscribed to in
+// the organization it is signed in to. A client whose token has expired
+// without being refreshed is disconnected instead; it reconnects with a new
+// token and resumes from the last event it handled.
+func (c *wsClient) eventLoop(subscription *events.Subscription) {
+	forward := func(event events.Event) {
+		c.mu.Lock()
+		subscribed := c.projects[event.ProjectID]
+		user, token := c.user, c.token
+		c.mu.Unlock()
+		if _, err := jwt.Verify(token); err != nil {
+			c.reject(wsOutgoing{Type: "error", Status: http.StatusUnauthorized, Errors: []ErrorResponseError{{Message: err.Error(), Field: "token"}}})
+			return
+		}
+		if subscribed && inEventOrg(user, event) {
+			c.enqueue(wsOutgoing{Type: "event", EventID: event.ID, Event: event.Type, ProjectID: event.ProjectID, Data: event.Data})
+		}
+	}
+
+	if subscription.Reset {
+		c.enqueue(wsOutgoing{Type: "reset"})
+	}
+	for _, event := range subscription.Replay {
+		forward(event)
+	}
+	for {
+		select {
+		case <-c.done:
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				c.close(websocket.CloseTryAgainLater, "client is too slow")
+				return
+			}
+			forward(event)
+		}
+	}
+}
+
+func (c *wsClient) authenticate(token string) []ErrorResponseError {
+	user, _, err := signedInUser(token)
+	if err != nil {
+		return []ErrorResponseError{{Message: err.Error(), Field: "token"}}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.user != nil && c.user.ID != user.ID {
+		return []ErrorResponseError{{Message: "token is for a different user", Field: "token"}}
+	}
+	// Subscriptions are to projects in the organization the connection signed
+	// in to, so a refreshed token must stay in it.
+	if c.user != nil && c.user.OrgID != user.OrgID {
+		return []ErrorResponseError{{Message: "token is for a different organization", Field: "token"}}
+	}
+	c.user = user
+	c.token = token
+	return nil
+}
+
+func (c *wsClient) subscribe(message wsIncoming) []ErrorResponseError {
+	// Reload the user so projects created since the connection opened can
+	// be subscribed to.
+	user, _, err := signedInUser(c.token)
+	if err != nil {
+		return []ErrorResponseError{{Message: err.Error(), Field: "token"}}
+	}
+	// Only projects in the organization the token selects can be subscribed
+	// to: the user's own there, or ones shared with them in it.
+	for _, projectID := range message.ProjectIDs {
+		if project := user.Projects.FindByID(projectID); project.ID != "" && project.OrgID == user.OrgID {
+			continue
+		}
+		if shareStore != nil {
+			if _, err := shareStore.OrgMembership(context.Background(), projectID, user.ID, user.OrgID); err == nil {
+				continue
+			}
+		}
+		return []ErrorResponseError{{Message: "unknown project " + projectID.String(), Field: "projectIds"}}
+	}
+
+	c.mu.Lock()
+	for _, projectID := range message.ProjectIDs {
+		c.projects[projectID] = true
+	}
+	var subscription *events.Subscription
+	if c.subscription == nil {
+		subscription = eventBroker.Subscribe(user.ID, message.LastEventID)
+		c.subscription = subscription
+	}
+	c.mu.Unlock()
+
+	if subscription != nil {
+		go c.eventLoop(subscription)
+	}
+	for _, projectID := range message.ProjectIDs {
+		wsPresence.join(projectID, c)
+	}
+	return nil
+}
+
+func (c *wsClient) unsubscribe(message wsIncoming) {
+	c.mu.Lock()
+	for _, projectID := range message.ProjectIDs {
+		delete(c.projects, projectID)
+	}
+	c.mu.Unlock()
+	for _, projectID := range message.ProjectIDs {
+		wsPresence.leave(projectID, c)
+	}
+}
+
+// mutate makes the API request described by the message as the signed in
+// user, so mutations over the socket are validated, recorded and published
+// exactly like those made over HTTP.
+func (c *wsClient) mutate(message wsIncoming) wsOutgoing {
+	reply := wsOutgoing{Type: "result", ID: message.ID}
+	method := strings.ToUpper(message.Method)
+	if method != http.MethodPost && method != http.MethodPut && method != http.MethodPatch && method != http.MethodDelete {
+		reply.Errors = []ErrorResponseError{{Message: "method must be one of POST, PUT, PATCH, DELETE", Field: "method"}}
+		return reply
+	}
+	allowed := false
+	for _, prefix := range wsMutationPaths {
+		if message.Path == prefix || strings.HasPrefix(message.Path, prefix+"/") || strings.HasPrefix(message.Path, prefix+"?") {
+			allowed = true
+		}
+	}
+	if !allowed {
+		reply.Errors = []ErrorResponseError{{Message: "path must be a todos, projects or tags route", Field: "path"}}
+		return reply
+	}
+
+	r, err := http.NewRequest(method, message.Path, bytes.NewReader(message.Body))
+	if err != nil {
+		reply.Errors = []ErrorResponseError{{Message: err.Error(), Field: "path"}}
+		return reply
+	}
+	for name, value := range message.Headers {
+		if !wsMutationHeaders[http.CanonicalHeaderKey(name)] {
+			reply.Errors = []ErrorResponseError{{Message: "header " + name + " can't be set", Field: "headers"}}
+			return reply
+		}
+		r.Header.Set(name, value)
+	}
+	if r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	c.mu.Lock()
+	r.Header.Set("Authorization", c.token)
+	c.mu.Unlock()
+	r.RemoteAddr = c.remoteAddr
+
+	recorder := httptest.NewRecorder()
+	c.api.ServeHTTP(recorder, r)
+
+	reply.Status = recorder.Code
+	if body := recorder.Body.Bytes(); json.Valid(body) {
+		reply.Data = body
+	}
+	return reply
+}
+
+func registerWebSocketRoutes(r chi.Router, api http.Handler) {
+	r.Get("/ws", func(rw http.ResponseWriter, r *http.Request) {
+		var token string
+		offered := false
+		for _, protocol := range websocket.Subprotocols(r) {
+			if protocol == wsSubprotocol {
+				offered = true
+			}
+			if strings.HasPrefix(protocol, wsBearerPrefix) {
+				token = strings.TrimPrefix(protocol, wsBearerPrefix)
+			}
+		}
+		if !offered {
+			respondError(rw, http.StatusBadRequest, ErrorResponse{
+				Errors: []ErrorResponseError{{Message: "the " + wsSubprotocol + " subprotocol must be offered"}},
+			})
+			return
+		}
+
+		conn, err := websocket.Upgrade(rw, r, wsSubprotocol)
+		if err != nil {
+			return
+		}
+		client := &wsClient{
+			conn:       conn,
+			api:        api,
+			remoteAddr: r.RemoteAddr,
+			send:       make(chan []byte, wsSendBuffer),
+			done:       make(chan struct{}),
+			projects:   make(map[entityid.ID]bool),
+		}
+		go client.writeLoop()
+		defer func() {
+			client.close(websocket.CloseNormal, "")
+			client.mu.Lock()
+			var projectIDs []entityid.ID
+			for projectID := range client.projects {
+				projectIDs = append(projectIDs, projectID)
+			}
+			if client.subscription != nil {
+				client.subscription.Close()
+			}
+			client.mu.Unlock()
+			for _, projectID := range projectIDs {
+				wsPresence.leave(projectID, client)
+			}
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+		if token != "" {
+			if errors := client.authenticate(token); len(errors) > 0 {
+				client.reject(wsOutgoing{Type: "error", Status: http.StatusUnauthorized, Errors: errors})
+				return
+			}
+			client.enqueue(wsOutgoing{Type: "ready"})
+			conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		}
+
+		extendDeadline := func() { conn.SetReadDeadline(time.Now().Add(wsReadTimeout)) }
+		for {
+			_, data, err := conn.ReadMessage(extendDeadline)
+			if err != nil {
+				return
+			}
+
+			var message wsIncoming
+			if err := json.Unmarshal(data, &message); err != nil {
+				client.enqueue(wsOutgoing{Type: "error", Status: http.StatusBadRequest, Errors: []ErrorResponseError{{Message: err.Error()}}})
+				continue
+			}
+
+			client.mu.Lock()
+			authenticated := client.user != nil
+			client.mu.Unlock()
+			if !authenticated && message.Type != "auth" {
+				client.reject(wsOutgoing{Type: "error", ID: message.ID, Status: http.StatusUnauthorized, Errors: []ErrorResponseError{{Message: "Not authorized"}}})
+				return
+			}
+			extendDeadline()
+
+			switch message.Type {
+			case "auth":
+				if errors := client.authenticate(message.Token); len(errors) > 0 {
+					if !authenticated {
+						client.reject(wsOutgoing{Type: "error", ID: message.ID, Status: http.StatusUnauthorized, Errors: errors})
+						return
+					}
+					client.enqueue(wsOutgoing{Type: "error", ID: message.ID, Status: http.StatusUnauthorized, Errors: errors})
+					continue
+				}
+				client.enqueue(wsOutgoing{Type: "ready", ID: message.ID})
+			case "subscribe":
+				if errors := client.subscribe(message); len(errors) > 0 {
+					client.enqueue(wsOutgoing{Type: "error", ID: message.ID, Status: http.StatusBadRequest, Errors: errors})
+					continue
+				}
+				client.enqueue(wsOutgoing{Type: "result", ID: message.ID, Status: http.StatusOK})
+			case "unsubscribe":
+				client.unsubscribe(message)
+				client.enqueue(wsOutgoing{Type: "result", ID: message.ID, Status: http.StatusOK})
+			case "mutate":
+				client.enqueue(client.mutate(message))
+			default:
+				client.enqueue(wsOutgoing{Type: "error", ID: message.ID, Status: http.StatusBadRequest, Errors: []ErrorResponseError{{Message: "unknown message type", Field: "type"}}})
+			}
+		}
+	})
+}